@@ -0,0 +1,47 @@
+//go:build linux
+
+package icmptest
+
+import (
+	"fmt"
+	"net"
+	"syscall"
+)
+
+// bindToDevice applies SO_BINDTODEVICE to conn so that its packets only use the named
+// interface (or, for a VRF master device, that VRF's routing table).
+func bindToDevice(conn *net.IPConn, name string) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptString(int(fd), syscall.SOL_SOCKET, syscall.SO_BINDTODEVICE, name)
+	}); err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("SO_BINDTODEVICE %s: %v", name, sockErr)
+	}
+	return nil
+}
+
+// setMark applies SO_MARK to conn so that fwmark policy routing rules match its packets.
+// Setting a mark requires CAP_NET_ADMIN.
+func setMark(conn *net.IPConn, mark uint32) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_MARK, int(mark))
+	}); err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("SO_MARK %#x: %v", mark, sockErr)
+	}
+	return nil
+}
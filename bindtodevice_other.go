@@ -0,0 +1,18 @@
+//go:build !linux
+
+package icmptest
+
+import (
+	"fmt"
+	"net"
+)
+
+// bindToDevice is only implemented on Linux, where SO_BINDTODEVICE exists.
+func bindToDevice(conn *net.IPConn, name string) error {
+	return fmt.Errorf("bind_to_device is only supported on Linux")
+}
+
+// setMark is only implemented on Linux, where SO_MARK exists.
+func setMark(conn *net.IPConn, mark uint32) error {
+	return fmt.Errorf("fwmark is only supported on Linux")
+}
@@ -0,0 +1,141 @@
+package icmptest
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/2matzzz/icmp-test/config"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+)
+
+// runBroadcastTest sends one echo request to a broadcast or multicast destination and
+// collects echo replies from every host that answers within test.Timeout. The test passes
+// if at least test.MinResponders distinct hosts replied, or, for an expected timeout, if
+// none did.
+func runBroadcastTest(ctx context.Context, cfg *config.Config, test Test) TestResult {
+	result := newTestResult(cfg, test)
+
+	fail := func(format string, args ...interface{}) TestResult {
+		result.Status = "FAILED"
+		result.Details = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	pconn, err := openPacketConn(cfg, test)
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer pconn.Close()
+
+	dst, resolveTime, err := test.resolve(ctx, cfg)
+	result.ResolveTime = resolveTime
+	if err != nil {
+		return fail("[error] test name: %s, %v", test.Name, err)
+	}
+
+	cm := &ipv4.ControlMessage{
+		IfIndex: cfg.General.Interface.Index,
+		Src:     cfg.General.SourceIPAddress,
+	}
+
+	b, err := buildICMPPacket(test)
+	if err != nil {
+		return fail("[error] test name: %s, %v", test.Name, err)
+	}
+
+	// Unblock ReadFrom as soon as the suite context is done.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			pconn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	start := time.Now()
+	n, err := pconn.WriteTo(b, cm, dst)
+	if err != nil {
+		return fail("WriteTo error: %v", err)
+	}
+	if err := checkWrite(n, len(b), dst); err != nil {
+		return fail("%v", err)
+	}
+	ttl, _ := pconn.MulticastTTL()
+	if !dst.IP.IsMulticast() {
+		ttl, _ = pconn.TTL()
+	}
+	recordSent(ctx, cfg, test, dst, ttl, b)
+	result.PacketsSent = 1
+	result.RequestSize, result.BytesSent = len(b), len(b)
+	if err := pconn.SetReadDeadline(start.Add(test.Timeout)); err != nil {
+		return fail("SetReadDeadline error: %v", err)
+	}
+
+	// Every host answers the same request, so replies are told apart by source address.
+	// Only echo replies count: a copy of the request may be looped back to this socket.
+	seen := make(map[string]bool)
+	rtts := newRTTSamples(test.maxRTTSamples)
+	resp := make([]byte, 1500)
+	for ctx.Err() == nil {
+		n, rcm, peer, err := pconn.ReadFrom(resp)
+		if err != nil {
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				break
+			}
+			if ctx.Err() != nil {
+				break
+			}
+			return fail("ReadFrom error: %v", err)
+		}
+		elapsed := time.Since(start)
+		recordReceived(ctx, cfg, test, peer, rcm, resp[:n])
+		parsedMsg, err := icmp.ParseMessage(1, resp[:n])
+		if err != nil || parsedMsg.Type != ipv4.ICMPTypeEchoReply || !matchesProbe(parsedMsg, test) {
+			continue
+		}
+		if seen[peer.String()] {
+			continue
+		}
+		seen[peer.String()] = true
+		result.Responders = append(result.Responders, peer.String())
+		if rtts.count() == 0 {
+			result.FirstReplyRTT = elapsed
+		}
+		rtts.add(elapsed)
+		result.ReplySize = n
+		result.RawReply = rawReply(cfg, resp[:n])
+		result.BytesReceived += n
+	}
+	result.Duration = time.Since(start)
+	if ctx.Err() != nil {
+		result.ActualResult = "interrupted"
+		return fail("interrupted: %v", ctx.Err())
+	}
+
+	setRTTStats(&result, rtts)
+	result.ActualResult = fmt.Sprintf("%d responders", len(result.Responders))
+	summary := fmt.Sprintf("%d responders within %v", len(result.Responders), test.Timeout)
+	if len(result.Responders) > 0 {
+		summary += ": " + strings.Join(result.Responders, ", ")
+	}
+
+	if test.ExpectedResult == "timeout" {
+		if len(result.Responders) > 0 {
+			return fail("expected timeout, but got %s", summary)
+		}
+	} else if len(result.Responders) < test.MinResponders {
+		return fail("expected at least %d responders, but got %s", test.MinResponders, summary)
+	}
+	if err := checkRTTLimits(result, test); err != nil {
+		return fail("%v (%s)", err, summary)
+	}
+	result.Status = "PASSED"
+	result.Details = summary
+	return result
+}
@@ -0,0 +1,400 @@
+// Command icmp-test runs the ICMP test suites described by YAML configuration files
+// and reports the results. The tests themselves are implemented by package icmptest.
+package main
+
+import (
+	"compress/gzip"
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	icmptest "github.com/2matzzz/icmp-test"
+	"github.com/2matzzz/icmp-test/config"
+	"github.com/2matzzz/icmp-test/output"
+)
+
+// logLevel controls the verbosity of logger; it is set from the -log-level flag.
+var logLevel = new(slog.LevelVar)
+
+// nagiosMode is set by -nagios; fatal errors then exit with the plugin's UNKNOWN code.
+var nagiosMode bool
+
+// logger writes diagnostics to stderr so that results on stdout stay machine-readable.
+var logger = slog.New(slog.NewTextHandler(os.Stderr, &slog.HandlerOptions{Level: logLevel}))
+
+func main() {
+	var configFilePaths stringList
+	flag.Var(&configFilePaths, "config", "Path to YAML test configuration file; repeat to merge several (default config.yaml)")
+	interfaceFlag := flag.String("interface", "", "Network interface to send from (overrides general.interface_name)")
+	sourceIPFlag := flag.String("source-ip", "", "Source IP address (overrides general.source_ip)")
+	pcapPath := flag.String("pcap", "", "Write all sent and received packets to this pcap file")
+	seqStartFlag := flag.Int("seq-start", -1, "ICMP sequence number of the first probe (0-65535); random per run if unset")
+	allowFlood := flag.Bool("allow-flood", false, "Allow tests with mode: \"flood\" to run")
+	allowSpoof := flag.Bool("allow-spoof", false, "Allow general.spoof_source (lab use only)")
+	dryRun := flag.Bool("dry-run", false, "Build packets for every test and print them without sending anything")
+	logLevelFlag := flag.String("log-level", "info", "Log verbosity on stderr: debug, info, warn or error")
+	suiteTimeout := flag.Duration("suite-timeout", 0, "Overall time budget for the whole suite (overrides general.suite_timeout)")
+	repeat := flag.Int("repeat", 1, "Run the whole suite this many times and report per-test pass counts")
+	initConfig := flag.Bool("init", false, "Write a commented example config to stdout (or the path given as argument) and exit")
+	schema := flag.Bool("schema", false, "Print a JSON Schema describing the config file and exit")
+	seed := flag.Int64("seed", 0, "Seed for random start_jitter delays and -shuffle; random per run if 0")
+	shuffle := flag.Bool("shuffle", false, "Start tests in a random order (seeded by -seed) to flush out order-dependent failures; results stay in config order")
+	list := flag.Bool("list", false, "Print the tests that would run, after expansion and defaults, and exit")
+	serial := flag.Bool("serial", false, "Run tests one at a time in config order (overrides general.parallelism), for reproducible runs")
+	failFast := flag.Bool("fail-fast", false, "Stop the suite at the first failed test; remaining tests are reported as SKIPPED")
+	outputPath := flag.String("o", "", "Write results in the general.output format to this file instead of stdout")
+	jsonCompact := flag.Bool("json-compact", false, "Write results as single-line JSON (overrides general.output; same as output: \"json-compact\")")
+	gzipOutput := flag.Bool("gzip", false, "Gzip-compress the -o file (implied by a .gz suffix)")
+	reportPath := flag.String("report", "", "Also write all results and a summary as JSON to this file, whatever the output format")
+	dumpConfig := flag.Bool("dump-config", false, "Print the effective configuration, after merging, defaults and interface resolution, as JSON and exit")
+	nagios := flag.Bool("nagios", false, "Run as a Nagios/Icinga plugin: print one summary line with performance data and exit 0 (OK), 1 (WARNING), 2 (CRITICAL) or 3 (UNKNOWN)")
+	nagiosWarning := flag.Int("nagios-warning", 1, "With -nagios: failed tests from which the state is WARNING")
+	nagiosCritical := flag.Int("nagios-critical", 1, "With -nagios: failed tests from which the state is CRITICAL")
+	quiet := flag.Bool("quiet", false, "Do not show the progress line on stderr")
+	maxRTTSamples := flag.Int("max-rtt-samples", 0, "Keep at most this many RTT samples per test for percentiles, estimating them beyond (0 = keep all)")
+	defaultCountFlag := flag.Int("count", 0, "Probe count for tests that set neither count nor deadline, e.g. to sample latency across the whole suite.\n"+
+		"Tests limited to one probe (mode, retries, linger, expected_result unreachable or a list) keep it. Probes go their\n"+
+		"interval apart (default "+config.DefaultInterval+") and each waits up to its probe timeout (see -timeout), so a test\n"+
+		"takes up to (count+1) × timeout + (count-1) × interval, the extra timeout waiting for late replies (collect_grace)")
+	defaultTimeoutFlag := flag.Duration("timeout", 0, "Per-probe timeout for tests that set neither timeout nor probe_timeout (default "+config.DefaultTimeout+")")
+	flag.Parse()
+	nagiosMode = *nagios
+
+	if *initConfig {
+		w := io.Writer(os.Stdout)
+		if path := flag.Arg(0); path != "" {
+			f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0o644)
+			if err != nil {
+				fatalf("example config create error: %v", err)
+			}
+			defer f.Close()
+			w = f
+		}
+		if err := config.WriteExampleConfig(w); err != nil {
+			fatalf("example config write error: %v", err)
+		}
+		return
+	}
+	if *schema {
+		if err := config.WriteConfigSchema(os.Stdout); err != nil {
+			fatalf("schema write error: %v", err)
+		}
+		return
+	}
+	level, err := parseLogLevel(*logLevelFlag)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	logLevel.Set(level)
+	icmptest.SetLogLevel(level)
+	slog.SetDefault(logger) // package output logs through the default logger
+
+	if *maxRTTSamples < 0 {
+		fatalf("invalid -max-rtt-samples %d: must be non-negative", *maxRTTSamples)
+	}
+	if *repeat < 1 {
+		fatalf("invalid -repeat %d: must be at least 1", *repeat)
+	}
+	if *nagiosWarning < 1 {
+		fatalf("invalid -nagios-warning %d: must be at least 1", *nagiosWarning)
+	}
+	if *nagiosCritical < *nagiosWarning {
+		fatalf("invalid -nagios-critical %d: must be at least -nagios-warning (%d)", *nagiosCritical, *nagiosWarning)
+	}
+
+	seqStart := -1 // random per run
+	if *seqStartFlag >= 0 {
+		if *seqStartFlag > 0xffff {
+			fatalf("invalid -seq-start %d: must be between 0 and 65535", *seqStartFlag)
+		}
+		seqStart = *seqStartFlag
+	}
+
+	if len(configFilePaths) == 0 {
+		configFilePaths = stringList{"config.yaml"}
+	}
+	cfg, err := config.LoadConfigsWithOverrides(configFilePaths,
+		config.Overrides{InterfaceName: *interfaceFlag, SourceIP: *sourceIPFlag})
+	if err != nil {
+		fatalf("config load error: %v", err)
+	}
+	if *suiteTimeout > 0 {
+		cfg.General.SuiteTimeout = *suiteTimeout
+	}
+	if *serial {
+		cfg.General.Parallelism = 1
+	}
+	if *jsonCompact {
+		cfg.General.Output = "json-compact"
+	}
+	if *defaultTimeoutFlag < 0 {
+		fatalf("invalid -timeout %v: must be positive", *defaultTimeoutFlag)
+	}
+	if *defaultTimeoutFlag > 0 {
+		if err := config.ApplyDefaultTimeout(cfg, *defaultTimeoutFlag); err != nil {
+			fatalf("invalid -timeout: %v", err)
+		}
+	}
+	if *defaultCountFlag != 0 {
+		if err := config.ApplyDefaultCount(cfg, *defaultCountFlag); err != nil {
+			fatalf("invalid -count: %v", err)
+		}
+	}
+
+	if *dumpConfig {
+		if err := config.WriteConfigJSON(os.Stdout, cfg); err != nil {
+			fatalf("%v", err)
+		}
+		return
+	}
+
+	if *list {
+		if err := icmptest.ListTests(os.Stdout, cfg, *allowFlood); err != nil {
+			fatalf("list write error: %v", err)
+		}
+		return
+	}
+
+	if !*dryRun && !icmptest.HasRawSocketPrivilege() {
+		logger.Warn("insufficient privileges for raw ICMP sockets; tests will fail to open a socket",
+			"remedy", icmptest.PrivilegeRemedy)
+	}
+
+	// Results go to out; logs stay on stderr. The file is created before the run so that
+	// a bad path fails fast instead of after every test has run.
+	out := io.Writer(os.Stdout)
+	var outFile *os.File
+	var gz *gzip.Writer
+	if *gzipOutput && *outputPath == "" {
+		fatalf("-gzip requires -o")
+	}
+	if *outputPath != "" {
+		outFile, err = os.Create(*outputPath)
+		if err != nil {
+			fatalf("output file create error: %v", err)
+		}
+		out = outFile
+		if *gzipOutput || strings.HasSuffix(*outputPath, ".gz") {
+			gz = gzip.NewWriter(outFile)
+			out = gz
+		}
+	}
+
+	if *pcapPath != "" {
+		f, err := os.Create(*pcapPath)
+		if err != nil {
+			fatalf("pcap file create error: %v", err)
+		}
+		defer f.Close()
+		if err := icmptest.CapturePackets(f); err != nil {
+			fatalf("%v", err)
+		}
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+	logger.Debug("random seed", "seed", *seed)
+	opts := icmptest.RunOptions{DryRun: *dryRun, AllowFlood: *allowFlood, AllowSpoof: *allowSpoof, Rand: rand.New(rand.NewSource(*seed)), FailFast: *failFast,
+		Shuffle: *shuffle, Seqs: icmptest.NewSeqAllocator(seqStart), DNS: icmptest.NewDNSCache(cfg.General.DNSCacheTTL), MaxRTTSamples: *maxRTTSamples}
+	// The progress line is only for a person watching a terminal; it is cleared before
+	// any results are written so that it never mixes with them, and log lines are
+	// written through it so that they do not run into it either.
+	var progress *progressLine
+	if !*quiet && isTerminal(os.Stderr) {
+		progress = &progressLine{w: os.Stderr}
+		opts.Progress = progress.update
+		icmptest.SetLogOutput(progress)
+		logger = slog.New(slog.NewTextHandler(progress, &slog.HandlerOptions{Level: logLevel}))
+		slog.SetDefault(logger)
+	}
+	results, err := icmptest.RunWithOptions(context.Background(), *cfg, opts)
+	if err != nil {
+		fatalf("%v", err)
+	}
+	runCount := 1
+	if *repeat > 1 {
+		runs := [][]icmptest.TestResult{results}
+		for run := 2; run <= *repeat && !(opts.FailFast && output.Summarize(results).Failed > 0); run++ {
+			logger.Info("starting suite run", "run", run, "of", *repeat)
+			results, err = icmptest.RunWithOptions(context.Background(), *cfg, opts)
+			if err != nil {
+				fatalf("%v", err)
+			}
+			runs = append(runs, results)
+		}
+		results = icmptest.AggregateRuns(runs)
+		runCount = len(runs)
+	}
+	progress.clear()
+	allPassed := true
+
+	// Check if any test failed. SKIPPED and DRY-RUN results count as neither pass nor fail.
+	for _, res := range results {
+		if res.Status == "FAILED" || res.Status == "FLAKY" {
+			allPassed = false
+			break
+		}
+	}
+
+	// フィルタリング処理
+	filteredResults := results
+	if len(cfg.General.ResultFilter) > 0 {
+		tmp := []icmptest.TestResult{}
+		for _, res := range results {
+			for _, status := range cfg.General.ResultFilter {
+				if res.Status == status {
+					tmp = append(tmp, res)
+					break
+				}
+			}
+		}
+		filteredResults = tmp
+	}
+
+	// Output the results. LoadConfigs only accepts registered formats. With -nagios,
+	// stdout carries only the plugin line, so results are written only to an -o file.
+	if !*nagios || *outputPath != "" {
+		formatter, _ := output.Lookup(cfg.General.Output)
+		if err := formatter.Format(out, filteredResults, output.Summarize(results)); err != nil {
+			fatalf("output write error: %v", err)
+		}
+		if cfg.General.Output == "text" && *repeat > 1 {
+			if err := output.WriteRepeatSummary(out, results, runCount); err != nil {
+				fatalf("output write error: %v", err)
+			}
+		}
+	}
+	// Closing gz writes the gzip trailer; without it the file would be truncated.
+	if gz != nil {
+		if err := gz.Close(); err != nil {
+			fatalf("output file close error: %v", err)
+		}
+	}
+	if outFile != nil {
+		if err := outFile.Close(); err != nil {
+			fatalf("output file close error: %v", err)
+		}
+	}
+
+	if *reportPath != "" {
+		if err := output.WriteReport(*reportPath, results); err != nil {
+			fatalf("report write error: %v", err)
+		}
+	}
+
+	if cfg.General.PushURL != "" {
+		if err := output.PushResults(context.Background(), cfg.General.PushURL, results); err != nil {
+			fatalf("push error: %v", err)
+		}
+	}
+	if cfg.General.WebhookURL != "" {
+		if err := output.NotifyResults(context.Background(), cfg.General.WebhookURL, cfg.General.NotifyOn, results,
+			output.NotifyLimit{StateFile: cfg.General.NotifyStateFile, Interval: cfg.General.NotifyInterval}); err != nil {
+			fatalf("webhook error: %v", err)
+		}
+	}
+
+	if *nagios {
+		code, err := output.WriteNagios(os.Stdout, results,
+			output.NagiosThresholds{Warning: *nagiosWarning, Critical: *nagiosCritical})
+		if err != nil {
+			fatalf("output write error: %v", err)
+		}
+		os.Exit(code)
+	}
+
+	// If any test has FAILED, exit with a nonzero exit code.
+	if !allPassed {
+		os.Exit(1)
+	}
+}
+
+// progressLine shows "completed X / Y (Z failed)" on a terminal, rewriting one line in
+// place. A nil *progressLine does nothing.
+type progressLine struct {
+	mu    sync.Mutex
+	w     io.Writer
+	shown bool
+	text  string // The line last shown
+}
+
+func (p *progressLine) update(completed, total, failed int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.text = fmt.Sprintf("completed %d / %d (%d failed)", completed, total, failed)
+	fmt.Fprint(p.w, "\r\033[K"+p.text)
+	p.shown = true
+}
+
+// Write writes b, a log record, above the progress line: the line is erased first and
+// shown again after b.
+func (p *progressLine) Write(b []byte) (int, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.shown {
+		fmt.Fprint(p.w, "\r\033[K")
+	}
+	n, err := p.w.Write(b)
+	if p.shown {
+		fmt.Fprint(p.w, p.text)
+	}
+	return n, err
+}
+
+// clear erases the progress line, if one is shown.
+func (p *progressLine) clear() {
+	if p == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.shown {
+		fmt.Fprint(p.w, "\r\033[K")
+		p.shown = false
+	}
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	fi, err := f.Stat()
+	return err == nil && fi.Mode()&os.ModeCharDevice != 0
+}
+
+// stringList is a repeatable string flag.
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// fatalf logs an error-level message and exits with a nonzero status.
+func fatalf(format string, args ...interface{}) {
+	logger.Error(fmt.Sprintf(format, args...))
+	if nagiosMode {
+		fmt.Printf("UNKNOWN - %s\n", fmt.Sprintf(format, args...))
+		os.Exit(output.NagiosUnknown)
+	}
+	os.Exit(1)
+}
+
+// parseLogLevel converts a -log-level value (debug, info, warn, error) into a slog.Level.
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return 0, fmt.Errorf("invalid log level %q: must be one of debug, info, warn, error", s)
+	}
+	return level, nil
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"testing"
+)
+
+// TestParseLogLevel verifies that -log-level values map to slog levels and invalid values are rejected.
+func TestParseLogLevel(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected slog.Level
+		err      bool
+	}{
+		{"debug", slog.LevelDebug, false},
+		{"info", slog.LevelInfo, false},
+		{"warn", slog.LevelWarn, false},
+		{"error", slog.LevelError, false},
+		{"verbose", 0, true},
+	}
+	for _, tc := range tests {
+		got, err := parseLogLevel(tc.input)
+		if (err != nil) != tc.err {
+			t.Errorf("parseLogLevel(%q) error = %v, wantErr %v", tc.input, err, tc.err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("parseLogLevel(%q) = %v, want %v", tc.input, got, tc.expected)
+		}
+	}
+}
+
+// TestProgressLine verifies that the progress line is rewritten in place and cleared.
+func TestProgressLine(t *testing.T) {
+	var buf bytes.Buffer
+	p := &progressLine{w: &buf}
+	p.update(1, 3, 0)
+	p.update(2, 3, 1)
+	p.clear()
+	want := "\r\033[Kcompleted 1 / 3 (0 failed)\r\033[Kcompleted 2 / 3 (1 failed)\r\033[K"
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+
+	// Without an update there is nothing to clear, and a nil line does nothing.
+	buf.Reset()
+	p.clear()
+	var none *progressLine
+	none.clear()
+	if buf.Len() != 0 {
+		t.Errorf("clear without a shown line wrote %q", buf.String())
+	}
+}
+
+// TestProgressLineWrite verifies that a log line written while the progress line is shown
+// replaces it and the progress line is shown again beneath it.
+func TestProgressLineWrite(t *testing.T) {
+	var buf bytes.Buffer
+	p := &progressLine{w: &buf}
+	p.Write([]byte("before\n"))
+	p.update(1, 3, 0)
+	p.Write([]byte("level=INFO msg=\"test finished\"\n"))
+	p.clear()
+	p.Write([]byte("after\n"))
+	want := "before\n\r\033[Kcompleted 1 / 3 (0 failed)\r\033[Klevel=INFO msg=\"test finished\"\ncompleted 1 / 3 (0 failed)\r\033[Kafter\n"
+	if got := buf.String(); got != want {
+		t.Errorf("output = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,12 @@
+//go:build !linux
+
+package config
+
+// BindToDeviceSupported reports whether bind_to_device and vrf can be used on this platform.
+const BindToDeviceSupported = false
+
+// RXTimestampSupported reports whether rx_timestamp can be used on this platform.
+const RXTimestampSupported = false
+
+// FWMarkSupported reports whether fwmark can be used on this platform.
+const FWMarkSupported = false
@@ -0,0 +1,1214 @@
+// Package config loads and validates icmp-test configuration files. LoadConfigs merges
+// the files, applies defaults and resolves the source interface; package icmptest then
+// turns each TestInput into a test and runs it.
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	defaultOutput      = "text"
+	defaultParallelism = 1
+	defaultTOS         = 0
+	defaultSetDFBit    = false
+
+	defaultResolveTimeout = 5 * time.Second
+	defaultMaxTimeout     = 10 * time.Second
+	defaultNotifyInterval = time.Hour
+
+	minRcvBuf = 4 << 10  // Smaller buffers cannot hold a handful of replies
+	maxRcvBuf = 64 << 20 // Far beyond what any kernel grants unprivileged sockets
+
+	maxReaders = 64 // More goroutines than this only contend for the socket
+)
+
+// Defaults of the per-test settings, applied when a test is built from its TestInput.
+const (
+	DefaultTimeout     = "1s"
+	DefaultPayloadSize = 32
+	DefaultCount       = 1
+	DefaultInterval    = "1s"
+)
+
+// MaxRetries is the largest retries value a test may set.
+const MaxRetries = 10
+
+// GeneralConfig holds the effective general settings, after defaults and interface
+// resolution.
+type GeneralConfig struct {
+	Output                string `yaml:"output"`      // A registered format: "text", "json", "csv", ...
+	Parallelism           int    `yaml:"parallelism"` // Number of tests to run concurrently
+	TOS                   int    `yaml:"tos"`
+	InterfaceName         string `yaml:"interface_name"` // Network interface name
+	Interface             net.Interface
+	SourceIPAddressString string `yaml:"source_ip"` // Source IP address
+	SourceIPAddress       net.IP
+	ResultFilter          []string      `yaml:"result_filter"`
+	SetDFBit              bool          `yaml:"set_df_bit"`        // Set Don't Fragment bit in IP header
+	SuiteTimeout          time.Duration `yaml:"suite_timeout"`     // Overall time budget for the whole suite (0 = unlimited)
+	BindToDevice          bool          `yaml:"bind_to_device"`    // Bind sockets to Interface with SO_BINDTODEVICE (Linux only)
+	VRF                   string        `yaml:"vrf"`               // Bind sockets to this VRF master device instead (Linux only)
+	MaxPerDest            int           `yaml:"max_per_dest"`      // Tests allowed to run concurrently against one destination (0 = unlimited)
+	StartJitter           time.Duration `yaml:"start_jitter"`      // Maximum random delay before each test's first send (0 = none)
+	PushURL               string        `yaml:"push_url"`          // Collector that receives the results after the run (http(s), tcp or unix URL)
+	WebhookURL            string        `yaml:"webhook_url"`       // Chat webhook notified after the run about results in NotifyOn
+	NotifyOn              []string      `yaml:"notify_on"`         // Statuses that trigger a webhook message (default FAILED)
+	NotifyStateFile       string        `yaml:"notify_state_file"` // Remembers when each test was last notified, across runs ("" = no limit)
+	NotifyInterval        time.Duration `yaml:"notify_interval"`   // Minimum time before a test still matching NotifyOn is notified again
+	Source                string        `yaml:"source"`            // "default", or "all": tests without their own source run from every IPv4 address of Interface
+	ResolveTimeout        time.Duration `yaml:"resolve_timeout"`   // Time allowed for resolving each destination name
+	DNSCacheTTL           time.Duration `yaml:"dns_cache_ttl"`     // How long resolved destination names are reused (0 = resolve every time)
+	MaxTimeout            time.Duration `yaml:"max_timeout"`       // Largest per-probe timeout a test may set
+	RcvBuf                int           `yaml:"rcvbuf"`            // Socket receive buffer size in bytes (0 = OS default)
+	Readers               int           `yaml:"readers"`           // Flood mode: goroutines draining the test's socket (0 = 1)
+	SpoofSource           net.IP        `yaml:"spoof_source"`      // Lab use: source address written into requests' IP headers (nil = off)
+	DontRoute             bool          `yaml:"dont_route"`        // Set SO_DONTROUTE: send only to directly connected destinations
+	RXTimestamp           bool          `yaml:"rx_timestamp"`      // Measure RTTs to SO_TIMESTAMPNS receive timestamps (Linux only)
+	FWMark                uint32        `yaml:"fwmark"`            // SO_MARK set on sockets for policy routing (0 = none; Linux only)
+
+	IncludeRawReply bool `yaml:"include_raw_reply"` // Keep the bytes of the (last) reply in results as raw_reply
+
+	Labels map[string]string `yaml:"labels"` // Added to every result's annotations; a test's own annotations win on conflict
+}
+
+// Config defines the YAML configuration structure.
+type Config struct {
+	General GeneralConfig `yaml:"general" json:"general"`
+	Tests   []TestInput   `yaml:"tests" json:"tests"`
+}
+
+type inputGeneralConfig struct {
+	Output                *string   `yaml:"output"`      // A registered format: "text", "json", "csv", ...
+	Parallelism           *int      `yaml:"parallelism"` // Number of tests to run concurrently
+	TOS                   *string   `yaml:"tos"`
+	InterfaceName         *string   `yaml:"interface_name"`  // Network interface name
+	SourceIPAddressString *string   `yaml:"source_ip"`       // Source IP address
+	SourceSubnet          *string   `yaml:"source_subnet"`   // Pick the interface address within this CIDR
+	SourceIPIndex         *int      `yaml:"source_ip_index"` // Pick the n-th (0-based) IPv4 address of interface_name
+	ResultFilter          *[]string `yaml:"result_filter"`
+	SetDFBit              *bool     `yaml:"set_df_bit"`        // Set Don't Fragment bit in IP header
+	SuiteTimeout          *string   `yaml:"suite_timeout"`     // Overall time budget for the whole suite (e.g., "60s")
+	BindToDevice          *bool     `yaml:"bind_to_device"`    // Bind sockets to the interface with SO_BINDTODEVICE (Linux only)
+	VRF                   *string   `yaml:"vrf"`               // Bind sockets to this VRF master device (Linux only)
+	MaxPerDest            *int      `yaml:"max_per_dest"`      // Tests allowed to run concurrently against one destination
+	StartJitter           *string   `yaml:"start_jitter"`      // Maximum random delay before each test's first send (e.g., "50ms")
+	PushURL               *string   `yaml:"push_url"`          // Collector that receives the results after the run
+	WebhookURL            *string   `yaml:"webhook_url"`       // Chat webhook notified after the run (http or https)
+	NotifyOn              *[]string `yaml:"notify_on"`         // Statuses that trigger a webhook message (default ["FAILED"])
+	NotifyStateFile       *string   `yaml:"notify_state_file"` // File that remembers when each test was last notified
+	NotifyInterval        *string   `yaml:"notify_interval"`   // Minimum time before the same test is notified again (default "1h")
+	Source                *string   `yaml:"source"`            // "default" or "all" (every IPv4 address of the interface)
+	ResolveTimeout        *string   `yaml:"resolve_timeout"`   // Time allowed for resolving each destination name (default "5s")
+	DNSCacheTTL           *string   `yaml:"dns_cache_ttl"`     // How long resolved destination names are reused across tests and runs (e.g., "5m")
+	MaxTimeout            *string   `yaml:"max_timeout"`       // Largest per-probe timeout a test may set (default "10s")
+	RcvBuf                *int      `yaml:"rcvbuf"`            // Socket receive buffer size in bytes (default: OS default)
+	Readers               *int      `yaml:"readers"`           // Flood mode: goroutines reading replies from the test's socket (default 1)
+	SpoofSource           *string   `yaml:"spoof_source"`      // Lab use: send requests from this address, which the host need not own
+	DontRoute             *bool     `yaml:"dont_route"`        // Bypass gateways with SO_DONTROUTE; sending to a destination not on a connected subnet fails
+	RXTimestamp           *bool     `yaml:"rx_timestamp"`      // End RTTs at the kernel's receive timestamp instead of when the reply is read (Linux only)
+	FWMark                *string   `yaml:"fwmark"`            // Mark requests with SO_MARK so that fwmark rules pick their routing table (e.g., "0x100"; Linux only)
+
+	IncludeRawReply *bool `yaml:"include_raw_reply"` // Add the received ICMP message, base64-encoded, to JSON results as raw_reply
+
+	Labels map[string]string `yaml:"labels"` // Metadata for every result (e.g. site: "dc1")
+}
+
+type inputConfig struct {
+	General inputGeneralConfig `yaml:"general"`
+	Tests   []TestInput        `yaml:"tests"`
+}
+
+// TestInput defines the structure for a single test scenario.
+type TestInput struct {
+	Name            string             `yaml:"name"`            // Test name
+	Destination     string             `yaml:"-"`               // Destination IP address (set by LoadConfig from Destinations)
+	Destinations    DestinationList    `yaml:"dest"`            // One destination, or a list expanded into one test each
+	DestFile        *string            `yaml:"dest_file"`       // File listing destinations one per line, expanded like a dest list
+	RequestType     string             `yaml:"request_type"`    // Request type ("echo", "timestamp" or "raw")
+	ExpectedResult  string             `yaml:"-"`               // Expected results joined by "|" (set by LoadConfig from ExpectedResults)
+	ExpectedResults ExpectedResultList `yaml:"expected_result"` // "response", "timeout" or "unreachable", or a list of them of which any passes
+	ExpectedCode    *string            `yaml:"expected_code"`   // "unreachable" tests: Destination Unreachable code by name (e.g. "admin-prohibited") or number (default: any)
+	Timeout         *string            `yaml:"timeout"`         // Per-probe timeout (e.g., "2s"); same as probe_timeout
+	ProbeTimeout    *string            `yaml:"probe_timeout"`   // How long to wait for each probe's reply (e.g., "1s")
+	PayloadSize     *int               `yaml:"payload_size"`    // ICMP echo payload size in bytes
+	PayloadPreset   *string            `yaml:"payload_preset"`  // Echo data of a ping implementation: "icmp-test" (default), "linux-ping" or "windows-ping"
+	ID              *int               `yaml:"id"`              // ICMP identifier (0-65535; default: process ID)
+	Source          *string            `yaml:"source"`          // "default" or "all"; overrides general.source
+	SourceIP        net.IP             `yaml:"-"`               // Source address of this test (set by LoadConfig for source "all"); nil = general source
+
+	ICMPType    *int    `yaml:"icmp_type"`    // Raw requests: ICMP type (0-255)
+	ICMPCode    *int    `yaml:"icmp_code"`    // Raw requests: ICMP code (0-255, default 0)
+	Payload     *string `yaml:"payload"`      // Raw requests: body after ID/Seq, hex-encoded (e.g. "deadbeef")
+	PayloadFile *string `yaml:"payload_file"` // Raw requests: file whose contents are the body after ID/Seq
+
+	SetDFBit            *bool   `yaml:"set_df_bit"`            // Overrides general.set_df_bit for this test
+	FailOnFragmentation *bool   `yaml:"fail_on_fragmentation"` // Fail before sending if the payload would be fragmented
+	VerifySource        *bool   `yaml:"verify_source"`         // Fail if the reply's source differs from the destination
+	VerifyChecksum      *bool   `yaml:"verify_checksum"`       // Echo requests: end the payload with a CRC32 and fail if a reply's does not match
+	TTL                 *int    `yaml:"ttl"`                   // IP TTL of requests (1-255; system default if unset)
+	Linger              *string `yaml:"linger"`                // Single-probe tests: keep listening this long after a timeout and note late replies
+	Retries             *int    `yaml:"retries"`               // Single-probe "response" tests: resends after a timeout or transient ICMP error (default 0)
+	CollectGrace        *string `yaml:"collect_grace"`         // Multi-probe tests: keep listening this long after the last probe and count late replies (e.g., "500ms"; default probe_timeout)
+
+	Count      *int    `yaml:"count"`        // Number of probes to send (default 1)
+	Warmup     *int    `yaml:"warmup"`       // Probes sent and discarded before the counted ones (count > 1 only)
+	Deadline   *string `yaml:"deadline"`     // Stop sending probes after this long, even if count is not reached (e.g., "10s")
+	StopOnLoss *bool   `yaml:"stop_on_loss"` // Stop at the first unanswered probe and report the probes answered before it
+	Interval   *string `yaml:"interval"`     // Delay between probes when count > 1 (e.g., "200ms")
+	MaxJitter  *string `yaml:"max_jitter"`   // Fail if jitter across probes exceeds this (e.g., "5ms")
+	MaxP99     *string `yaml:"max_p99"`      // Fail if the 99th percentile RTT exceeds this (e.g., "50ms")
+
+	AssertRTTBelow       *string `yaml:"assert_rtt_below"`       // Fail unless the RTT is below this (e.g., "20ms")
+	AssertRTTStat        *string `yaml:"assert_rtt_stat"`        // Statistic checked by assert_rtt_below for count > 1 (default "avg")
+	AssertLossBelow      *string `yaml:"assert_loss_below"`      // Packet loss allowed for count > 1 and flood tests (e.g., "10%")
+	MaxUnexpectedReplies *int    `yaml:"max_unexpected_replies"` // "timeout" tests with count > 1 or flood: replies tolerated (default 0)
+	AssertTTLMin         *int    `yaml:"assert_ttl_min"`         // Fail if a reply arrives with a lower IP TTL (e.g., 60)
+	AssertTTLMax         *int    `yaml:"assert_ttl_max"`         // Fail if a reply arrives with a higher IP TTL (e.g., 64)
+
+	DependsOn []string `yaml:"depends_on"` // Names of tests that must pass first; otherwise this test is SKIPPED
+	Skip      bool     `yaml:"skip"`       // Do not run this test; report it as SKIPPED
+	SkipIf    []string `yaml:"skip_if"`    // Environment predicates (e.g. "no_ipv6", "not_root") that skip the test when true
+
+	Mode          *string `yaml:"mode"`           // "" (default), "flood" or "broadcast"
+	Rate          *int    `yaml:"rate"`           // Flood mode: packets per second (0 = as fast as possible)
+	Duration      *string `yaml:"duration"`       // Flood mode: how long to send (required, at most 60s)
+	MinResponders *int    `yaml:"min_responders"` // Broadcast mode: distinct hosts that must reply (default 1); several destinations: how many must pass
+
+	Annotations map[string]string `yaml:"annotations"` // Arbitrary metadata (e.g. ticket: "NET-123") copied verbatim into the test's results
+
+	groupName string // Name of the config entry this test was expanded from, if any
+}
+
+// DestinationList holds the value of a test's dest field, which may be a single
+// string or a list of strings in YAML.
+type DestinationList []string
+
+// UnmarshalYAML accepts both a scalar and a sequence for dest.
+func (d *DestinationList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*d = DestinationList{single}
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return fmt.Errorf("dest must be a string or a list of strings: %w", err)
+	}
+	*d = list
+	return nil
+}
+
+// ExpectedResultList holds the value of a test's expected_result field, which may be a
+// single string or a list of strings in YAML.
+type ExpectedResultList []string
+
+// UnmarshalYAML accepts both a scalar and a sequence for expected_result.
+func (e *ExpectedResultList) UnmarshalYAML(unmarshal func(interface{}) error) error {
+	var single string
+	if err := unmarshal(&single); err == nil {
+		*e = ExpectedResultList{single}
+		return nil
+	}
+	var list []string
+	if err := unmarshal(&list); err != nil {
+		return fmt.Errorf("expected_result must be a string or a list of strings: %w", err)
+	}
+	*e = list
+	return nil
+}
+
+// ProbeCount returns the number of probes the test sends, including warmup probes and
+// retries, and hence the number of sequence numbers it needs. Invalid values count as one.
+func (t TestInput) ProbeCount() int {
+	n := 1
+	if t.Count != nil && *t.Count > 1 {
+		n = *t.Count
+	} else if t.Count == nil && t.Deadline != nil {
+		interval := DefaultInterval
+		if t.Interval != nil {
+			interval = *t.Interval
+		}
+		d, err1 := time.ParseDuration(*t.Deadline)
+		i, err2 := time.ParseDuration(interval)
+		if err1 == nil && err2 == nil && d > 0 && i > 0 {
+			n = DeadlineCount(d, i)
+		}
+	}
+	if t.Warmup != nil && *t.Warmup > 0 {
+		n += *t.Warmup
+	}
+	if t.Retries != nil && *t.Retries > 0 {
+		n += *t.Retries
+	}
+	return n
+}
+
+// DeadlineCount returns the number of probes sent interval apart that start within deadline.
+func DeadlineCount(deadline, interval time.Duration) int {
+	return int(deadline/interval) + 1
+}
+
+// BaseName returns the name the test was given in the config, before any expansion;
+// all tests expanded from one config entry share it.
+func (t TestInput) BaseName() string {
+	if t.groupName != "" {
+		return t.groupName
+	}
+	return t.Name
+}
+
+// validateDestination checks that dest is an IP address or a syntactically valid
+// hostname (RFC 1123). Names made only of numeric labels, such as "8.8.8", are
+// rejected as malformed IPv4 addresses.
+func validateDestination(dest string) error {
+	if dest == "" {
+		return fmt.Errorf("dest is empty")
+	}
+	if net.ParseIP(dest) != nil {
+		return nil
+	}
+	name := strings.TrimSuffix(dest, ".")
+	if len(name) == 0 || len(name) > 253 {
+		return fmt.Errorf("invalid dest %q: not an IP address or hostname", dest)
+	}
+	allNumeric := true
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+			return fmt.Errorf("invalid dest %q: not an IP address or hostname", dest)
+		}
+		for _, c := range label {
+			switch {
+			case c >= '0' && c <= '9':
+			case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c == '-':
+				allNumeric = false
+			default:
+				return fmt.Errorf("invalid dest %q: not an IP address or hostname", dest)
+			}
+		}
+	}
+	if allNumeric {
+		return fmt.Errorf("invalid dest %q: malformed IP address", dest)
+	}
+	return nil
+}
+
+// readDestFile returns the destinations listed in path, one per line. Blank lines and
+// text after "#" are ignored; every destination is validated like dest.
+func readDestFile(path string) (DestinationList, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var dests DestinationList
+	for i, line := range strings.Split(string(data), "\n") {
+		if j := strings.IndexByte(line, '#'); j >= 0 {
+			line = line[:j]
+		}
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		if err := validateDestination(line); err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", path, i+1, err)
+		}
+		dests = append(dests, line)
+	}
+	if len(dests) == 0 {
+		return nil, fmt.Errorf("%s lists no destinations", path)
+	}
+	return dests, nil
+}
+
+// expandDestinations returns one test per destination of each input test. Tests with a
+// single destination keep their name; multi-destination tests get the destination appended.
+func expandDestinations(tests []TestInput) []TestInput {
+	var expanded []TestInput
+	for _, t := range tests {
+		if len(t.Destinations) <= 1 {
+			if len(t.Destinations) == 1 {
+				t.Destination = t.Destinations[0]
+			}
+			expanded = append(expanded, t)
+			continue
+		}
+		for _, dest := range t.Destinations {
+			sub := t
+			sub.Name = fmt.Sprintf("%s (%s)", t.Name, dest)
+			sub.Destination = dest
+			sub.Destinations = DestinationList{dest}
+			sub.groupName = t.Name
+			expanded = append(expanded, sub)
+		}
+	}
+	return expanded
+}
+
+// sourceModes are the values of source: "default" sends from the general source address,
+// "all" once from each IPv4 address of the interface.
+var sourceModes = []string{"default", "all"}
+
+// expandSources returns one test per IPv4 address of the interface for each test whose
+// source, or else general.source, is "all"; other tests are returned unchanged. Expanded
+// tests get the source address appended to their name.
+func expandSources(tests []TestInput, general GeneralConfig) ([]TestInput, error) {
+	var expanded []TestInput
+	for i, t := range tests {
+		mode := general.Source
+		if t.Source != nil {
+			if !slices.Contains(sourceModes, *t.Source) {
+				return nil, errorf(fmt.Sprintf("tests[%d].source", i), "test %q: invalid source value: %s. It must be 'default' or 'all'", t.Name, *t.Source)
+			}
+			mode = *t.Source
+		}
+		if mode != "all" {
+			expanded = append(expanded, t)
+			continue
+		}
+		addrs, err := ipv4Addrs(general.Interface, nil)
+		if err != nil {
+			return nil, &Error{Field: fmt.Sprintf("tests[%d].source", i), Reason: fmt.Sprintf("test %q: %v", t.Name, err), Err: err}
+		}
+		if len(addrs) == 0 {
+			return nil, &Error{Field: fmt.Sprintf("tests[%d].source", i),
+				Reason: fmt.Sprintf("test %q: source all: interface %s has no IPv4 address", t.Name, general.Interface.Name), Err: ErrNoIPv4}
+		}
+		for _, ip := range addrs {
+			sub := t
+			sub.Name = fmt.Sprintf("%s (from %s)", t.Name, ip)
+			sub.SourceIP = ip
+			sub.groupName = t.BaseName()
+			expanded = append(expanded, sub)
+		}
+	}
+	return expanded, nil
+}
+
+// ExpectedResults lists the values of expected_result.
+var ExpectedResults = []string{"response", "timeout", "unreachable"}
+
+// PayloadPresets lists the values of payload_preset.
+var PayloadPresets = []string{"icmp-test", "linux-ping", "windows-ping"}
+
+// annotationKey matches valid annotation keys. They are restricted to label-safe names
+// so that every output, including metric labels, can carry them unchanged.
+var annotationKey = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// RTTStatistics lists the values of assert_rtt_stat.
+var RTTStatistics = []string{"min", "avg", "max", "p50", "p95", "p99"}
+
+// skipPredicates are the conditions accepted in skip_if. Each returns a reason when the
+// test should be skipped in the current environment.
+var skipPredicates = map[string]func() (string, bool){
+	"no_ipv6": func() (string, bool) {
+		if hostHasIPv6() {
+			return "", false
+		}
+		return "skipped: no non-loopback IPv6 address on this host (skip_if: no_ipv6)", true
+	},
+	"not_root": func() (string, bool) {
+		if os.Geteuid() == 0 {
+			return "", false
+		}
+		return "skipped: not running as root (skip_if: not_root)", true
+	},
+}
+
+// EvaluateSkipIf returns the reason for the first skip_if predicate that holds.
+func EvaluateSkipIf(predicates []string) (string, bool) {
+	for _, name := range predicates {
+		if pred, ok := skipPredicates[name]; ok {
+			if reason, skip := pred(); skip {
+				return reason, true
+			}
+		}
+	}
+	return "", false
+}
+
+// hostHasIPv6 reports whether any interface has a global (non-loopback, non-link-local) IPv6 address.
+func hostHasIPv6() bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() != nil {
+			continue
+		}
+		if !ipnet.IP.IsLoopback() && !ipnet.IP.IsLinkLocalUnicast() {
+			return true
+		}
+	}
+	return false
+}
+
+// ResolveDependencies maps each test's depends_on names to test indices. A name matches
+// every test expanded from the test with that name (see expandDestinations).
+func ResolveDependencies(tests []TestInput) ([][]int, error) {
+	byName := make(map[string][]int)
+	for i, t := range tests {
+		byName[t.BaseName()] = append(byName[t.BaseName()], i)
+	}
+	deps := make([][]int, len(tests))
+	for i, t := range tests {
+		for _, name := range t.DependsOn {
+			indices, ok := byName[name]
+			if !ok {
+				return nil, fmt.Errorf("test %q depends on unknown test %q", t.Name, name)
+			}
+			deps[i] = append(deps[i], indices...)
+		}
+	}
+	return deps, nil
+}
+
+// OrderTests returns test indices ordered so that every test follows its dependencies,
+// keeping config order among tests whose dependencies are satisfied. It fails on circular dependencies.
+func OrderTests(tests []TestInput, deps [][]int) ([]int, error) {
+	pending := make([]int, len(deps)) // number of unplaced dependencies
+	dependents := make([][]int, len(deps))
+	for i, d := range deps {
+		pending[i] = len(d)
+		for _, j := range d {
+			dependents[j] = append(dependents[j], i)
+		}
+	}
+
+	order := make([]int, 0, len(deps))
+	placed := make([]bool, len(deps))
+	for len(order) < len(deps) {
+		next := -1
+		for i := range deps {
+			if !placed[i] && pending[i] == 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			var cyclic []string
+			for i := range deps {
+				if !placed[i] {
+					cyclic = append(cyclic, tests[i].Name)
+				}
+			}
+			return nil, fmt.Errorf("circular dependency among tests: %q", cyclic)
+		}
+		placed[next] = true
+		order = append(order, next)
+		for _, k := range dependents[next] {
+			pending[k]--
+		}
+	}
+	return order, nil
+}
+
+// LoadConfig reads and validates the configuration file at path.
+func LoadConfig(path string) (*Config, error) {
+	return LoadConfigs([]string{path})
+}
+
+// LoadConfigs reads and merges several configuration files in order: their tests are
+// appended, and each general setting present in a later file overrides earlier ones.
+func LoadConfigs(paths []string) (*Config, error) {
+	return LoadConfigsWithOverrides(paths, Overrides{})
+}
+
+// Overrides replaces general settings of the loaded files, e.g. from command-line flags.
+// They are applied after merging and before defaults and interface resolution, so they
+// are validated exactly like configured values. Empty fields leave the files' values.
+type Overrides struct {
+	// InterfaceName replaces interface_name. Unless SourceIP is also set, the files'
+	// source_ip is dropped, since it belongs to the interface being replaced.
+	InterfaceName string
+	// SourceIP replaces source_ip, and drops source_subnet and source_ip_index.
+	SourceIP string
+}
+
+// apply writes the set fields of o into input.
+func (o Overrides) apply(input *inputConfig) {
+	if o.InterfaceName != "" {
+		input.General.InterfaceName = &o.InterfaceName
+		input.General.SourceIPAddressString = nil
+	}
+	if o.SourceIP != "" {
+		input.General.SourceIPAddressString = &o.SourceIP
+		input.General.SourceSubnet = nil
+		input.General.SourceIPIndex = nil
+	}
+}
+
+// LoadConfigsWithOverrides is LoadConfigs with general settings replaced by overrides.
+func LoadConfigsWithOverrides(paths []string, overrides Overrides) (*Config, error) {
+	var input inputConfig
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("config file read error: %w", err)
+		}
+
+		var file inputConfig
+		if err := yaml.Unmarshal(data, &file); err != nil {
+			return nil, fmt.Errorf("YAML unmarshal error in %s: %w", path, err)
+		}
+		mergeInputConfig(&input, file)
+	}
+	overrides.apply(&input)
+	return buildConfig(input)
+}
+
+// mergeInputConfig layers src onto dst: general settings set in src replace those in
+// dst, and src's tests are appended after dst's.
+func mergeInputConfig(dst *inputConfig, src inputConfig) {
+	d := reflect.ValueOf(&dst.General).Elem()
+	s := reflect.ValueOf(src.General)
+	for i := 0; i < s.NumField(); i++ {
+		if f := s.Field(i); !f.IsZero() {
+			d.Field(i).Set(f)
+		}
+	}
+	dst.Tests = append(dst.Tests, src.Tests...)
+}
+
+var (
+	outputFormatsMu sync.RWMutex
+	outputFormats   = make(map[string]bool)
+)
+
+// RegisterOutputFormat makes name a valid general.output value. Package output calls it
+// for every registered Formatter.
+func RegisterOutputFormat(name string) {
+	outputFormatsMu.Lock()
+	defer outputFormatsMu.Unlock()
+	outputFormats[name] = true
+}
+
+// OutputFormats returns the valid general.output values in sorted order.
+func OutputFormats() []string {
+	outputFormatsMu.RLock()
+	defer outputFormatsMu.RUnlock()
+	names := make([]string, 0, len(outputFormats))
+	for name := range outputFormats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func isOutputFormat(name string) bool {
+	outputFormatsMu.RLock()
+	defer outputFormatsMu.RUnlock()
+	return outputFormats[name]
+}
+
+// ErrNoTests is returned by LoadConfig when the configuration defines no tests.
+var ErrNoTests = errors.New("no test scenarios found")
+
+// Error describes an invalid configuration setting. Field is the YAML key it
+// concerns, e.g. "general.tos" or "tests[2].dest" (test indexes are after dest lists
+// are expanded), or "general" for the interface and source address settings taken
+// together. Reason is the complete message; Err is the underlying error, if any.
+type Error struct {
+	Field  string
+	Reason string
+	Err    error
+}
+
+func (e *Error) Error() string {
+	return e.Reason
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// errorf returns an *Error for field with a formatted Reason.
+func errorf(field, format string, args ...interface{}) *Error {
+	return &Error{Field: field, Reason: fmt.Sprintf(format, args...)}
+}
+
+// buildConfig validates input and applies defaults. Invalid settings are reported as
+// *Error, a missing tests list as ErrNoTests.
+func buildConfig(input inputConfig) (*Config, error) {
+	var cfg Config
+	var err error
+
+	if input.General.Output == nil {
+		// Allocate memory for the pointer and set the default value.
+		cfg.General.Output = defaultOutput
+	} else {
+		// Check for valid values.
+		if !isOutputFormat(*input.General.Output) {
+			return nil, errorf("general.output", "invalid output value: %s. It must be one of %s", *input.General.Output, strings.Join(quoteAll(OutputFormats()), ", "))
+		}
+		// Use the provided output.
+		cfg.General.Output = *input.General.Output
+	}
+
+	if input.General.Parallelism == nil || *input.General.Parallelism <= 0 {
+		cfg.General.Parallelism = defaultParallelism
+	} else {
+		cfg.General.Parallelism = *input.General.Parallelism
+	}
+
+	if input.General.TOS == nil {
+		cfg.General.TOS = defaultTOS
+	} else {
+		tosValue, err := strconv.ParseInt(*input.General.TOS, 0, 8)
+		if err != nil || tosValue < 0 || tosValue > 255 {
+			return nil, errorf("general.tos", "invalid TOS value in general configuration: %s. It must be a number or hex string (like '0x00') between 0 and 255", *input.General.TOS)
+		}
+		cfg.General.TOS = int(tosValue)
+	}
+
+	cfg.General.Interface, cfg.General.SourceIPAddress, err = determineNetworkInterfaceAndIPAddress(input)
+	if err != nil {
+		return nil, &Error{Field: "general", Reason: err.Error(), Err: err}
+	}
+
+	if input.General.ResultFilter != nil {
+		cfg.General.ResultFilter = *input.General.ResultFilter
+	}
+
+	if input.General.SetDFBit == nil {
+		cfg.General.SetDFBit = defaultSetDFBit
+	} else {
+		cfg.General.SetDFBit = *input.General.SetDFBit
+	}
+
+	if input.General.SuiteTimeout != nil {
+		suiteTimeout, err := time.ParseDuration(*input.General.SuiteTimeout)
+		if err != nil || suiteTimeout < 0 {
+			return nil, errorf("general.suite_timeout", "invalid suite_timeout value: %s. It must be a non-negative duration (like '60s')", *input.General.SuiteTimeout)
+		}
+		cfg.General.SuiteTimeout = suiteTimeout
+	}
+
+	cfg.General.ResolveTimeout = defaultResolveTimeout
+	if input.General.ResolveTimeout != nil {
+		resolveTimeout, err := time.ParseDuration(*input.General.ResolveTimeout)
+		if err != nil || resolveTimeout <= 0 {
+			return nil, errorf("general.resolve_timeout", "invalid resolve_timeout value: %s. It must be a positive duration (like '2s')", *input.General.ResolveTimeout)
+		}
+		cfg.General.ResolveTimeout = resolveTimeout
+	}
+
+	cfg.General.MaxTimeout = defaultMaxTimeout
+	if input.General.MaxTimeout != nil {
+		maxTimeout, err := time.ParseDuration(*input.General.MaxTimeout)
+		if err != nil || maxTimeout <= 0 {
+			return nil, errorf("general.max_timeout", "invalid max_timeout value: %s. It must be a positive duration (like '30s')", *input.General.MaxTimeout)
+		}
+		cfg.General.MaxTimeout = maxTimeout
+	}
+
+	if input.General.RcvBuf != nil {
+		rcvBuf := *input.General.RcvBuf
+		if rcvBuf != 0 && (rcvBuf < minRcvBuf || rcvBuf > maxRcvBuf) {
+			return nil, errorf("general.rcvbuf", "invalid rcvbuf value: %d. It must be 0 (OS default) or between %d and %d bytes", rcvBuf, minRcvBuf, maxRcvBuf)
+		}
+		cfg.General.RcvBuf = rcvBuf
+	}
+
+	if input.General.Readers != nil {
+		if *input.General.Readers < 1 || *input.General.Readers > maxReaders {
+			return nil, errorf("general.readers", "invalid readers value: %d. It must be between 1 and %d", *input.General.Readers, maxReaders)
+		}
+		cfg.General.Readers = *input.General.Readers
+	}
+
+	if input.General.SpoofSource != nil {
+		ip := net.ParseIP(*input.General.SpoofSource).To4()
+		if ip == nil {
+			return nil, errorf("general.spoof_source", "invalid spoof_source value: %q. It must be an IPv4 address", *input.General.SpoofSource)
+		}
+		cfg.General.SpoofSource = ip
+	}
+
+	for key := range input.General.Labels {
+		if !annotationKey.MatchString(key) {
+			return nil, errorf("general.labels", "invalid label key %q: must be letters, digits and underscores, not starting with a digit", key)
+		}
+	}
+	cfg.General.Labels = input.General.Labels
+
+	if input.General.DNSCacheTTL != nil {
+		dnsCacheTTL, err := time.ParseDuration(*input.General.DNSCacheTTL)
+		if err != nil || dnsCacheTTL < 0 {
+			return nil, errorf("general.dns_cache_ttl", "invalid dns_cache_ttl value: %s. It must be a non-negative duration (like '5m')", *input.General.DNSCacheTTL)
+		}
+		cfg.General.DNSCacheTTL = dnsCacheTTL
+	}
+
+	if input.General.StartJitter != nil {
+		startJitter, err := time.ParseDuration(*input.General.StartJitter)
+		if err != nil || startJitter < 0 {
+			return nil, errorf("general.start_jitter", "invalid start_jitter value: %s. It must be a non-negative duration (like '50ms')", *input.General.StartJitter)
+		}
+		cfg.General.StartJitter = startJitter
+	}
+
+	if input.General.MaxPerDest != nil {
+		if *input.General.MaxPerDest < 0 {
+			return nil, errorf("general.max_per_dest", "invalid max_per_dest value: %d. It must be 0 (unlimited) or greater", *input.General.MaxPerDest)
+		}
+		cfg.General.MaxPerDest = *input.General.MaxPerDest
+	}
+
+	if input.General.DontRoute != nil {
+		cfg.General.DontRoute = *input.General.DontRoute
+	}
+	if input.General.FWMark != nil {
+		if !FWMarkSupported {
+			return nil, errorf("general.fwmark", "fwmark is only supported on Linux")
+		}
+		mark, err := strconv.ParseUint(*input.General.FWMark, 0, 32)
+		if err != nil {
+			return nil, errorf("general.fwmark", "invalid fwmark value: %s. It must be a number or hex string (like '0x100') between 0 and 0xffffffff", *input.General.FWMark)
+		}
+		cfg.General.FWMark = uint32(mark)
+	}
+	if input.General.IncludeRawReply != nil {
+		cfg.General.IncludeRawReply = *input.General.IncludeRawReply
+	}
+
+	if input.General.BindToDevice != nil && *input.General.BindToDevice {
+		if !BindToDeviceSupported {
+			return nil, errorf("general.bind_to_device", "bind_to_device is only supported on Linux")
+		}
+		cfg.General.BindToDevice = true
+	}
+
+	if input.General.RXTimestamp != nil && *input.General.RXTimestamp {
+		if !RXTimestampSupported {
+			return nil, errorf("general.rx_timestamp", "rx_timestamp is only supported on Linux")
+		}
+		cfg.General.RXTimestamp = true
+	}
+
+	if input.General.VRF != nil {
+		if !BindToDeviceSupported {
+			return nil, errorf("general.vrf", "vrf is only supported on Linux")
+		}
+		if cfg.General.BindToDevice {
+			return nil, errorf("general.vrf", "vrf and bind_to_device cannot both be set")
+		}
+		if _, err := getIfaceFromInterfaceName(*input.General.VRF); err != nil {
+			return nil, &Error{Field: "general.vrf", Reason: fmt.Sprintf("vrf %q: %v", *input.General.VRF, err), Err: err}
+		}
+		cfg.General.VRF = *input.General.VRF
+	}
+
+	if input.General.PushURL != nil {
+		if _, err := ParsePushURL(*input.General.PushURL); err != nil {
+			return nil, &Error{Field: "general.push_url", Reason: fmt.Sprintf("invalid push_url %q: %v", *input.General.PushURL, err), Err: err}
+		}
+		cfg.General.PushURL = *input.General.PushURL
+	}
+
+	if input.General.WebhookURL != nil {
+		if _, err := ParseWebhookURL(*input.General.WebhookURL); err != nil {
+			return nil, &Error{Field: "general.webhook_url", Reason: fmt.Sprintf("invalid webhook_url %q: %v", *input.General.WebhookURL, err), Err: err}
+		}
+		cfg.General.WebhookURL = *input.General.WebhookURL
+		cfg.General.NotifyOn = []string{"FAILED"}
+	}
+	if input.General.NotifyOn != nil {
+		if cfg.General.WebhookURL == "" {
+			return nil, errorf("general.notify_on", "notify_on requires webhook_url")
+		}
+		for _, status := range *input.General.NotifyOn {
+			if !slices.Contains(ResultStatuses, status) {
+				return nil, errorf("general.notify_on", "invalid notify_on status %q: must be one of %s", status, strings.Join(ResultStatuses, ", "))
+			}
+		}
+		cfg.General.NotifyOn = *input.General.NotifyOn
+	}
+	if input.General.NotifyStateFile != nil {
+		if cfg.General.WebhookURL == "" {
+			return nil, errorf("general.notify_state_file", "notify_state_file requires webhook_url")
+		}
+		cfg.General.NotifyStateFile = *input.General.NotifyStateFile
+		cfg.General.NotifyInterval = defaultNotifyInterval
+	}
+	if input.General.NotifyInterval != nil {
+		if cfg.General.NotifyStateFile == "" {
+			return nil, errorf("general.notify_interval", "notify_interval requires notify_state_file")
+		}
+		notifyInterval, err := time.ParseDuration(*input.General.NotifyInterval)
+		if err != nil || notifyInterval < 0 {
+			return nil, errorf("general.notify_interval", "invalid notify_interval value: %s. It must be a non-negative duration (like '1h')", *input.General.NotifyInterval)
+		}
+		cfg.General.NotifyInterval = notifyInterval
+	}
+
+	cfg.General.Source = "default"
+	if input.General.Source != nil {
+		if !slices.Contains(sourceModes, *input.General.Source) {
+			return nil, errorf("general.source", "invalid source value: %s. It must be 'default' or 'all'", *input.General.Source)
+		}
+		cfg.General.Source = *input.General.Source
+	}
+
+	if len(input.Tests) == 0 {
+		return nil, ErrNoTests
+	}
+	for i, t := range input.Tests {
+		if t.DestFile == nil {
+			continue
+		}
+		if len(t.Destinations) > 0 {
+			return nil, errorf(fmt.Sprintf("tests[%d].dest_file", i), "test %q: dest and dest_file cannot both be set", t.Name)
+		}
+		dests, err := readDestFile(*t.DestFile)
+		if err != nil {
+			return nil, &Error{Field: fmt.Sprintf("tests[%d].dest_file", i), Reason: fmt.Sprintf("test %q: invalid dest_file: %v", t.Name, err), Err: err}
+		}
+		input.Tests[i].Destinations = dests
+	}
+	for i, t := range input.Tests {
+		if t.MinResponders == nil || (t.Mode != nil && *t.Mode == "broadcast") {
+			continue
+		}
+		if len(t.Destinations) < 2 {
+			return nil, errorf(fmt.Sprintf("tests[%d].min_responders", i), "test %q: min_responders requires mode \"broadcast\" or several destinations", t.Name)
+		}
+		if *t.MinResponders > len(t.Destinations) {
+			return nil, errorf(fmt.Sprintf("tests[%d].min_responders", i), "test %q: min_responders %d exceeds the %d destinations", t.Name, *t.MinResponders, len(t.Destinations))
+		}
+	}
+	cfg.Tests = expandDestinations(input.Tests)
+	for i, t := range cfg.Tests {
+		cfg.Tests[i].ExpectedResult = strings.Join(t.ExpectedResults, "|")
+	}
+
+	for i, t := range cfg.Tests {
+		if err := validateDestination(t.Destination); err != nil {
+			return nil, &Error{Field: fmt.Sprintf("tests[%d].dest", i), Reason: fmt.Sprintf("test %q: %v", t.Name, err), Err: err}
+		}
+	}
+
+	for i, t := range cfg.Tests {
+		for key := range t.Annotations {
+			if !annotationKey.MatchString(key) {
+				return nil, errorf(fmt.Sprintf("tests[%d].annotations", i), "test %q: invalid annotation key %q: must be letters, digits and underscores, not starting with a digit", t.Name, key)
+			}
+		}
+	}
+
+	for i, t := range cfg.Tests {
+		for _, name := range t.SkipIf {
+			if _, ok := skipPredicates[name]; !ok {
+				return nil, errorf(fmt.Sprintf("tests[%d].skip_if", i), "test %q: unknown skip_if predicate %q", t.Name, name)
+			}
+		}
+	}
+
+	for i, t := range cfg.Tests {
+		key, value := "probe_timeout", t.ProbeTimeout
+		if value == nil {
+			key, value = "timeout", t.Timeout
+		}
+		if value == nil {
+			continue
+		}
+		if err := checkTimeout(*value, cfg.General.MaxTimeout); err != nil {
+			return nil, errorf(fmt.Sprintf("tests[%d].%s", i, key), "test %q: invalid %s %q: %v", t.Name, key, *value, err)
+		}
+	}
+
+	if cfg.Tests, err = expandSources(cfg.Tests, cfg.General); err != nil {
+		return nil, err
+	}
+
+	deps, err := ResolveDependencies(cfg.Tests)
+	if err != nil {
+		return nil, &Error{Field: "tests.depends_on", Reason: err.Error(), Err: err}
+	}
+	if _, err := OrderTests(cfg.Tests, deps); err != nil {
+		return nil, &Error{Field: "tests.depends_on", Reason: err.Error(), Err: err}
+	}
+	return &cfg, nil
+}
+
+func getIfaceFromInterfaceName(interfaceName string) (*net.Interface, error) {
+	if interfaceName == "" {
+		return nil, fmt.Errorf("interface name is empty")
+	}
+	iface, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return nil, fmt.Errorf("InterfaceByName(%s) failed: %v", interfaceName, err)
+	}
+	return iface, nil
+}
+
+// interfaceHasIP reports whether ip is one of the addresses assigned to iface.
+func interfaceHasIP(iface net.Interface, ip net.IP) (bool, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return false, fmt.Errorf("failed to get addresses for interface %s: %v", iface.Name, err)
+	}
+	for _, addr := range addrs {
+		var a net.IP
+		switch v := addr.(type) {
+		case *net.IPNet:
+			a = v.IP
+		case *net.IPAddr:
+			a = v.IP
+		}
+		if a != nil && a.Equal(ip) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// interfaceByIP returns the interface that ip is assigned to.
+func interfaceByIP(ip net.IP) (net.Interface, error) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return net.Interface{}, fmt.Errorf("failed to get interfaces: %v", err)
+	}
+	for _, iface := range ifaces {
+		ok, err := interfaceHasIP(iface, ip)
+		if err != nil {
+			return net.Interface{}, err
+		}
+		if ok {
+			return iface, nil
+		}
+	}
+	return net.Interface{}, fmt.Errorf("no network interface found with IP address %s", ip)
+}
+
+// selectIPv4Addr returns the index-th IPv4 address of iface (in the order the system
+// reports them) that lies within subnet, or any address if subnet is nil. It returns
+// nil without error if iface has no matching address.
+func selectIPv4Addr(iface net.Interface, subnet *net.IPNet, index int) (net.IP, error) {
+	candidates, err := ipv4Addrs(iface, subnet)
+	if err != nil {
+		return nil, err
+	}
+	if len(candidates) == 0 {
+		return nil, nil
+	}
+	if index >= len(candidates) {
+		return nil, fmt.Errorf("source_ip_index %d out of range: interface %s has %d matching IPv4 addresses", index, iface.Name, len(candidates))
+	}
+	return candidates[index], nil
+}
+
+// ipv4Addrs returns the IPv4 addresses of iface, in the order the system reports them,
+// that lie within subnet, or all of them if subnet is nil.
+func ipv4Addrs(iface net.Interface, subnet *net.IPNet) ([]net.IP, error) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get addresses for interface %s: %v", iface.Name, err)
+	}
+	var candidates []net.IP
+	for _, addr := range addrs {
+		var ip net.IP
+		switch v := addr.(type) {
+		case *net.IPNet:
+			ip = v.IP
+		case *net.IPAddr:
+			ip = v.IP
+		}
+		if ip == nil || ip.To4() == nil {
+			continue
+		}
+		if subnet != nil && !subnet.Contains(ip) {
+			continue
+		}
+		candidates = append(candidates, ip)
+	}
+	return candidates, nil
+}
+
+// determineNetworkInterfaceAndIPAddress resolves the interface and source IP address
+// tests are sent from. Either may be configured; whichever is missing is derived from
+// the other, and when both are given the source IP must be assigned to the interface.
+// Without source_ip, source_subnet and source_ip_index choose among an interface's
+// addresses. With neither, the first interface with a matching IPv4 address is used.
+func determineNetworkInterfaceAndIPAddress(input inputConfig) (net.Interface, net.IP, error) {
+	var sourceIP net.IP
+	if input.General.SourceIPAddressString != nil {
+		sourceIP = net.ParseIP(*input.General.SourceIPAddressString)
+		if sourceIP == nil {
+			return net.Interface{}, nil, fmt.Errorf("invalid source IP address: %s", *input.General.SourceIPAddressString)
+		}
+	}
+
+	var subnet *net.IPNet
+	if input.General.SourceSubnet != nil {
+		_, n, err := net.ParseCIDR(*input.General.SourceSubnet)
+		if err != nil {
+			return net.Interface{}, nil, fmt.Errorf("invalid source_subnet: %s. It must be a CIDR (like '10.0.0.0/24')", *input.General.SourceSubnet)
+		}
+		subnet = n
+	}
+	index := 0
+	if input.General.SourceIPIndex != nil {
+		index = *input.General.SourceIPIndex
+		if index < 0 {
+			return net.Interface{}, nil, fmt.Errorf("invalid source_ip_index: %d. It must be 0 or greater", index)
+		}
+		if input.General.InterfaceName == nil {
+			return net.Interface{}, nil, fmt.Errorf("source_ip_index requires interface_name")
+		}
+	}
+	if sourceIP != nil && (subnet != nil || input.General.SourceIPIndex != nil) {
+		return net.Interface{}, nil, fmt.Errorf("source_ip cannot be combined with source_subnet or source_ip_index")
+	}
+
+	switch {
+	case input.General.InterfaceName != nil && sourceIP != nil:
+		iface, err := getIfaceFromInterfaceName(*input.General.InterfaceName)
+		if err != nil {
+			return net.Interface{}, nil, err
+		}
+		ok, err := interfaceHasIP(*iface, sourceIP)
+		if err != nil {
+			return net.Interface{}, nil, err
+		}
+		if !ok {
+			return net.Interface{}, nil, fmt.Errorf("source IP address %s is not assigned to interface %s", sourceIP, iface.Name)
+		}
+		return *iface, sourceIP, nil
+
+	case input.General.InterfaceName != nil:
+		iface, err := getIfaceFromInterfaceName(*input.General.InterfaceName)
+		if err != nil {
+			return net.Interface{}, nil, err
+		}
+		ip, err := selectIPv4Addr(*iface, subnet, index)
+		if err != nil {
+			return net.Interface{}, nil, err
+		}
+		if ip == nil {
+			if subnet != nil {
+				return net.Interface{}, nil, fmt.Errorf("interface %s has %w in %s", iface.Name, ErrNoIPv4, subnet)
+			}
+			return net.Interface{}, nil, fmt.Errorf("interface %s has %w%s", iface.Name, ErrNoIPv4, ipv6OnlyHint(*iface))
+		}
+		return *iface, ip, nil
+
+	case sourceIP != nil:
+		if sourceIP.To4() == nil {
+			return net.Interface{}, nil, fmt.Errorf("source IP address %s is not an IPv4 address: icmp-test sends ICMPv4 only", sourceIP)
+		}
+		iface, err := interfaceByIP(sourceIP)
+		if err != nil {
+			return net.Interface{}, nil, err
+		}
+		return iface, sourceIP, nil
+	}
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return net.Interface{}, nil, fmt.Errorf("net.Interfaces() failed: %v", err)
+	}
+	for _, iface := range ifaces {
+		ip, err := selectIPv4Addr(iface, subnet, 0)
+		if err != nil {
+			return net.Interface{}, nil, err
+		}
+		if ip != nil {
+			return iface, ip, nil
+		}
+	}
+	if subnet != nil {
+		return net.Interface{}, nil, fmt.Errorf("%w in %s on any network interface", ErrNoIPv4, subnet)
+	}
+	return net.Interface{}, nil, fmt.Errorf("%w on any network interface%s", ErrNoIPv4, ipv6OnlyHint(ifaces...))
+}
+
+// ErrNoIPv4 is wrapped by the error LoadConfig returns when the source interface, or
+// every interface, lacks an IPv4 address, as on IPv6-only networks.
+var ErrNoIPv4 = errors.New("no IPv4 address")
+
+// ipv6OnlyHint explains, for an error about ifaces lacking IPv4 addresses, which IPv6
+// addresses they have instead and that only ICMPv4 is sent. It returns "" if they have
+// no IPv6 addresses either.
+func ipv6OnlyHint(ifaces ...net.Interface) string {
+	var v6 []string
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if ipnet, ok := addr.(*net.IPNet); ok && ipnet.IP.To4() == nil && !ipnet.IP.IsLoopback() {
+				v6 = append(v6, ipnet.IP.String())
+			}
+		}
+	}
+	if len(v6) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("; only IPv6 addresses were found (%s), but icmp-test sends ICMPv4 only: "+
+		"choose an interface with an IPv4 address with interface_name or source_ip", strings.Join(v6, ", "))
+}
+
+// checkTimeout validates a per-probe timeout against the general.max_timeout limit max.
+func checkTimeout(value string, max time.Duration) error {
+	timeout, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	if timeout < time.Millisecond || timeout > max {
+		return fmt.Errorf("must be between 1ms and %v (general.max_timeout)", max)
+	}
+	return nil
+}
+
+// ApplyDefaultCount sets the probe count of every test in cfg that could send several
+// probes but sets neither count nor deadline. Tests with a mode, retries or linger, and
+// tests expecting "unreachable" or a list of results, which are limited to a single
+// probe, are left alone.
+func ApplyDefaultCount(cfg *Config, count int) error {
+	if count < 1 {
+		return fmt.Errorf("invalid count %d: must be at least 1", count)
+	}
+	for i := range cfg.Tests {
+		t := &cfg.Tests[i]
+		if t.Count != nil || t.Deadline != nil || t.Mode != nil || t.Retries != nil || t.Linger != nil {
+			continue
+		}
+		if t.ExpectedResult != "response" && t.ExpectedResult != "timeout" {
+			continue
+		}
+		n := count
+		t.Count = &n
+	}
+	return nil
+}
+
+// ApplyDefaultTimeout sets the per-probe timeout of every test in cfg that does not set
+// its own timeout or probe_timeout. The timeout is validated like configured values.
+func ApplyDefaultTimeout(cfg *Config, timeout time.Duration) error {
+	value := timeout.String()
+	if err := checkTimeout(value, cfg.General.MaxTimeout); err != nil {
+		return fmt.Errorf("invalid timeout %v: %v", timeout, err)
+	}
+	for i := range cfg.Tests {
+		if cfg.Tests[i].Timeout == nil && cfg.Tests[i].ProbeTimeout == nil {
+			cfg.Tests[i].ProbeTimeout = &value
+		}
+	}
+	return nil
+}
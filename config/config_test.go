@@ -0,0 +1,1647 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Package output registers the formats in the program; register the built-in ones here.
+func init() {
+	for _, name := range []string{"text", "json", "csv"} {
+		RegisterOutputFormat(name)
+	}
+}
+
+func getValidInterfaceAndIP(t *testing.T) (string, string) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip("Unable to get network interfaces: ", err)
+	}
+	for _, iface := range ifaces {
+		addrs, err := iface.Addrs()
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			var ip net.IP
+			switch v := addr.(type) {
+			case *net.IPNet:
+				ip = v.IP
+			case *net.IPAddr:
+				ip = v.IP
+			}
+			if ip == nil || ip.To4() == nil || ip.IsLoopback() {
+				continue
+			}
+			return iface.Name, ip.String()
+		}
+	}
+	t.Skip("No valid network interface with IPv4 found")
+	return "", ""
+}
+
+func getLocalInterfaceAndIP(t *testing.T) (string, string) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		t.Skip("Unable to get network interfaces: ", err)
+	}
+	for _, iface := range ifaces {
+		if iface.Name == "lo0" || iface.Name == "lo" {
+			addrs, err := iface.Addrs()
+			if err != nil {
+				continue
+			}
+			for _, addr := range addrs {
+				var ip net.IP
+				switch v := addr.(type) {
+				case *net.IPNet:
+					ip = v.IP
+				case *net.IPAddr:
+					ip = v.IP
+				}
+				if ip == nil || ip.To4() == nil || !ip.IsLoopback() {
+					continue
+				}
+				return iface.Name, ip.String()
+			}
+		}
+	}
+	// Fallback to any loopback address
+	return "lo0", "127.0.0.1"
+}
+
+// TestLoadConfigValid verifies that a valid YAML configuration file is loaded correctly.
+func TestLoadConfigValid(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	// Prepare a YAML configuration content with valid values.
+	yamlContent := fmt.Sprintf(`
+general:
+  output: "json"
+  parallelism: 4
+  tos: 100
+  interfaceName: "%s"
+  sourceIPAddress: "%s"
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+`, ifaceName, ipStr)
+
+	// Write the YAML content to a temporary file.
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+
+	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+
+	// Validate each configuration field.
+	if cfg.General.Output != "json" {
+		t.Errorf("Expected output to be 'json', got: %v", cfg.General.Output)
+	}
+	if cfg.General.Parallelism != 4 {
+		t.Errorf("Expected parallelism to be 4, got: %v", cfg.General.Parallelism)
+	}
+	if cfg.General.TOS != 100 {
+		t.Errorf("Expected TOS to be 100, got: %v", cfg.General.TOS)
+	}
+	if len(cfg.Tests) != 1 || cfg.Tests[0].Name != "scenario1" {
+		t.Errorf("Expected tests to be ['scenario1'], got: %v", cfg.Tests)
+	}
+
+	// Verify that the network interface and source IP are set correctly.
+	// Note: Due to the updated getValidInterfaceAndIP function, we just verify they're non-empty
+	if cfg.General.Interface.Name == "" {
+		t.Errorf("Expected interface to be set, but got empty string")
+	}
+	if cfg.General.SourceIPAddress == nil {
+		t.Errorf("Expected source IP to be set, but got nil")
+	}
+
+	// Log for debugging purposes
+	t.Logf("Using interface: %s, IP: %s", cfg.General.Interface.Name, cfg.General.SourceIPAddress.String())
+}
+
+// TestLoadConfigInvalidOutput checks that an invalid output value results in an error.
+func TestLoadConfigInvalidOutput(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	yamlContent := fmt.Sprintf(`
+general:
+  output: "xml"
+  parallelism: 4
+  tos: 100
+  interfaceName: "%s"
+  sourceIPAddress: "%s"
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+`, ifaceName, ipStr)
+
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	_, err = LoadConfig(tmpfile.Name())
+	if err == nil {
+		t.Fatal("Expected an error for invalid output value, but got nil")
+	}
+	var cfgErr *Error
+	if !errors.As(err, &cfgErr) || cfgErr.Field != "general.output" {
+		t.Errorf("Expected a Error for general.output, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "invalid output value") {
+		t.Errorf("Expected error message to contain 'invalid output value', got: %v", err)
+	}
+}
+
+// TestLoadConfigInvalidTOS checks that an out-of-range TOS value results in an error.
+func TestLoadConfigInvalidTOS(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	yamlContent := fmt.Sprintf(`
+general:
+  output: "json"
+  parallelism: 4
+  tos: 300
+  interfaceName: "%s"
+  sourceIPAddress: "%s"
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+`, ifaceName, ipStr)
+
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	_, err = LoadConfig(tmpfile.Name())
+	if err == nil {
+		t.Fatal("Expected an error for invalid TOS value, but got nil")
+	}
+	var cfgErr *Error
+	if !errors.As(err, &cfgErr) || cfgErr.Field != "general.tos" {
+		t.Errorf("Expected a Error for general.tos, got: %v", err)
+	}
+	if !strings.Contains(err.Error(), "invalid TOS value") {
+		t.Errorf("Expected error message to contain 'invalid TOS value', got: %v", err)
+	}
+}
+
+// TestLoadConfigNoTests checks that an empty tests section results in an error.
+func TestLoadConfigNoTests(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	yamlContent := fmt.Sprintf(`
+general:
+  output: "json"
+  parallelism: 4
+  tos: 100
+  interfaceName: "%s"
+  sourceIPAddress: "%s"
+tests: []
+`, ifaceName, ipStr)
+
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	_, err = LoadConfig(tmpfile.Name())
+	if err == nil {
+		t.Fatal("Expected an error for empty tests section, but got nil")
+	}
+	if !errors.Is(err, ErrNoTests) {
+		t.Errorf("Expected ErrNoTests, got: %v", err)
+	}
+}
+
+// TestLoadConfigMissingFile checks that a missing config file can be told apart from an invalid one.
+func TestLoadConfigMissingFile(t *testing.T) {
+	_, err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml"))
+	if !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("Expected an error wrapping fs.ErrNotExist, got: %v", err)
+	}
+	var cfgErr *Error
+	if errors.As(err, &cfgErr) {
+		t.Errorf("A missing file should not be a Error, got field %q", cfgErr.Field)
+	}
+}
+
+// TestGetIfaceFromInterfaceName verifies the behavior of getIfaceFromInterfaceName for valid and invalid interface names.
+func TestGetIfaceFromInterfaceName(t *testing.T) {
+	ifaceName, _ := getValidInterfaceAndIP(t)
+	// Test with a valid interface name.
+	iface, err := getIfaceFromInterfaceName(ifaceName)
+	if err != nil {
+		t.Fatalf("Expected no error for a valid interface name, but got: %v", err)
+	}
+	if iface.Name != ifaceName {
+		t.Errorf("Expected interface name %s, got %s", ifaceName, iface.Name)
+	}
+
+	// Test with an empty string.
+	_, err = getIfaceFromInterfaceName("")
+	if err == nil {
+		t.Fatal("Expected an error for empty interface name, but got nil")
+	}
+
+	// Test with a non-existent interface name.
+	_, err = getIfaceFromInterfaceName("nonexistent_interface_12345")
+	if err == nil {
+		t.Fatal("Expected an error for a non-existent interface name, but got nil")
+	}
+}
+
+// TestDetermineNetworkInterfaceAndIPAddress_BothSpecified verifies the behavior when both interfaceName and sourceIPAddress are specified.
+func TestDetermineNetworkInterfaceAndIPAddress_BothSpecified(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+	cfg := inputConfig{
+		General: inputGeneralConfig{
+			InterfaceName:         &ifaceName,
+			SourceIPAddressString: &ipStr,
+		},
+	}
+
+	iface, ip, err := determineNetworkInterfaceAndIPAddress(cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if iface.Name != ifaceName {
+		t.Errorf("Expected interface name %s, got %s", ifaceName, iface.Name)
+	}
+	if ip == nil || ip.String() != ipStr {
+		t.Errorf("Expected IP %s, got %v", ipStr, ip)
+	}
+}
+
+// TestDetermineNetworkInterfaceAndIPAddress_Errors verifies that resolution failures are returned as errors.
+func TestDetermineNetworkInterfaceAndIPAddress_Errors(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+	badIface := "nonexistent_interface_12345"
+	badIP := "not-an-ip"
+	unassignedIP := "192.0.2.1"
+	v6IP := "2001:db8::1"
+
+	tests := []struct {
+		name    string
+		general inputGeneralConfig
+		want    string
+	}{
+		{"unknown interface", inputGeneralConfig{InterfaceName: &badIface}, badIface},
+		{"invalid source IP", inputGeneralConfig{SourceIPAddressString: &badIP}, "invalid source IP address"},
+		{"unassigned source IP", inputGeneralConfig{SourceIPAddressString: &unassignedIP}, "no network interface found"},
+		{"unknown interface with source IP", inputGeneralConfig{InterfaceName: &badIface, SourceIPAddressString: &ipStr}, badIface},
+		{"source IP not on interface", inputGeneralConfig{InterfaceName: &ifaceName, SourceIPAddressString: &unassignedIP}, unassignedIP},
+		{"IPv6 source IP", inputGeneralConfig{SourceIPAddressString: &v6IP}, "not an IPv4 address"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, _, err := determineNetworkInterfaceAndIPAddress(inputConfig{General: tt.general})
+			if err == nil {
+				t.Fatal("Expected an error, but got nil")
+			}
+			if !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("Expected error to contain %q, got: %v", tt.want, err)
+			}
+		})
+	}
+}
+
+// TestDetermineNetworkInterfaceAndIPAddress_InterfaceOnly verifies the behavior when only interfaceName is specified.
+func TestDetermineNetworkInterfaceAndIPAddress_InterfaceOnly(t *testing.T) {
+	ifaceName, _ := getValidInterfaceAndIP(t)
+	cfg := inputConfig{
+		General: inputGeneralConfig{
+			InterfaceName: &ifaceName,
+		},
+	}
+	iface, ip, err := determineNetworkInterfaceAndIPAddress(cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if iface.Name != ifaceName {
+		t.Errorf("Expected interface name %s, got %s", ifaceName, iface.Name)
+	}
+	if ip == nil || ip.To4() == nil {
+		t.Errorf("Expected a valid IPv4 address for interface %s, got: %v", ifaceName, ip)
+	}
+}
+
+// TestDetermineNetworkInterfaceAndIPAddress_SourceIPOnly verifies that the interface owning the source IP is chosen.
+func TestDetermineNetworkInterfaceAndIPAddress_SourceIPOnly(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+	cfg := inputConfig{
+		General: inputGeneralConfig{
+			SourceIPAddressString: &ipStr,
+		},
+	}
+	iface, ip, err := determineNetworkInterfaceAndIPAddress(cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if iface.Name != ifaceName {
+		t.Errorf("Expected interface name %s, got %s", ifaceName, iface.Name)
+	}
+	if ip.String() != ipStr {
+		t.Errorf("Expected IP %s, got %v", ipStr, ip)
+	}
+}
+
+// TestLoadConfigUsesResolvedInterface checks that LoadConfig keeps the interface that owns
+// the configured source IP rather than a default interface.
+func TestLoadConfigUsesResolvedInterface(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	yamlContent := fmt.Sprintf(`
+general:
+  source_ip: "%s"
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+`, ipStr)
+
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if cfg.General.Interface.Name != ifaceName || cfg.General.SourceIPAddress.String() != ipStr {
+		t.Errorf("Expected %s/%s, got %s/%v", ifaceName, ipStr, cfg.General.Interface.Name, cfg.General.SourceIPAddress)
+	}
+}
+
+// TestDetermineNetworkInterfaceAndIPAddress_SourceSubnet verifies address selection by CIDR hint and index.
+func TestDetermineNetworkInterfaceAndIPAddress_SourceSubnet(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+	subnet := ipStr + "/32"
+	zero := 0
+	outOfRange := 1000
+	unmatched := "203.0.113.0/24"
+
+	iface, ip, err := determineNetworkInterfaceAndIPAddress(inputConfig{General: inputGeneralConfig{SourceSubnet: &subnet}})
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if iface.Name != ifaceName || ip.String() != ipStr {
+		t.Errorf("Expected %s/%s, got %s/%v", ifaceName, ipStr, iface.Name, ip)
+	}
+
+	_, ip, err = determineNetworkInterfaceAndIPAddress(inputConfig{General: inputGeneralConfig{
+		InterfaceName: &ifaceName, SourceSubnet: &subnet, SourceIPIndex: &zero,
+	}})
+	if err != nil || ip.String() != ipStr {
+		t.Errorf("Expected %s with index 0, got %v (err %v)", ipStr, ip, err)
+	}
+
+	_, _, err = determineNetworkInterfaceAndIPAddress(inputConfig{General: inputGeneralConfig{
+		InterfaceName: &ifaceName, SourceIPIndex: &outOfRange,
+	}})
+	if err == nil || !strings.Contains(err.Error(), "out of range") {
+		t.Errorf("Expected out of range error, got: %v", err)
+	}
+
+	_, _, err = determineNetworkInterfaceAndIPAddress(inputConfig{General: inputGeneralConfig{
+		InterfaceName: &ifaceName, SourceSubnet: &unmatched,
+	}})
+	if err == nil || !strings.Contains(err.Error(), unmatched) || !errors.Is(err, ErrNoIPv4) {
+		t.Errorf("Expected ErrNoIPv4 naming %s, got: %v", unmatched, err)
+	}
+
+	_, _, err = determineNetworkInterfaceAndIPAddress(inputConfig{General: inputGeneralConfig{
+		SourceIPAddressString: &ipStr, SourceSubnet: &subnet,
+	}})
+	if err == nil {
+		t.Error("Expected an error combining source_ip and source_subnet, but got nil")
+	}
+}
+
+// TestDetermineNetworkInterfaceAndIPAddress_NeitherSpecified verifies the behavior when neither interfaceName nor sourceIPAddress is specified.
+// Note: This branch returns the first valid interface found on the system.
+func TestDetermineNetworkInterfaceAndIPAddress_NeitherSpecified(t *testing.T) {
+	cfg := inputConfig{
+		General: inputGeneralConfig{},
+	}
+	iface, ip, err := determineNetworkInterfaceAndIPAddress(cfg)
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if ip == nil || ip.To4() == nil {
+		t.Errorf("Expected a valid IPv4 address, got: %v", ip)
+	}
+	if iface.Name == "" {
+		t.Errorf("Expected a valid interface, got: %v", iface)
+	}
+}
+
+// TestLoadConfigSuiteTimeout verifies that suite_timeout is parsed and invalid values are rejected.
+func TestLoadConfigSuiteTimeout(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	cases := []struct {
+		value   string
+		want    time.Duration
+		wantErr bool
+	}{
+		{"60s", 60 * time.Second, false},
+		{"0s", 0, false},
+		{"-1s", 0, true},
+		{"soon", 0, true},
+	}
+	for _, tc := range cases {
+		yamlContent := fmt.Sprintf(`
+general:
+  interface_name: "%s"
+  source_ip: "%s"
+  suite_timeout: "%s"
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+`, ifaceName, ipStr, tc.value)
+
+		tmpfile, err := os.CreateTemp("", "config-*.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmpfile.Name())
+		if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+			t.Fatal(err)
+		}
+		tmpfile.Close()
+
+		cfg, err := LoadConfig(tmpfile.Name())
+		if tc.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "invalid suite_timeout") {
+				t.Errorf("suite_timeout %q: expected invalid suite_timeout error, got: %v", tc.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("suite_timeout %q: unexpected error: %v", tc.value, err)
+		}
+		if cfg.General.SuiteTimeout != tc.want {
+			t.Errorf("suite_timeout %q: expected %v, got %v", tc.value, tc.want, cfg.General.SuiteTimeout)
+		}
+		if cfg.General.ResolveTimeout != defaultResolveTimeout {
+			t.Errorf("resolve_timeout: expected default %v, got %v", defaultResolveTimeout, cfg.General.ResolveTimeout)
+		}
+	}
+}
+
+// TestLoadConfigMultipleDestinations verifies that dest accepts a scalar or a list and that lists expand into one test per address.
+func TestLoadConfigMultipleDestinations(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	yamlContent := fmt.Sprintf(`
+general:
+  interface_name: "%s"
+  source_ip: "%s"
+tests:
+  - name: "single"
+    dest: "8.8.8.8"
+    request_type: "echo"
+    expected_result: "response"
+  - name: "dns"
+    dest: ["8.8.8.8", "1.1.1.1"]
+    request_type: "echo"
+    expected_result: "response"
+    payload_size: 64
+`, ifaceName, ipStr)
+
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if len(cfg.Tests) != 3 {
+		t.Fatalf("Expected 3 tests after expansion, got %d: %v", len(cfg.Tests), cfg.Tests)
+	}
+	expected := []struct{ name, dest string }{
+		{"single", "8.8.8.8"},
+		{"dns (8.8.8.8)", "8.8.8.8"},
+		{"dns (1.1.1.1)", "1.1.1.1"},
+	}
+	for i, e := range expected {
+		if cfg.Tests[i].Name != e.name || cfg.Tests[i].Destination != e.dest {
+			t.Errorf("test %d: expected %s -> %s, got %s -> %s", i, e.name, e.dest, cfg.Tests[i].Name, cfg.Tests[i].Destination)
+		}
+	}
+	for _, tc := range cfg.Tests[1:] {
+		if tc.PayloadSize == nil || *tc.PayloadSize != 64 {
+			t.Errorf("expected expanded test %s to keep payload_size 64, got %v", tc.Name, tc.PayloadSize)
+		}
+	}
+}
+
+func TestLoadConfigExpectedResultList(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	yamlContent := fmt.Sprintf(`
+general:
+  interface_name: "%s"
+  source_ip: "%s"
+tests:
+  - name: "single"
+    dest: "8.8.8.8"
+    request_type: "echo"
+    expected_result: "timeout"
+  - name: "any"
+    dest: "8.8.8.8"
+    request_type: "echo"
+    expected_result: ["response", "unreachable"]
+`, ifaceName, ipStr)
+
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if got := cfg.Tests[0].ExpectedResult; got != "timeout" {
+		t.Errorf("scalar expected_result: got %q, want %q", got, "timeout")
+	}
+	if got := cfg.Tests[1].ExpectedResult; got != "response|unreachable" {
+		t.Errorf("list expected_result: got %q, want %q", got, "response|unreachable")
+	}
+}
+
+// TestOrderTests verifies dependency resolution, stable ordering, and rejection of unknown or circular dependencies.
+func TestOrderTests(t *testing.T) {
+	tests := expandDestinations([]TestInput{
+		{Name: "service", Destinations: DestinationList{"10.0.0.10"}, DependsOn: []string{"gateway"}},
+		{Name: "gateway", Destinations: DestinationList{"10.0.0.1", "10.0.0.2"}},
+		{Name: "independent", Destinations: DestinationList{"10.0.0.3"}},
+	})
+	deps, err := ResolveDependencies(tests)
+	if err != nil {
+		t.Fatalf("ResolveDependencies error: %v", err)
+	}
+	if len(deps[0]) != 2 {
+		t.Errorf("expected service to depend on both expanded gateway tests, got %v", deps[0])
+	}
+	order, err := OrderTests(tests, deps)
+	if err != nil {
+		t.Fatalf("OrderTests error: %v", err)
+	}
+	if fmt.Sprint(order) != "[1 2 0 3]" {
+		t.Errorf("expected order [1 2 0 3], got %v", order)
+	}
+
+	_, err = ResolveDependencies([]TestInput{{Name: "a", DependsOn: []string{"missing"}}})
+	if err == nil || !strings.Contains(err.Error(), "unknown test") {
+		t.Errorf("expected unknown test error, got: %v", err)
+	}
+
+	cyclic := []TestInput{
+		{Name: "a", DependsOn: []string{"b"}},
+		{Name: "b", DependsOn: []string{"a"}},
+		{Name: "c"},
+	}
+	deps, err = ResolveDependencies(cyclic)
+	if err != nil {
+		t.Fatalf("ResolveDependencies error: %v", err)
+	}
+	if _, err := OrderTests(cyclic, deps); err == nil || !strings.Contains(err.Error(), "circular dependency") {
+		t.Errorf("expected circular dependency error, got: %v", err)
+	}
+}
+
+// TestEvaluateSkipIf verifies that the first matching skip_if predicate produces a skip reason.
+func TestEvaluateSkipIf(t *testing.T) {
+	skipPredicates["test_always"] = func() (string, bool) { return "skipped: always", true }
+	skipPredicates["test_never"] = func() (string, bool) { return "", false }
+	defer func() {
+		delete(skipPredicates, "test_always")
+		delete(skipPredicates, "test_never")
+	}()
+
+	if _, skip := EvaluateSkipIf(nil); skip {
+		t.Errorf("expected no skip without predicates")
+	}
+	if _, skip := EvaluateSkipIf([]string{"test_never"}); skip {
+		t.Errorf("expected no skip when predicate is false")
+	}
+	reason, skip := EvaluateSkipIf([]string{"test_never", "test_always"})
+	if !skip || reason != "skipped: always" {
+		t.Errorf("expected skip with reason %q, got %v %q", "skipped: always", skip, reason)
+	}
+}
+
+// TestLoadConfigUnknownSkipIf checks that an unknown skip_if predicate is rejected at load time.
+func TestLoadConfigUnknownSkipIf(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	yamlContent := fmt.Sprintf(`
+general:
+  interface_name: "%s"
+  source_ip: "%s"
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+    dest: "8.8.8.8"
+    skip_if: ["no_quantum"]
+`, ifaceName, ipStr)
+
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	_, err = LoadConfig(tmpfile.Name())
+	if err == nil || !strings.Contains(err.Error(), "unknown skip_if predicate") {
+		t.Errorf("Expected unknown skip_if predicate error, got: %v", err)
+	}
+}
+
+func TestLoadConfigAnnotations(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	cases := []struct {
+		annotations string
+		wantErr     bool
+	}{
+		{`{ticket: "NET-123", owner: "team-a"}`, false},
+		{`{"owner-team": "a"}`, true},
+		{`{"1st": "a"}`, true},
+	}
+	for _, tc := range cases {
+		yamlContent := fmt.Sprintf(`
+general:
+  interface_name: "%s"
+  source_ip: "%s"
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+    annotations: %s
+`, ifaceName, ipStr, tc.annotations)
+
+		tmpfile, err := os.CreateTemp("", "config-*.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmpfile.Name())
+		if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+			t.Fatal(err)
+		}
+		tmpfile.Close()
+
+		cfg, err := LoadConfig(tmpfile.Name())
+		if tc.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "invalid annotation key") {
+				t.Errorf("annotations %s: expected invalid annotation key error, got: %v", tc.annotations, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("annotations %s: unexpected error: %v", tc.annotations, err)
+		}
+		if got := cfg.Tests[0].Annotations; got["ticket"] != "NET-123" || got["owner"] != "team-a" {
+			t.Errorf("annotations = %v", got)
+		}
+	}
+
+	yamlContent := fmt.Sprintf(`
+general:
+  interface_name: "%s"
+  source_ip: "%s"
+  labels: {"data-center": "dc1"}
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+`, ifaceName, ipStr)
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+	if _, err := LoadConfig(tmpfile.Name()); err == nil || !strings.Contains(err.Error(), "invalid label key") {
+		t.Errorf("expected invalid label key error, got: %v", err)
+	}
+}
+
+// TestLoadConfigMaxTimeout checks that per-probe timeouts are limited by general.max_timeout
+// at load time, with the offending field named in the error.
+func TestLoadConfigMaxTimeout(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	cases := []struct {
+		maxTimeout string // "" = default
+		wantErr    bool
+	}{
+		{"", true},
+		{"60s", false},
+		{"20s", true},
+	}
+	for _, tc := range cases {
+		general := ""
+		if tc.maxTimeout != "" {
+			general = fmt.Sprintf("  max_timeout: %q\n", tc.maxTimeout)
+		}
+		yamlContent := fmt.Sprintf(`
+general:
+  interface_name: "%s"
+  source_ip: "%s"
+%stests:
+  - name: "satellite"
+    dest: "127.0.0.1"
+    probe_timeout: "30s"
+`, ifaceName, ipStr, general)
+
+		tmpfile, err := os.CreateTemp("", "config-*.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmpfile.Name())
+		if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+			t.Fatal(err)
+		}
+		tmpfile.Close()
+
+		_, err = LoadConfig(tmpfile.Name())
+		var cfgErr *Error
+		if tc.wantErr {
+			if !errors.As(err, &cfgErr) || cfgErr.Field != "tests[0].probe_timeout" || !strings.Contains(err.Error(), "max_timeout") {
+				t.Errorf("max_timeout %q: expected a tests[0].probe_timeout error, got: %v", tc.maxTimeout, err)
+			}
+		} else if err != nil {
+			t.Errorf("max_timeout %q: unexpected error: %v", tc.maxTimeout, err)
+		}
+	}
+}
+
+// TestLoadConfigRcvBuf checks the bounds of general.rcvbuf.
+func TestLoadConfigRcvBuf(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	cases := []struct {
+		value   int
+		wantErr bool
+	}{
+		{0, false},
+		{1 << 20, false},
+		{1024, true},
+		{128 << 20, true},
+	}
+	for _, tc := range cases {
+		yamlContent := fmt.Sprintf(`
+general:
+  interface_name: "%s"
+  source_ip: "%s"
+  rcvbuf: %d
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+`, ifaceName, ipStr, tc.value)
+
+		tmpfile, err := os.CreateTemp("", "config-*.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmpfile.Name())
+		if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+			t.Fatal(err)
+		}
+		tmpfile.Close()
+
+		cfg, err := LoadConfig(tmpfile.Name())
+		if tc.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "invalid rcvbuf") {
+				t.Errorf("rcvbuf %d: expected invalid rcvbuf error, got: %v", tc.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("rcvbuf %d: unexpected error: %v", tc.value, err)
+		}
+		if cfg.General.RcvBuf != tc.value {
+			t.Errorf("rcvbuf %d: got %d", tc.value, cfg.General.RcvBuf)
+		}
+	}
+}
+
+// TestLoadConfigReaders checks the bounds of general.readers.
+func TestLoadConfigReaders(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	cases := []struct {
+		value   int
+		wantErr bool
+	}{
+		{1, false},
+		{8, false},
+		{0, true},
+		{65, true},
+	}
+	for _, tc := range cases {
+		yamlContent := fmt.Sprintf(`
+general:
+  interface_name: "%s"
+  source_ip: "%s"
+  readers: %d
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+`, ifaceName, ipStr, tc.value)
+
+		tmpfile, err := os.CreateTemp("", "config-*.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmpfile.Name())
+		if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+			t.Fatal(err)
+		}
+		tmpfile.Close()
+
+		cfg, err := LoadConfig(tmpfile.Name())
+		if tc.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "invalid readers") {
+				t.Errorf("readers %d: expected invalid readers error, got: %v", tc.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("readers %d: unexpected error: %v", tc.value, err)
+		}
+		if cfg.General.Readers != tc.value {
+			t.Errorf("readers %d: got %d", tc.value, cfg.General.Readers)
+		}
+	}
+}
+
+func TestLoadConfigSpoofSource(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	cases := []struct {
+		value   string
+		wantErr bool
+	}{
+		{"198.51.100.7", false},
+		{"2001:db8::1", true},
+		{"not-an-ip", true},
+	}
+	for _, tc := range cases {
+		yamlContent := fmt.Sprintf(`
+general:
+  interface_name: "%s"
+  source_ip: "%s"
+  spoof_source: "%s"
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+`, ifaceName, ipStr, tc.value)
+
+		tmpfile, err := os.CreateTemp("", "config-*.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer os.Remove(tmpfile.Name())
+		if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+			t.Fatal(err)
+		}
+		tmpfile.Close()
+
+		cfg, err := LoadConfig(tmpfile.Name())
+		if tc.wantErr {
+			if err == nil || !strings.Contains(err.Error(), "invalid spoof_source") {
+				t.Errorf("spoof_source %q: expected invalid spoof_source error, got: %v", tc.value, err)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("spoof_source %q: unexpected error: %v", tc.value, err)
+		}
+		if !cfg.General.SpoofSource.Equal(net.ParseIP(tc.value)) {
+			t.Errorf("spoof_source %q: got %v", tc.value, cfg.General.SpoofSource)
+		}
+	}
+}
+
+// TestLoadConfigSourceIPNotOnInterface checks that a source IP not assigned to the
+// configured interface is rejected at load time.
+func TestLoadConfigSourceIPNotOnInterface(t *testing.T) {
+	ifaceName, _ := getValidInterfaceAndIP(t)
+
+	yamlContent := fmt.Sprintf(`
+general:
+  interface_name: "%s"
+  source_ip: "192.0.2.1"
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+`, ifaceName)
+
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	_, err = LoadConfig(tmpfile.Name())
+	if err == nil {
+		t.Fatal("Expected an error for a source IP not on the interface, but got nil")
+	}
+	if !strings.Contains(err.Error(), "192.0.2.1") || !strings.Contains(err.Error(), ifaceName) {
+		t.Errorf("Expected error to name both the source IP and interface, got: %v", err)
+	}
+}
+
+func TestLoadConfigBindToDevice(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	yamlContent := `
+general:
+  bind_to_device: true
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+`
+	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if !BindToDeviceSupported {
+		if err == nil || !strings.Contains(err.Error(), "only supported on Linux") {
+			t.Errorf("Expected an unsupported platform error, got: %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if !cfg.General.BindToDevice {
+		t.Error("Expected bind_to_device to be set")
+	}
+}
+
+func TestLoadConfigRXTimestamp(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	yamlContent := `
+general:
+  rx_timestamp: true
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+`
+	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if !RXTimestampSupported {
+		if err == nil || !strings.Contains(err.Error(), "only supported on Linux") {
+			t.Errorf("Expected an unsupported platform error, got: %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if !cfg.General.RXTimestamp {
+		t.Error("Expected rx_timestamp to be set")
+	}
+}
+
+func TestLoadConfigFWMark(t *testing.T) {
+	load := func(mark string) (*Config, error) {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		yamlContent := fmt.Sprintf(`
+general:
+  fwmark: %s
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+`, mark)
+		if err := os.WriteFile(path, []byte(yamlContent), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return LoadConfig(path)
+	}
+
+	cfg, err := load("0x100")
+	if !FWMarkSupported {
+		if err == nil || !strings.Contains(err.Error(), "only supported on Linux") {
+			t.Errorf("Expected an unsupported platform error, got: %v", err)
+		}
+		return
+	}
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if cfg.General.FWMark != 0x100 {
+		t.Errorf("Expected fwmark 0x100, got: %#x", cfg.General.FWMark)
+	}
+	if cfg, err := load("4294967295"); err != nil || cfg.General.FWMark != 0xffffffff {
+		t.Errorf("Expected fwmark 0xffffffff, got: %v", err)
+	}
+	for _, bad := range []string{"-1", "0x100000000", "mark"} {
+		_, err := load(bad)
+		var cfgErr *Error
+		if !errors.As(err, &cfgErr) || cfgErr.Field != "general.fwmark" || !strings.Contains(err.Error(), "invalid fwmark value") {
+			t.Errorf("fwmark %s: expected a general.fwmark error, got: %v", bad, err)
+		}
+	}
+}
+
+func TestLoadConfigVRF(t *testing.T) {
+	if !BindToDeviceSupported {
+		t.Skip("vrf is only supported on Linux")
+	}
+	tests := []struct {
+		name    string
+		general string
+		wantErr string
+	}{
+		{"unknown device", `vrf: "nonexistent_vrf_12345"`, "nonexistent_vrf_12345"},
+		{"with bind_to_device", "vrf: \"lo\"\n  bind_to_device: true", "cannot both be set"},
+		{"valid", `vrf: "lo"`, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpfile, err := os.CreateTemp("", "config-*.yaml")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.Remove(tmpfile.Name())
+			yamlContent := fmt.Sprintf("general:\n  %s\ntests:\n  - name: \"scenario1\"\n    dest: \"127.0.0.1\"\n", tt.general)
+			if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+				t.Fatal(err)
+			}
+			tmpfile.Close()
+
+			cfg, err := LoadConfig(tmpfile.Name())
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Errorf("Expected error containing %q, got: %v", tt.wantErr, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Expected no error, but got: %v", err)
+			}
+			if cfg.General.VRF != "lo" {
+				t.Errorf("Expected vrf lo, got %q", cfg.General.VRF)
+			}
+		})
+	}
+}
+
+// TestConfigDocsCoverAllFields checks that every YAML key has documentation for -init
+// and -schema, and that no documentation refers to a key that no longer exists.
+func TestConfigDocsCoverAllFields(t *testing.T) {
+	check := func(typ interface{}, docs map[string]fieldDoc) {
+		seen := make(map[string]bool)
+		for _, f := range yamlKeys(reflect.TypeOf(typ)) {
+			key := yamlKey(f)
+			seen[key] = true
+			if _, ok := docs[key]; !ok {
+				t.Errorf("%T: config key %q has no fieldDoc", typ, key)
+			}
+		}
+		for key := range docs {
+			if !seen[key] {
+				t.Errorf("%T: fieldDoc for unknown config key %q", typ, key)
+			}
+		}
+	}
+	check(inputGeneralConfig{}, generalDocs)
+	check(TestInput{}, testDocs)
+}
+
+func TestWriteExampleConfigLoads(t *testing.T) {
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if err := WriteExampleConfig(tmpfile); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	cfg, err := LoadConfig(tmpfile.Name())
+	if err != nil {
+		t.Fatalf("generated example config does not load: %v", err)
+	}
+	if len(cfg.Tests) != 1 || cfg.Tests[0].Destination != "127.0.0.1" {
+		t.Errorf("unexpected tests in example config: %+v", cfg.Tests)
+	}
+}
+
+func TestWriteConfigSchema(t *testing.T) {
+	var buf bytes.Buffer
+	if err := WriteConfigSchema(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties struct {
+			General struct {
+				Properties map[string]json.RawMessage `json:"properties"`
+			} `json:"general"`
+			Tests struct {
+				Items struct {
+					Properties map[string]json.RawMessage `json:"properties"`
+				} `json:"items"`
+			} `json:"tests"`
+		} `json:"properties"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &schema); err != nil {
+		t.Fatalf("schema is not valid JSON: %v", err)
+	}
+	if len(schema.Properties.General.Properties) != len(generalDocs) {
+		t.Errorf("general has %d properties, want %d", len(schema.Properties.General.Properties), len(generalDocs))
+	}
+	if len(schema.Properties.Tests.Items.Properties) != len(testDocs) {
+		t.Errorf("tests have %d properties, want %d", len(schema.Properties.Tests.Items.Properties), len(testDocs))
+	}
+}
+
+func TestValidateDestination(t *testing.T) {
+	valid := []string{"8.8.8.8", "::1", "example.com", "example.com.", "localhost", "a-b.example", "1.example"}
+	for _, dest := range valid {
+		if err := validateDestination(dest); err != nil {
+			t.Errorf("validateDestination(%q) = %v, want nil", dest, err)
+		}
+	}
+	invalid := []string{"", "8.8.8", "256.1.1.1", "-bad.example", "bad-.example", "a..b", "exa mple.com", "under_score.example"}
+	for _, dest := range invalid {
+		if err := validateDestination(dest); err == nil {
+			t.Errorf("validateDestination(%q) = nil, want error", dest)
+		}
+	}
+}
+
+func TestLoadConfigsMerge(t *testing.T) {
+	write := func(content string) string {
+		tmpfile, err := os.CreateTemp("", "config-*.yaml")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { os.Remove(tmpfile.Name()) })
+		if _, err := tmpfile.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+		tmpfile.Close()
+		return tmpfile.Name()
+	}
+	base := write(`
+general:
+  output: "json"
+  parallelism: 4
+tests:
+  - name: "a"
+    dest: "127.0.0.1"
+`)
+	override := write(`
+general:
+  output: "csv"
+tests:
+  - name: "b"
+    dest: "127.0.0.1"
+    depends_on: ["a"]
+`)
+
+	cfg, err := LoadConfigs([]string{base, override})
+	if err != nil {
+		t.Fatalf("Expected no error, but got: %v", err)
+	}
+	if cfg.General.Output != "csv" {
+		t.Errorf("output = %q, want the later file's csv", cfg.General.Output)
+	}
+	if cfg.General.Parallelism != 4 {
+		t.Errorf("parallelism = %d, want 4 kept from the earlier file", cfg.General.Parallelism)
+	}
+	if len(cfg.Tests) != 2 || cfg.Tests[0].Name != "a" || cfg.Tests[1].Name != "b" {
+		t.Errorf("tests = %+v, want a then b", cfg.Tests)
+	}
+}
+
+// TestLoadConfigsWithOverrides checks that overrides replace the files' egress settings
+// before interface resolution and are validated like configured values.
+func TestLoadConfigsWithOverrides(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+
+	// The file's source_ip is not on the interface; it must be dropped by the override.
+	yamlContent := `
+general:
+  interface_name: "no-such-interface0"
+  source_ip: "192.0.2.99"
+tests:
+  - name: "scenario1"
+    dest: "127.0.0.1"
+`
+	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(tmpfile.Name())
+	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+		t.Fatal(err)
+	}
+	tmpfile.Close()
+
+	cfg, err := LoadConfigsWithOverrides([]string{tmpfile.Name()}, Overrides{InterfaceName: ifaceName})
+	if err != nil {
+		t.Fatalf("interface override: unexpected error: %v", err)
+	}
+	if cfg.General.Interface.Name != ifaceName {
+		t.Errorf("interface = %s, want %s", cfg.General.Interface.Name, ifaceName)
+	}
+
+	cfg, err = LoadConfigsWithOverrides([]string{tmpfile.Name()}, Overrides{InterfaceName: ifaceName, SourceIP: ipStr})
+	if err != nil {
+		t.Fatalf("interface and source overrides: unexpected error: %v", err)
+	}
+	if cfg.General.SourceIPAddress.String() != ipStr {
+		t.Errorf("source = %s, want %s", cfg.General.SourceIPAddress, ipStr)
+	}
+
+	if _, err := LoadConfigsWithOverrides([]string{tmpfile.Name()}, Overrides{InterfaceName: ifaceName, SourceIP: "not-an-ip"}); err == nil {
+		t.Error("expected an error for an invalid source IP override")
+	}
+	if _, err := LoadConfig(tmpfile.Name()); err == nil {
+		t.Error("expected the file without overrides to fail on its unknown interface")
+	}
+}
+
+func TestApplyDefaultTimeout(t *testing.T) {
+	explicit, probe := "5s", "250ms"
+	cfg := &Config{Tests: []TestInput{
+		{Name: "default", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response"},
+		{Name: "timeout", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Timeout: &explicit},
+		{Name: "probe_timeout", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", ProbeTimeout: &probe},
+	}}
+	cfg.General.MaxTimeout = defaultMaxTimeout
+	if err := ApplyDefaultTimeout(cfg, 30*time.Second); err == nil || !strings.Contains(err.Error(), "max_timeout") {
+		t.Errorf("expected a max_timeout error for 30s, got %v", err)
+	}
+	if err := ApplyDefaultTimeout(cfg, 3*time.Second); err != nil {
+		t.Fatal(err)
+	}
+	tests := cfg.Tests
+
+	if tests[0].ProbeTimeout == nil || *tests[0].ProbeTimeout != "3s" {
+		t.Errorf("default: probe_timeout = %v, want 3s", tests[0].ProbeTimeout)
+	}
+	if tests[1].ProbeTimeout != nil || *tests[1].Timeout != "5s" {
+		t.Errorf("timeout: probe_timeout = %v, timeout = %s; want unset and 5s", tests[1].ProbeTimeout, *tests[1].Timeout)
+	}
+	if *tests[2].ProbeTimeout != "250ms" {
+		t.Errorf("probe_timeout: probe_timeout = %s, want 250ms", *tests[2].ProbeTimeout)
+	}
+}
+
+func TestLoadConfigMinResponders(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+	load := func(test string) error {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		yamlContent := fmt.Sprintf(`
+general:
+  interface_name: "%s"
+  source_ip: "%s"
+tests:
+  - name: "DNS"
+    request_type: "echo"
+    expected_result: "response"
+%s`, ifaceName, ipStr, test)
+		if err := os.WriteFile(path, []byte(yamlContent), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		_, err := LoadConfig(path)
+		return err
+	}
+
+	if err := load("    dest: [\"192.0.2.1\", \"192.0.2.2\", \"192.0.2.3\"]\n    min_responders: 2\n"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := load("    dest: \"192.0.2.255\"\n    mode: \"broadcast\"\n    min_responders: 3\n"); err != nil {
+		t.Fatalf("unexpected error for broadcast mode: %v", err)
+	}
+	for test, want := range map[string]string{
+		"    dest: \"192.0.2.1\"\n    min_responders: 1\n":                  "requires mode",
+		"    dest: [\"192.0.2.1\", \"192.0.2.2\"]\n    min_responders: 3\n": "exceeds the 2 destinations",
+	} {
+		err := load(test)
+		var cfgErr *Error
+		if !errors.As(err, &cfgErr) || cfgErr.Field != "tests[0].min_responders" || !strings.Contains(err.Error(), want) {
+			t.Errorf("expected a tests[0].min_responders error containing %q, got %v", want, err)
+		}
+	}
+}
+
+func TestLoadConfigDestFile(t *testing.T) {
+	ifaceName, ipStr := getValidInterfaceAndIP(t)
+	dir := t.TempDir()
+	targets := filepath.Join(dir, "targets.txt")
+	if err := os.WriteFile(targets, []byte("# core routers\n192.0.2.1\n\n  192.0.2.2  # spine\ngw.example.com\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	bad := filepath.Join(dir, "bad.txt")
+	if err := os.WriteFile(bad, []byte("192.0.2.1\n8.8.8\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty.txt")
+	if err := os.WriteFile(empty, []byte("# nothing yet\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	load := func(test string) (*Config, error) {
+		path := filepath.Join(dir, "config.yaml")
+		yamlContent := fmt.Sprintf(`
+general:
+  interface_name: "%s"
+  source_ip: "%s"
+tests:
+  - name: "core"
+    request_type: "echo"
+    expected_result: "response"
+%s`, ifaceName, ipStr, test)
+		if err := os.WriteFile(path, []byte(yamlContent), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return LoadConfig(path)
+	}
+
+	cfg, err := load(fmt.Sprintf("    dest_file: %q\n", targets))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var names []string
+	for _, test := range cfg.Tests {
+		names = append(names, test.Name)
+	}
+	want := []string{"core (192.0.2.1)", "core (192.0.2.2)", "core (gw.example.com)"}
+	if !reflect.DeepEqual(names, want) {
+		t.Errorf("tests = %v, want %v", names, want)
+	}
+
+	for _, tc := range []struct {
+		test, want string
+	}{
+		{fmt.Sprintf("    dest_file: %q\n", bad), "bad.txt:2: invalid dest \"8.8.8\""},
+		{fmt.Sprintf("    dest_file: %q\n", empty), "lists no destinations"},
+		{fmt.Sprintf("    dest_file: %q\n", filepath.Join(dir, "missing.txt")), "no such file"},
+		{fmt.Sprintf("    dest: \"192.0.2.9\"\n    dest_file: %q\n", targets), "cannot both be set"},
+	} {
+		if _, err := load(tc.test); err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("%s: expected error containing %q, got %v", strings.TrimSpace(tc.test), tc.want, err)
+		}
+	}
+}
+
+func TestApplyDefaultCount(t *testing.T) {
+	three, deadline, flood, linger := 3, "5s", "flood", "500ms"
+	cfg := &Config{Tests: []TestInput{
+		{Name: "default", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response"},
+		{Name: "count", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Count: &three},
+		{Name: "deadline", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Deadline: &deadline},
+		{Name: "flood", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Mode: &flood},
+		{Name: "unreachable", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "unreachable"},
+		{Name: "linger", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "timeout", Linger: &linger},
+	}}
+	if err := ApplyDefaultCount(cfg, 0); err == nil {
+		t.Error("expected an error for count 0")
+	}
+	if err := ApplyDefaultCount(cfg, 10); err != nil {
+		t.Fatal(err)
+	}
+	tests := cfg.Tests
+
+	if tests[0].Count == nil || *tests[0].Count != 10 {
+		t.Errorf("default: count = %v, want 10", tests[0].Count)
+	}
+	if *tests[1].Count != 3 {
+		t.Errorf("count: count = %d, want 3", *tests[1].Count)
+	}
+	for _, tc := range tests[2:] {
+		if tc.Count != nil {
+			t.Errorf("%s: count = %d, want unset", tc.Name, *tc.Count)
+		}
+	}
+}
+
+func TestWriteConfigJSON(t *testing.T) {
+	count := 3
+	config := &Config{}
+	config.General.Output = "json"
+	config.General.Interface = net.Interface{Index: 1, MTU: 1500, Name: "eth0", Flags: net.FlagUp,
+		HardwareAddr: net.HardwareAddr{0, 0x11, 0x22, 0x33, 0x44, 0x55}}
+	config.General.SourceIPAddress = net.ParseIP("192.0.2.10")
+	config.General.SuiteTimeout = 90 * time.Second
+	config.Tests = []TestInput{{Name: "t", Destination: "192.0.2.1", Destinations: DestinationList{"192.0.2.1", "192.0.2.2"},
+		RequestType: "echo", ExpectedResult: "response", Count: &count}}
+
+	var buf bytes.Buffer
+	if err := WriteConfigJSON(&buf, config); err != nil {
+		t.Fatalf("WriteConfigJSON error: %v", err)
+	}
+	var got struct {
+		General map[string]interface{}   `json:"general"`
+		Tests   []map[string]interface{} `json:"tests"`
+	}
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("output is not valid JSON: %v\n%s", err, buf.String())
+	}
+	iface, _ := got.General["interface"].(map[string]interface{})
+	if iface["name"] != "eth0" || iface["hardware_addr"] != "00:11:22:33:44:55" || iface["flags"] != "up" {
+		t.Errorf("interface = %v", iface)
+	}
+	if got.General["source_ip"] != "192.0.2.10" || got.General["suite_timeout"] != "1m30s" {
+		t.Errorf("general = %v", got.General)
+	}
+	test := got.Tests[0]
+	if test["dest"] != "192.0.2.1" || test["count"] != float64(3) {
+		t.Errorf("test = %v", test)
+	}
+	if _, ok := test["timeout"]; ok {
+		t.Errorf("unset timeout should be omitted: %v", test)
+	}
+}
+
+func TestParsePushURL(t *testing.T) {
+	valid := []string{"http://collector:8080/results", "https://collector.example.com/results", "tcp://127.0.0.1:9000", "unix:///run/collector.sock"}
+	for _, raw := range valid {
+		if _, err := ParsePushURL(raw); err != nil {
+			t.Errorf("ParsePushURL(%q) error: %v", raw, err)
+		}
+	}
+	invalid := []string{"collector:8080", "ftp://collector/results", "http:///results", "tcp://collector", "unix://"}
+	for _, raw := range invalid {
+		if _, err := ParsePushURL(raw); err == nil {
+			t.Errorf("ParsePushURL(%q): expected an error", raw)
+		}
+	}
+}
+
+func TestLoadConfigWebhook(t *testing.T) {
+	tests := []struct {
+		name    string
+		general string
+		wantErr string
+	}{
+		{"default notify_on", `webhook_url: "https://hooks.example.com/x"`, ""},
+		{"tcp webhook", `webhook_url: "tcp://127.0.0.1:9000"`, "must be http or https"},
+		{"notify_on without webhook", `notify_on: ["FAILED"]`, "requires webhook_url"},
+		{"bad status", "webhook_url: \"https://hooks.example.com/x\"\n  notify_on: [\"BROKEN\"]", "invalid notify_on status"},
+		{"state file", "webhook_url: \"https://hooks.example.com/x\"\n  notify_state_file: \"/tmp/notify.json\"", ""},
+		{"state file without webhook", `notify_state_file: "/tmp/notify.json"`, "requires webhook_url"},
+		{"interval without state file", "webhook_url: \"https://hooks.example.com/x\"\n  notify_interval: \"1h\"", "requires notify_state_file"},
+		{"bad interval", "webhook_url: \"https://hooks.example.com/x\"\n  notify_state_file: \"/tmp/notify.json\"\n  notify_interval: \"-1h\"", "invalid notify_interval"},
+	}
+	for _, tc := range tests {
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		content := "general:\n  " + tc.general + "\ntests:\n  - name: \"t\"\n    dest: \"127.0.0.1\"\n    request_type: \"echo\"\n    expected_result: \"response\"\n"
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		config, err := LoadConfig(path)
+		if tc.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+				t.Errorf("%s: error = %v, want %q", tc.name, err, tc.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", tc.name, err)
+		}
+		if !reflect.DeepEqual(config.General.NotifyOn, []string{"FAILED"}) {
+			t.Errorf("%s: notify_on = %v, want [FAILED]", tc.name, config.General.NotifyOn)
+		}
+		if config.General.NotifyStateFile != "" && config.General.NotifyInterval != time.Hour {
+			t.Errorf("%s: notify_interval = %v, want the 1h default", tc.name, config.General.NotifyInterval)
+		}
+	}
+}
+
+// TestExpandSources verifies that source "all" yields one test per IPv4 address of the
+// interface, that a test's source overrides general.source, and that invalid values fail.
+func TestExpandSources(t *testing.T) {
+	ifaceName, ipStr := getLocalInterfaceAndIP(t)
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addrs, err := ipv4Addrs(*iface, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	general := GeneralConfig{Interface: *iface, Source: "all"}
+	def, bad := "default", "every"
+	tests := []TestInput{
+		{Name: "a", Destination: "127.0.0.1"},
+		{Name: "b", Destination: "127.0.0.1", Source: &def},
+	}
+
+	expanded, err := expandSources(tests, general)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(expanded) != len(addrs)+1 {
+		t.Fatalf("got %d tests, want %d (one per address of %s, plus b)", len(expanded), len(addrs)+1, ifaceName)
+	}
+	first := expanded[0]
+	if first.SourceIP.String() != addrs[0].String() || first.Name != "a (from "+addrs[0].String()+")" || first.BaseName() != "a" {
+		t.Errorf("first test = %q from %v (base %q), want a from %s", first.Name, first.SourceIP, first.BaseName(), addrs[0])
+	}
+	if last := expanded[len(expanded)-1]; last.Name != "b" || last.SourceIP != nil {
+		t.Errorf("last test = %q from %v, want b unchanged", last.Name, last.SourceIP)
+	}
+	found := false
+	for _, ip := range addrs {
+		found = found || ip.String() == ipStr
+	}
+	if !found {
+		t.Errorf("addresses %v of %s do not include %s", addrs, ifaceName, ipStr)
+	}
+
+	tests[1].Source = &bad
+	var cfgErr *Error
+	if _, err := expandSources(tests, general); !errors.As(err, &cfgErr) || cfgErr.Field != "tests[1].source" {
+		t.Errorf("invalid source error = %v, want *Error for tests[1].source", err)
+	}
+}
@@ -0,0 +1,307 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+)
+
+// fieldDoc documents one YAML configuration key for -init and -schema.
+type fieldDoc struct {
+	Description string
+	Example     string          // YAML value written by -init
+	Enum        []string        // Allowed values, if restricted
+	EnumFunc    func() []string // Allowed values known only at run time, such as registered output formats
+	Types       []string        // JSON Schema types overriding the one derived from the Go type
+	Commented   bool            // Write the key commented out (optional or conflicting settings)
+}
+
+// enum returns the allowed values of the key, or nil if any value is accepted.
+func (d fieldDoc) enum() []string {
+	if d.EnumFunc != nil {
+		return d.EnumFunc()
+	}
+	return d.Enum
+}
+
+// generalDocs and testDocs document every YAML key of inputGeneralConfig and TestInput.
+// The keys themselves come from the struct tags; TestConfigDocsCoverAllFields keeps the
+// two in sync.
+var generalDocs = map[string]fieldDoc{
+	"output":            {Description: "Output format", Example: `"text"`, EnumFunc: OutputFormats},
+	"parallelism":       {Description: "Number of tests to run concurrently", Example: "1"},
+	"tos":               {Description: "Type of Service (TOS) byte of requests, decimal or hex", Example: `"0x00"`, Types: []string{"string", "integer"}},
+	"interface_name":    {Description: "Network interface to send from (default: first interface with an IPv4 address)", Example: `"eth0"`, Commented: true},
+	"source_ip":         {Description: "Source IP address; must be assigned to interface_name if both are set", Example: `"192.0.2.1"`, Commented: true},
+	"source_subnet":     {Description: "Use the interface address within this CIDR", Example: `"192.0.2.0/24"`, Commented: true},
+	"source_ip_index":   {Description: "Use the n-th (0-based) IPv4 address of interface_name", Example: "0", Commented: true},
+	"result_filter":     {Description: "Only report results with these statuses", Example: `["FAILED"]`, Commented: true},
+	"set_df_bit":        {Description: "Set the Don't Fragment bit on requests", Example: "false"},
+	"suite_timeout":     {Description: "Overall time budget for the whole suite", Example: `"60s"`, Commented: true},
+	"bind_to_device":    {Description: "Bind sockets to the interface with SO_BINDTODEVICE (Linux only)", Example: "true", Commented: true},
+	"vrf":               {Description: "Bind sockets to this VRF master device (Linux only)", Example: `"mgmt"`, Commented: true},
+	"resolve_timeout":   {Description: "Time allowed for resolving each destination name; a slower resolver fails the test with a DNS timeout", Example: `"5s"`},
+	"max_timeout":       {Description: "Largest per-probe timeout a test may set; raise it for satellite or long-haul links", Example: `"10s"`, Commented: true},
+	"readers":           {Description: "Flood mode: goroutines reading replies from each flood test's socket (1-64); raise with rcvbuf if replies are dropped at high rates", Example: "4", Commented: true},
+	"rcvbuf":            {Description: "Socket receive buffer size in bytes (4096-67108864); raise it for flood and high-count tests", Example: "1048576", Commented: true},
+	"spoof_source":      {Description: "Lab use only: source address written into requests' IP headers; requires -allow-spoof and root", Example: `"198.51.100.7"`, Commented: true},
+	"dont_route":        {Description: "Set SO_DONTROUTE so that tests fail unless dest is on a directly connected subnet (Linux, macOS)", Example: "false", Commented: true},
+	"fwmark":            {Description: "Mark sockets with SO_MARK (0-0xffffffff, decimal or hex) so that fwmark policy routing rules apply to probes (Linux only)", Example: `"0x100"`, Types: []string{"string", "integer"}, Commented: true},
+	"rx_timestamp":      {Description: "Measure RTTs up to the kernel's SO_TIMESTAMPNS receive timestamp instead of when the reply is read (Linux only)", Example: "true", Commented: true},
+	"labels":            {Description: "Metadata added to every result's annotations; a test's own annotations win on conflict", Example: `{site: "dc1", role: "edge"}`, Commented: true},
+	"dns_cache_ttl":     {Description: "Reuse each resolved destination name for this long, across tests and -repeat runs (0 = resolve every time)", Example: `"5m"`, Commented: true},
+	"start_jitter":      {Description: "Maximum random delay before each test's first send; use -seed to reproduce", Example: `"50ms"`, Commented: true},
+	"max_per_dest":      {Description: "Tests allowed to run concurrently against one destination (0 = unlimited)", Example: "0"},
+	"push_url":          {Description: "Send results and summary as JSON here after the run (http, https, tcp or unix URL)", Example: `"https://collector.example.com/results"`, Commented: true},
+	"webhook_url":       {Description: "Post a chat message (Slack-compatible) about matching results after the run", Example: `"https://hooks.slack.com/services/T000/B000/XXXX"`, Commented: true},
+	"source":            {Description: "Send each test from the source address (default) or once from every IPv4 address of the interface (all)", Example: `"default"`, Enum: sourceModes, Commented: true},
+	"notify_on":         {Description: "Statuses that trigger a webhook message", Example: `["FAILED", "FLAKY"]`, Commented: true},
+	"notify_state_file": {Description: "File that remembers when each test was last in a webhook message, so that a test that keeps failing is not reported on every run", Example: `"/var/lib/icmp-test/notify-state.json"`, Commented: true},
+	"notify_interval":   {Description: "Minimum time before a test that still matches notify_on is reported again (needs notify_state_file; 0 = every run)", Example: `"1h"`, Commented: true},
+
+	"include_raw_reply": {Description: "Add the bytes of the (last) received ICMP message to JSON results as base64 raw_reply, for analysis without a pcap", Example: "true", Commented: true},
+}
+
+var testDocs = map[string]fieldDoc{
+	"name":                   {Description: "Test name", Example: `"Example Echo Test"`},
+	"dest":                   {Description: "Destination address, or a list expanded into one test each", Example: `"127.0.0.1"`},
+	"dest_file":              {Description: "Instead of dest: file with one destination per line (# starts a comment), expanded like a dest list", Example: `"targets.txt"`, Commented: true},
+	"request_type":           {Description: "ICMP request type", Example: `"echo"`, Enum: []string{"echo", "timestamp", "raw"}},
+	"expected_result":        {Description: `Expected outcome ("response", "timeout" or "unreachable"), or a list of outcomes of which any passes`, Example: `"response"`},
+	"expected_code":          {Description: "Expected result unreachable: Destination Unreachable code by name or number (default: any)", Example: `"admin-prohibited"`, Commented: true},
+	"timeout":                {Description: "Older name for probe_timeout; set only one", Example: `"2s"`, Commented: true},
+	"probe_timeout":          {Description: "How long to wait for each probe's reply (1ms-10s)", Example: `"2s"`},
+	"payload_preset":         {Description: "Echo data mimicking a ping implementation; sets the default payload_size (32, 56 for linux-ping)", Example: `"linux-ping"`, Enum: PayloadPresets, Commented: true},
+	"payload_size":           {Description: "ICMP payload size in bytes (0-65507)", Example: "32"},
+	"id":                     {Description: "ICMP identifier (0-65535; default: process ID)", Example: "12345", Commented: true},
+	"source":                 {Description: "Overrides general.source for this test", Example: `"all"`, Enum: sourceModes, Commented: true},
+	"icmp_type":              {Description: "Raw requests: ICMP type to send (0-255)", Example: "15", Commented: true},
+	"icmp_code":              {Description: "Raw requests: ICMP code (0-255)", Example: "0", Commented: true},
+	"payload":                {Description: "Raw requests: hex-encoded body after ID/Seq", Example: `"deadbeef"`, Commented: true},
+	"payload_file":           {Description: "Raw requests: file whose contents are the body after ID/Seq", Example: `"probe.bin"`, Commented: true},
+	"set_df_bit":             {Description: "Overrides general.set_df_bit for this test", Example: "true", Commented: true},
+	"fail_on_fragmentation":  {Description: "Fail before sending if the payload would be fragmented", Example: "false"},
+	"verify_source":          {Description: "Fail if the reply comes from an address other than dest", Example: "false"},
+	"verify_checksum":        {Description: "Echo requests: end the payload with a CRC32 and fail if a reply's payload is truncated or corrupted (payload_size >= 8)", Example: "true", Commented: true},
+	"ttl":                    {Description: "IP TTL of requests (1-255)", Example: "64", Commented: true},
+	"collect_grace":          {Description: "Multi-probe tests: after the last probe, keep listening this long and count late replies to unanswered probes (default probe_timeout; 0s = off)", Example: `"500ms"`, Commented: true},
+	"linger":                 {Description: "After a timeout, keep listening this long and note any late reply (single-probe tests)", Example: `"500ms"`, Commented: true},
+	"count":                  {Description: "Number of probes to send", Example: "1"},
+	"warmup":                 {Description: "Probes sent and discarded before the counted ones (count > 1 only)", Example: "2", Commented: true},
+	"deadline":               {Description: "Stop sending probes after this long, even if count is not reached", Example: `"10s"`, Commented: true},
+	"stop_on_loss":           {Description: "Stop at the first unanswered probe (count > 1 only)", Example: "true", Commented: true},
+	"interval":               {Description: "Delay between probes when count > 1", Example: `"1s"`},
+	"max_jitter":             {Description: "Fail if jitter across probes exceeds this", Example: `"5ms"`, Commented: true},
+	"max_p99":                {Description: "Fail if the 99th percentile RTT exceeds this", Example: `"50ms"`, Commented: true},
+	"assert_rtt_below":       {Description: "Fail unless the RTT (for count > 1: assert_rtt_stat) is below this", Example: `"20ms"`, Commented: true},
+	"assert_rtt_stat":        {Description: "RTT statistic checked by assert_rtt_below when count > 1", Example: `"avg"`, Enum: RTTStatistics, Commented: true},
+	"assert_loss_below":      {Description: "Packet loss allowed for count > 1 and flood tests (default: none for count > 1)", Example: `"10%"`, Commented: true},
+	"max_unexpected_replies": {Description: "Replies a timeout test with count > 1 or flood mode tolerates, e.g. strays from an earlier run", Example: "1", Commented: true},
+	"assert_ttl_min":         {Description: "Fail if a reply's IP TTL is below this (1-255), e.g. because the path gained hops", Example: "60", Commented: true},
+	"assert_ttl_max":         {Description: "Fail if a reply's IP TTL is above this (1-255), e.g. because the path lost hops", Example: "64", Commented: true},
+	"retries":                {Description: "Single-probe response tests: resend up to this many times, interval apart, after a timeout or transient ICMP error (0-10)", Example: "2", Commented: true},
+	"depends_on":             {Description: "Names of tests that must pass first; otherwise this test is SKIPPED", Example: `["Other Test"]`, Commented: true},
+	"skip":                   {Description: "Do not run this test; report it as SKIPPED", Example: "false"},
+	"skip_if":                {Description: "Skip the test when any of these predicates is true", Example: `["no_ipv6"]`, Commented: true},
+	"mode":                   {Description: "Test mode; flood requires -allow-flood, broadcast collects replies from every host answering a broadcast or multicast dest", Example: `"flood"`, Enum: []string{"", "flood", "broadcast"}, Commented: true},
+	"rate":                   {Description: "Flood mode: packets per second (0 = as fast as possible)", Example: "100", Commented: true},
+	"duration":               {Description: "Flood mode: how long to send (at most 60s)", Example: `"10s"`, Commented: true},
+	"min_responders":         {Description: "Broadcast mode: distinct hosts that must reply; with several destinations: how many must pass for the others' failures to be tolerated", Example: "2", Commented: true},
+	"annotations":            {Description: "Metadata copied verbatim into the results; keys are letters, digits and underscores", Example: `{ticket: "NET-123", owner: "team-a"}`, Commented: true},
+}
+
+// yamlKeys returns the YAML keys of struct type t in field order, skipping
+// untagged and "-" fields.
+func yamlKeys(t reflect.Type) []reflect.StructField {
+	var fields []reflect.StructField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if yamlKey(f) == "" {
+			continue
+		}
+		fields = append(fields, f)
+	}
+	return fields
+}
+
+// yamlKey returns the YAML key of f, or "" if f is not read from YAML.
+func yamlKey(f reflect.StructField) string {
+	key := strings.Split(f.Tag.Get("yaml"), ",")[0]
+	if key == "-" {
+		return ""
+	}
+	return key
+}
+
+// WriteExampleConfig writes a commented example configuration exercising every field.
+// Optional settings that conflict with each other or need extra setup are commented out.
+func WriteExampleConfig(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# icmp-test configuration (generated by icmp-test -init)\n")
+	b.WriteString("general:\n")
+	if err := writeExampleFields(&b, reflect.TypeOf(inputGeneralConfig{}), generalDocs, "  ", "  "); err != nil {
+		return err
+	}
+	b.WriteString("\ntests:\n")
+	if err := writeExampleFields(&b, reflect.TypeOf(TestInput{}), testDocs, "  - ", "    "); err != nil {
+		return err
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeExampleFields writes one "key: example  # description" line per field of t. The
+// first line uses firstIndent (e.g. a list item marker), the rest use indent.
+func writeExampleFields(b *strings.Builder, t reflect.Type, docs map[string]fieldDoc, firstIndent, indent string) error {
+	for i, f := range yamlKeys(t) {
+		key := yamlKey(f)
+		doc, ok := docs[key]
+		if !ok {
+			return fmt.Errorf("no documentation for config key %q", key)
+		}
+		prefix := indent
+		if i == 0 {
+			prefix = firstIndent
+		}
+		comment := doc.Description
+		if enum := doc.enum(); len(enum) > 0 {
+			comment += fmt.Sprintf(" (%s)", strings.Join(quoteAll(enum), ", "))
+		}
+		line := fmt.Sprintf("%s: %s  # %s\n", key, doc.Example, comment)
+		if doc.Commented {
+			line = "# " + line
+		}
+		b.WriteString(prefix + line)
+	}
+	return nil
+}
+
+func quoteAll(values []string) []string {
+	quoted := make([]string, len(values))
+	for i, v := range values {
+		quoted[i] = fmt.Sprintf("%q", v)
+	}
+	return quoted
+}
+
+// configSchema returns a JSON Schema (draft-07) describing the configuration file.
+func configSchema() (map[string]interface{}, error) {
+	general, err := objectSchema(reflect.TypeOf(inputGeneralConfig{}), generalDocs)
+	if err != nil {
+		return nil, err
+	}
+	test, err := objectSchema(reflect.TypeOf(TestInput{}), testDocs)
+	if err != nil {
+		return nil, err
+	}
+	test["required"] = []string{"name", "request_type", "expected_result"}
+	test["oneOf"] = []interface{}{
+		map[string]interface{}{"required": []string{"dest"}},
+		map[string]interface{}{"required": []string{"dest_file"}},
+	}
+	return map[string]interface{}{
+		"$schema":              "http://json-schema.org/draft-07/schema#",
+		"title":                "icmp-test configuration",
+		"type":                 "object",
+		"additionalProperties": false,
+		"required":             []string{"tests"},
+		"properties": map[string]interface{}{
+			"general": general,
+			"tests": map[string]interface{}{
+				"type":     "array",
+				"minItems": 1,
+				"items":    test,
+			},
+		},
+	}, nil
+}
+
+// objectSchema describes the YAML fields of struct type t.
+func objectSchema(t reflect.Type, docs map[string]fieldDoc) (map[string]interface{}, error) {
+	props := make(map[string]interface{})
+	for _, f := range yamlKeys(t) {
+		key := yamlKey(f)
+		doc, ok := docs[key]
+		if !ok {
+			return nil, fmt.Errorf("no documentation for config key %q", key)
+		}
+		prop, err := typeSchema(f.Type)
+		if err != nil {
+			return nil, fmt.Errorf("config key %q: %w", key, err)
+		}
+		if len(doc.Types) > 0 {
+			prop["type"] = doc.Types
+		}
+		prop["description"] = doc.Description
+		if enum := doc.enum(); len(enum) > 0 {
+			prop["enum"] = enum
+		}
+		props[key] = prop
+	}
+	return map[string]interface{}{
+		"type":                 "object",
+		"additionalProperties": false,
+		"properties":           props,
+	}, nil
+}
+
+// typeSchema maps a Go field type to a JSON Schema type.
+func typeSchema(t reflect.Type) (map[string]interface{}, error) {
+	if t == reflect.TypeOf(DestinationList{}) {
+		return map[string]interface{}{
+			"oneOf": []interface{}{
+				map[string]interface{}{"type": "string"},
+				map[string]interface{}{"type": "array", "items": map[string]interface{}{"type": "string"}},
+			},
+		}, nil
+	}
+	if t == reflect.TypeOf(ExpectedResultList{}) {
+		result := map[string]interface{}{"type": "string", "enum": ExpectedResults}
+		return map[string]interface{}{
+			"oneOf": []interface{}{
+				result,
+				map[string]interface{}{"type": "array", "minItems": 1, "items": result},
+			},
+		}, nil
+	}
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return map[string]interface{}{"type": "string"}, nil
+	case reflect.Int:
+		return map[string]interface{}{"type": "integer"}, nil
+	case reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}, nil
+	case reflect.Slice:
+		items, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "array", "items": items}, nil
+	case reflect.Map:
+		values, err := typeSchema(t.Elem())
+		if err != nil {
+			return nil, err
+		}
+		return map[string]interface{}{"type": "object", "additionalProperties": values}, nil
+	}
+	return nil, fmt.Errorf("unsupported type %v", t)
+}
+
+// WriteConfigSchema writes the configuration JSON Schema to w.
+func WriteConfigSchema(w io.Writer) error {
+	schema, err := configSchema()
+	if err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(schema, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
@@ -0,0 +1,89 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// interfaceJSON is the JSON form of the resolved net.Interface, whose Flags and
+// HardwareAddr would otherwise marshal as a number and base64.
+type interfaceJSON struct {
+	Name         string `json:"name"`
+	Index        int    `json:"index"`
+	MTU          int    `json:"mtu"`
+	HardwareAddr string `json:"hardware_addr,omitempty"`
+	Flags        string `json:"flags"`
+}
+
+// MarshalJSON writes the effective general settings under their YAML keys, plus the
+// resolved interface and source address.
+func (g GeneralConfig) MarshalJSON() ([]byte, error) {
+	fields := yamlFieldsJSON(reflect.ValueOf(g))
+	fields["interface_name"] = g.Interface.Name
+	fields["interface"] = interfaceJSON{
+		Name:         g.Interface.Name,
+		Index:        g.Interface.Index,
+		MTU:          g.Interface.MTU,
+		HardwareAddr: g.Interface.HardwareAddr.String(),
+		Flags:        g.Interface.Flags.String(),
+	}
+	fields["source_ip"] = g.SourceIPAddress.String()
+	return json.Marshal(fields)
+}
+
+// MarshalJSON writes the test under its YAML keys, omitting unset optional fields, with
+// dest set to the single destination the test was expanded to (and dest_file, already
+// expanded, left out), expected_result as a
+// string unless it lists several results and, for source "all", source_ip set to the
+// source address it was expanded to.
+func (t TestInput) MarshalJSON() ([]byte, error) {
+	fields := yamlFieldsJSON(reflect.ValueOf(t))
+	fields["dest"] = t.Destination
+	delete(fields, "dest_file")
+	if expected := strings.Split(t.ExpectedResult, "|"); len(expected) > 1 {
+		fields["expected_result"] = expected
+	} else {
+		fields["expected_result"] = t.ExpectedResult
+	}
+	if t.SourceIP != nil {
+		fields["source_ip"] = t.SourceIP.String()
+	}
+	return json.Marshal(fields)
+}
+
+// yamlFieldsJSON maps the YAML keys of struct value v to JSON-friendly values: nil
+// pointers, slices and maps are left out, pointers are dereferenced and durations are
+// written as strings such as "1.5s".
+func yamlFieldsJSON(v reflect.Value) map[string]interface{} {
+	fields := make(map[string]interface{})
+	for _, f := range yamlKeys(v.Type()) {
+		fv := v.FieldByIndex(f.Index)
+		if (fv.Kind() == reflect.Ptr || fv.Kind() == reflect.Slice || fv.Kind() == reflect.Map) && fv.IsNil() {
+			continue
+		}
+		for fv.Kind() == reflect.Ptr {
+			fv = fv.Elem()
+		}
+		if d, ok := fv.Interface().(time.Duration); ok {
+			fields[yamlKey(f)] = d.String()
+			continue
+		}
+		fields[yamlKey(f)] = fv.Interface()
+	}
+	return fields
+}
+
+// WriteConfigJSON writes config, as it takes effect after merging, defaulting and
+// interface resolution, as indented JSON.
+func WriteConfigJSON(w io.Writer, config *Config) error {
+	b, err := json.MarshalIndent(config, "", "  ")
+	if err != nil {
+		return fmt.Errorf("config marshal error: %w", err)
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
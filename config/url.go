@@ -0,0 +1,48 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// ParsePushURL validates a general.push_url value. http and https URLs receive a POST;
+// tcp://host:port and unix:///path receive the JSON followed by a newline.
+func ParsePushURL(raw string) (*url.URL, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "http", "https":
+		if u.Host == "" {
+			return nil, fmt.Errorf("missing host")
+		}
+	case "tcp":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return nil, fmt.Errorf("tcp URL must be tcp://host:port: %v", err)
+		}
+	case "unix":
+		if u.Path == "" {
+			return nil, fmt.Errorf("unix URL must be unix:///path/to/socket")
+		}
+	default:
+		return nil, fmt.Errorf("unsupported scheme %q: must be http, https, tcp or unix", u.Scheme)
+	}
+	return u, nil
+}
+
+// ResultStatuses lists the Status values a result can have.
+var ResultStatuses = []string{"PASSED", "FAILED", "SKIPPED", "DRY-RUN", "FLAKY"}
+
+// ParseWebhookURL validates a general.webhook_url value, which must be http or https.
+func ParseWebhookURL(raw string) (*url.URL, error) {
+	u, err := ParsePushURL(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("webhook URL must be http or https, not %q", u.Scheme)
+	}
+	return u, nil
+}
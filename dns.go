@@ -0,0 +1,58 @@
+package icmptest
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+)
+
+// DNSCache remembers the addresses destination names resolved to, so that tests and
+// repeated suite runs sharing a cache look each name up at most once per TTL. It is
+// safe for concurrent use; a nil *DNSCache or a TTL of 0 resolves every time.
+type DNSCache struct {
+	ttl     time.Duration
+	mu      sync.Mutex
+	entries map[string]dnsCacheEntry
+}
+
+type dnsCacheEntry struct {
+	ip      net.IP
+	expires time.Time
+}
+
+// NewDNSCache returns a cache keeping each resolved name for ttl, normally
+// general.dns_cache_ttl.
+func NewDNSCache(ttl time.Duration) *DNSCache {
+	return &DNSCache{ttl: ttl, entries: make(map[string]dnsCacheEntry)}
+}
+
+// resolve is resolveDestination answered from the cache while an entry is fresh. A cache
+// hit reports a resolution time of 0. Failed lookups are not cached. When a name that was
+// cached before resolves to a different address, the change is logged.
+func (c *DNSCache) resolve(ctx context.Context, dest string, timeout time.Duration) (*net.IPAddr, time.Duration, error) {
+	if c == nil || c.ttl <= 0 || net.ParseIP(dest) != nil || isLocalhostName(dest) {
+		return resolveDestination(ctx, dest, timeout)
+	}
+
+	c.mu.Lock()
+	prev, ok := c.entries[dest]
+	c.mu.Unlock()
+	if ok && time.Now().Before(prev.expires) {
+		logger.Debug("DNS cache hit", "name", dest, "addr", prev.ip)
+		return &net.IPAddr{IP: prev.ip}, 0, nil
+	}
+
+	// Concurrent misses for one name may each look it up; the last answer is kept.
+	dst, resolveTime, err := resolveDestination(ctx, dest, timeout)
+	if err != nil {
+		return nil, resolveTime, err
+	}
+	if ok && !prev.ip.Equal(dst.IP) {
+		logger.Info("DNS resolution changed", "name", dest, "old", prev.ip, "new", dst.IP)
+	}
+	c.mu.Lock()
+	c.entries[dest] = dnsCacheEntry{ip: dst.IP, expires: time.Now().Add(c.ttl)}
+	c.mu.Unlock()
+	return dst, resolveTime, nil
+}
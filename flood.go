@@ -0,0 +1,216 @@
+package icmptest
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/2matzzz/icmp-test/config"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/time/rate"
+)
+
+// maxFloodDuration caps how long a single flood test may send.
+const maxFloodDuration = 60 * time.Second
+
+// runFloodTest sends echo requests to test.Destination for test.FloodDuration, as fast as
+// possible or limited to test.Rate packets per second, and reports throughput, loss and the
+// RTT distribution. Sending and receiving run in separate goroutines sharing one socket;
+// general.readers goroutines drain it, so that one slow reader does not let the socket
+// buffer overflow at high rates. After the last send, replies are collected for one more
+// test.Timeout.
+func runFloodTest(ctx context.Context, cfg *config.Config, test Test) TestResult {
+	result := newTestResult(cfg, test)
+
+	fail := func(format string, args ...interface{}) TestResult {
+		result.Status = "FAILED"
+		result.Details = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	pconn, err := openPacketConn(cfg, test)
+	if err != nil {
+		return fail("%v", err)
+	}
+	defer pconn.Close()
+
+	dst, resolveTime, err := test.resolve(ctx, cfg)
+	result.ResolveTime = resolveTime
+	if err != nil {
+		return fail("[error] test name: %s, %v", test.Name, err)
+	}
+
+	cm := &ipv4.ControlMessage{
+		IfIndex: cfg.General.Interface.Index,
+		Src:     cfg.General.SourceIPAddress,
+	}
+
+	limit := rate.Inf
+	if test.Rate > 0 {
+		limit = rate.Limit(test.Rate)
+	}
+	limiter := rate.NewLimiter(limit, 1)
+
+	var (
+		mu     sync.Mutex
+		sentAt = make(map[int]time.Time) // keyed by Seq; entries are removed once answered
+		rtts   = newRTTSamples(test.maxRTTSamples)
+	)
+
+	// Receivers: match echo replies by (ID, Seq) until the read deadline set after sending.
+	// Each reply is read by exactly one of them; sentAt, guarded by mu, is shared.
+	readers := cfg.General.Readers
+	if readers < 1 {
+		readers = 1
+	}
+	var receivers sync.WaitGroup
+	receivers.Add(readers)
+	receive := func() {
+		defer receivers.Done()
+		resp := make([]byte, 1500)
+		for {
+			n, rcm, peer, err := pconn.ReadFrom(resp)
+			if err != nil {
+				return
+			}
+			now := time.Now()
+			recordReceived(ctx, cfg, test, peer, rcm, resp[:n])
+			parsedMsg, err := icmp.ParseMessage(1, resp[:n])
+			if err != nil || parsedMsg.Type != ipv4.ICMPTypeEchoReply {
+				continue
+			}
+			echo, ok := parsedMsg.Body.(*icmp.Echo)
+			if !ok || echo.ID != test.ID {
+				continue
+			}
+			mu.Lock()
+			if t, ok := sentAt[echo.Seq]; ok {
+				if rtts.count() == 0 {
+					result.FirstReplyRTT = now.Sub(t)
+				}
+				rtts.add(now.Sub(t))
+				delete(sentAt, echo.Seq)
+				result.ReplySize = n
+				result.BytesReceived += n
+			}
+			mu.Unlock()
+		}
+	}
+	for i := 0; i < readers; i++ {
+		go receive()
+	}
+
+	floodCtx, cancel := context.WithTimeout(ctx, test.FloodDuration)
+	defer cancel()
+
+	ttl, _ := pconn.TTL() // for the packet capture
+	start := time.Now()
+	var writeErr error
+	var windowWaits int // times sending waited for a Seq still in flight
+	for k := 0; floodCtx.Err() == nil; k++ {
+		if err := limiter.Wait(floodCtx); err != nil {
+			break
+		}
+		probe := test
+		probe.Seq = (test.Seq + k) & 0xffff
+		// Seq wraps after 65536 probes. A number still awaiting its reply is reused only
+		// once that reply would be a timeout late, and so lost anyway; until then fewer
+		// than 65536 probes are in flight and sending waits.
+		mu.Lock()
+		prev, inFlight := sentAt[probe.Seq]
+		mu.Unlock()
+		if inFlight {
+			if wait := test.Timeout - time.Since(prev); wait > 0 {
+				windowWaits++
+				select {
+				case <-time.After(wait):
+				case <-floodCtx.Done():
+				}
+				if floodCtx.Err() != nil {
+					break
+				}
+			}
+			mu.Lock()
+			delete(sentAt, probe.Seq)
+			mu.Unlock()
+		}
+		b, err := buildICMPPacket(probe)
+		if err != nil {
+			writeErr = err
+			break
+		}
+		mu.Lock()
+		sentAt[probe.Seq] = time.Now()
+		mu.Unlock()
+		n, err := pconn.WriteTo(b, cm, dst)
+		if err != nil {
+			writeErr = fmt.Errorf("WriteTo error: %v", err)
+			break
+		}
+		if err := checkWrite(n, len(b), dst); err != nil {
+			writeErr = err
+			break
+		}
+		recordSent(ctx, cfg, probe, dst, ttl, b)
+		result.PacketsSent++
+		result.RequestSize = len(b)
+		result.BytesSent += len(b)
+	}
+	sendElapsed := time.Since(start)
+	if windowWaits > 0 {
+		result.Notes = append(result.Notes, fmt.Sprintf("sending paused %d times for sequence numbers still in flight (at most 65536 probes per timeout %v)",
+			windowWaits, test.Timeout))
+	}
+
+	// Give stragglers one timeout to arrive, unless the suite itself is done.
+	if ctx.Err() != nil {
+		pconn.SetReadDeadline(time.Now())
+	} else {
+		pconn.SetReadDeadline(time.Now().Add(test.Timeout))
+	}
+	receivers.Wait()
+
+	result.Duration = time.Since(start)
+	mu.Lock()
+	setRTTStats(&result, rtts)
+	mu.Unlock()
+	if sendElapsed > 0 {
+		result.ThroughputPPS = float64(result.PacketsSent) / sendElapsed.Seconds()
+	}
+	result.ActualResult = fmt.Sprintf("%d/%d replies", result.PacketsReceived, result.PacketsSent)
+
+	loss := lossPercent(result)
+	summary := fmt.Sprintf("flooded for %v at %.0f pps, %.1f%% loss, %s",
+		sendElapsed.Round(time.Millisecond), result.ThroughputPPS, loss, probeSummary(result))
+
+	if ctx.Err() != nil {
+		result.ActualResult = "interrupted"
+		return fail("interrupted: %v (%s)", ctx.Err(), summary)
+	}
+	if writeErr != nil {
+		return fail("%v (%s)", writeErr, summary)
+	}
+	if test.ExpectedResult == "timeout" {
+		result.UnexpectedReplies = result.PacketsReceived
+		if result.PacketsReceived > test.MaxUnexpected {
+			return fail("expected timeout, but %s%s", summary, unexpectedAllowance(test))
+		}
+	} else if test.AssertLoss {
+		if loss > test.MaxLoss {
+			return fail("packet loss %.1f%% exceeds assert_loss_below %g%% (%s)", loss, test.MaxLoss, summary)
+		}
+	} else if result.PacketsReceived == 0 {
+		return fail("expected responses, but %s", summary)
+	}
+	if err := checkRTTLimits(result, test); err != nil {
+		return fail("%v (%s)", err, summary)
+	}
+	if err := checkRTTAssertion(result, test); err != nil {
+		return fail("%v (%s)", err, summary)
+	}
+	result.Status = "PASSED"
+	result.Details = summary
+	return result
+}
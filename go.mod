@@ -1,9 +1,10 @@
 module github.com/2matzzz/icmp-test
 
-go 1.19
+go 1.21
 
 require (
 	golang.org/x/net v0.34.0
+	golang.org/x/time v0.5.0
 	gopkg.in/yaml.v2 v2.4.0
 )
 
@@ -0,0 +1,142 @@
+package icmptest
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+
+	"golang.org/x/net/icmp"
+)
+
+// quotedRequest is the part of the original datagram that an ICMP error message quotes:
+// the IPv4 header followed by at least the first 8 bytes of the ICMP request.
+type quotedRequest struct {
+	Dst      net.IP
+	Protocol int
+	Type     int // ICMP type of the quoted request
+	ID       int
+	Seq      int
+}
+
+// parseQuotedRequest parses the inner packet carried in the body of an ICMP error.
+func parseQuotedRequest(data []byte) (quotedRequest, bool) {
+	if len(data) < ipv4HeaderLen {
+		return quotedRequest{}, false
+	}
+	ihl := int(data[0]&0x0f) * 4
+	if ihl < ipv4HeaderLen || len(data) < ihl+icmpHeaderLen {
+		return quotedRequest{}, false
+	}
+	inner := data[ihl:]
+	return quotedRequest{
+		Dst:      net.IP(data[16:20]),
+		Protocol: int(data[9]),
+		Type:     int(inner[0]),
+		ID:       int(inner[4])<<8 | int(inner[5]),
+		Seq:      int(inner[6])<<8 | int(inner[7]),
+	}, true
+}
+
+// quotedErrorRequest returns the request quoted by msg if msg is an ICMP error that
+// carries one (Destination Unreachable, Time Exceeded or Parameter Problem).
+func quotedErrorRequest(msg *icmp.Message) (quotedRequest, bool) {
+	switch body := msg.Body.(type) {
+	case *icmp.DstUnreach:
+		return parseQuotedRequest(body.Data)
+	case *icmp.TimeExceeded:
+		return parseQuotedRequest(body.Data)
+	case *icmp.ParamProb:
+		return parseQuotedRequest(body.Data)
+	}
+	return quotedRequest{}, false
+}
+
+// matches reports whether q is the request test sent to dst: an ICMP request of test's
+// type with its ID and Seq. dst may be nil if unknown.
+func (q quotedRequest) matches(test Test, dst net.IP) bool {
+	if q.Protocol != 1 || q.Type != int(test.RequestType) {
+		return false
+	}
+	if dst != nil && !q.Dst.Equal(dst) {
+		return false
+	}
+	return q.ID == test.ID && q.Seq == test.Seq
+}
+
+// unreachableCodes names the Destination Unreachable codes of RFC 792 and RFC 1812.
+var unreachableCodes = map[int]string{
+	0:  "net-unreachable",
+	1:  "host-unreachable",
+	2:  "protocol-unreachable",
+	3:  "port-unreachable",
+	4:  "fragmentation-needed",
+	5:  "source-route-failed",
+	6:  "net-unknown",
+	7:  "host-unknown",
+	8:  "source-host-isolated",
+	9:  "net-prohibited",
+	10: "host-prohibited",
+	11: "net-tos-unreachable",
+	12: "host-tos-unreachable",
+	13: "admin-prohibited",
+	14: "host-precedence-violation",
+	15: "precedence-cutoff",
+}
+
+// unreachableCodeName returns the name of a Destination Unreachable code, or "code N"
+// for codes without one.
+func unreachableCodeName(code int) string {
+	if name, ok := unreachableCodes[code]; ok {
+		return name
+	}
+	return fmt.Sprintf("code %d", code)
+}
+
+// parseUnreachableCode parses a Destination Unreachable code given by name (e.g.
+// "admin-prohibited") or number.
+func parseUnreachableCode(s string) (int, error) {
+	for code, name := range unreachableCodes {
+		if name == s {
+			return code, nil
+		}
+	}
+	code, err := strconv.Atoi(s)
+	if err != nil {
+		return 0, fmt.Errorf("not a known code name or number")
+	}
+	if code < 0 || code > 255 {
+		return 0, fmt.Errorf("code must be between 0 and 255")
+	}
+	return code, nil
+}
+
+// unreachableError reports a Destination Unreachable message quoting a probe's request.
+type unreachableError struct {
+	Code int
+	Peer net.Addr
+}
+
+func (e *unreachableError) Error() string {
+	return fmt.Sprintf("destination unreachable (%s, code %d) from %v", unreachableCodeName(e.Code), e.Code, e.Peer)
+}
+
+// transientUnreachableCodes are the Destination Unreachable codes that can clear up by
+// themselves, e.g. while a route converges or an ARP entry is resolved. All others, such
+// as admin-prohibited or port-unreachable, come back on every retry.
+var transientUnreachableCodes = map[int]bool{
+	0:  true, // net-unreachable
+	1:  true, // host-unreachable
+	11: true, // net-tos-unreachable
+	12: true, // host-tos-unreachable
+}
+
+// retryable reports whether the outcome of a probe may change if it is sent again: it
+// timed out, or met a Destination Unreachable with a transient code.
+func retryable(reply *probeReply, err error) bool {
+	if err == nil {
+		return reply == nil
+	}
+	var unreachable *unreachableError
+	return errors.As(err, &unreachable) && transientUnreachableCodes[unreachable.Code]
+}
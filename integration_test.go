@@ -1,9 +1,12 @@
-package main
+package icmptest
 
 import (
+	"context"
 	"net"
 	"testing"
 	"time"
+
+	"github.com/2matzzz/icmp-test/config"
 )
 
 // TestICMPIntegration runs various ICMP tests that were previously defined in YAML files
@@ -15,19 +18,19 @@ func TestICMPIntegration(t *testing.T) {
 	// Note: These tests require root privileges to run
 	testCases := []struct {
 		name       string
-		config     Config
+		config     config.Config
 		shouldPass bool
 	}{
 		{
 			name: "Basic Echo Test",
-			config: Config{
-				General: generalConfig{
+			config: config.Config{
+				General: config.GeneralConfig{
 					Output:      "text",
 					Parallelism: 1,
 					TOS:         0,
 					SetDFBit:    false,
 				},
-				Tests: []testInput{
+				Tests: []config.TestInput{
 					{
 						Name:           "Basic Echo to Google DNS",
 						Destination:    "8.8.8.8",
@@ -42,14 +45,14 @@ func TestICMPIntegration(t *testing.T) {
 		},
 		{
 			name: "Large Payload Test",
-			config: Config{
-				General: generalConfig{
+			config: config.Config{
+				General: config.GeneralConfig{
 					Output:      "text",
 					Parallelism: 1,
 					TOS:         0,
 					SetDFBit:    false,
 				},
-				Tests: []testInput{
+				Tests: []config.TestInput{
 					{
 						Name:           "Large payload - 1000 bytes",
 						Destination:    "8.8.8.8",
@@ -72,14 +75,14 @@ func TestICMPIntegration(t *testing.T) {
 		},
 		{
 			name: "DF Bit Test",
-			config: Config{
-				General: generalConfig{
+			config: config.Config{
+				General: config.GeneralConfig{
 					Output:      "text",
 					Parallelism: 1,
 					TOS:         0,
 					SetDFBit:    true, // DF bit enabled
 				},
-				Tests: []testInput{
+				Tests: []config.TestInput{
 					{
 						Name:           "DF bit with large payload (should timeout)",
 						Destination:    "8.8.8.8",
@@ -94,14 +97,14 @@ func TestICMPIntegration(t *testing.T) {
 		},
 		{
 			name: "Localhost Test",
-			config: Config{
-				General: generalConfig{
+			config: config.Config{
+				General: config.GeneralConfig{
 					Output:      "text",
 					Parallelism: 1,
 					TOS:         0,
 					SetDFBit:    false,
 				},
-				Tests: []testInput{
+				Tests: []config.TestInput{
 					{
 						Name:           "Localhost echo",
 						Destination:    "127.0.0.1",
@@ -127,11 +130,12 @@ func TestICMPIntegration(t *testing.T) {
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
 			// Set up network interface and source IP
-			config := setupTestConfig(t, &tc.config)
+			cfg := setupTestConfig(t, &tc.config)
 
 			// Run all tests in this configuration
 			allPassed := true
-			for i, testInput := range config.Tests {
+			seqs := NewSeqAllocator(-1)
+			for _, testInput := range cfg.Tests {
 				// Parse timeout
 				var timeout time.Duration = 2 * time.Second
 				if testInput.Timeout != nil {
@@ -148,7 +152,7 @@ func TestICMPIntegration(t *testing.T) {
 				}
 
 				// Set payload size
-				payloadSize := defaultPayloadSize
+				payloadSize := config.DefaultPayloadSize
 				if testInput.PayloadSize != nil {
 					payloadSize = *testInput.PayloadSize
 				}
@@ -157,14 +161,14 @@ func TestICMPIntegration(t *testing.T) {
 					Name:           testInput.Name,
 					Destination:    testInput.Destination,
 					ID:             pid,
-					Seq:            i + 1,
+					Seq:            seqs.alloc(1),
 					RequestType:    reqType,
 					Timeout:        timeout,
 					ExpectedResult: testInput.ExpectedResult,
 					PayloadSize:    payloadSize,
 				}
 
-				result := runICMPTest(config, test)
+				result := runICMPTest(context.Background(), cfg, test)
 
 				if result.Status != "PASSED" {
 					if tc.shouldPass {
@@ -192,10 +196,10 @@ func intPtr(i int) *int {
 	return &i
 }
 
-func setupTestConfig(t *testing.T, config *Config) *Config {
+func setupTestConfig(t *testing.T, cfg *config.Config) *config.Config {
 	// Determine if this config uses localhost destinations
 	usesLocalhost := false
-	for _, test := range config.Tests {
+	for _, test := range cfg.Tests {
 		if test.Destination == "127.0.0.1" || test.Destination == "::1" || test.Destination == "localhost" {
 			usesLocalhost = true
 			break
@@ -211,17 +215,17 @@ func setupTestConfig(t *testing.T, config *Config) *Config {
 		ifaceName, ipStr = getValidInterfaceAndIP(t)
 	}
 
-	iface, err := getIfaceFromInterfaceName(ifaceName)
+	iface, err := net.InterfaceByName(ifaceName)
 	if err != nil {
 		t.Fatalf("Failed to get interface %s: %v", ifaceName, err)
 	}
 
-	config.General.Interface = *iface
-	config.General.SourceIPAddress = parseIP(ipStr)
-	config.General.InterfaceName = ifaceName
-	config.General.SourceIPAddressString = ipStr
+	cfg.General.Interface = *iface
+	cfg.General.SourceIPAddress = parseIP(ipStr)
+	cfg.General.InterfaceName = ifaceName
+	cfg.General.SourceIPAddressString = ipStr
 
-	return config
+	return cfg
 }
 
 func parseIP(ipStr string) net.IP {
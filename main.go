@@ -1,76 +1,101 @@
-package main
+// Package icmptest runs ICMP reachability tests described by a configuration file
+// and reports their results. The icmp-test command in cmd/icmp-test is a thin wrapper
+// around LoadConfigs and Run.
+package icmptest
 
 import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
-	"flag"
+	"errors"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"math"
+	"math/rand"
 	"net"
 	"os"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
+	"github.com/2matzzz/icmp-test/config"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
-	"gopkg.in/yaml.v2"
 )
 
-type generalConfig struct {
-	Output                string `yaml:"output"`      // "text" or "json"
-	Parallelism           int    `yaml:"parallelism"` // Number of tests to run concurrently
-	TOS                   int    `yaml:"tos"`
-	InterfaceName         string `yaml:"interface_name"` // Network interface name
-	Interface             net.Interface
-	SourceIPAddressString string `yaml:"source_ip"` // Source IP address
-	SourceIPAddress       net.IP
-	ResultFilter          []string `yaml:"result_filter"`
-	SetDFBit              bool     `yaml:"set_df_bit"` // Set Don't Fragment bit in IP header
-}
-
-// Config defines the YAML configuration structure.
-type Config struct {
-	General generalConfig `yaml:"general"`
-	Tests   []testInput   `yaml:"tests"`
-}
-
-type inputGeneralConfig struct {
-	Output                *string `yaml:"output"`      // "text" or "json"
-	Parallelism           *int    `yaml:"parallelism"` // Number of tests to run concurrently
-	TOS                   *string `yaml:"tos"`
-	InterfaceName         *string `yaml:"interface_name"` // Network interface name
-	Interface             net.Interface
-	SourceIPAddressString *string `yaml:"source_ip"` // Source IP address
-	SourceIPAddress       net.IP
-	ResultFilter          *[]string `yaml:"result_filter"`
-	SetDFBit              *bool     `yaml:"set_df_bit"` // Set Don't Fragment bit in IP header
-}
-
-type inputConfig struct {
-	General inputGeneralConfig `yaml:"general"`
-	Tests   []testInput        `yaml:"tests"`
-}
-
-// testInput defines the structure for a single test scenario.
-type testInput struct {
-	Name           string  `yaml:"name"`            // Test name
-	Destination    string  `yaml:"dest"`            // Destination IP address
-	RequestType    string  `yaml:"request_type"`    // Request type ("echo" or "timestamp")
-	ExpectedResult string  `yaml:"expected_result"` // Expected result ("response" or "timeout")
-	Timeout        *string `yaml:"timeout"`         // Timeout duration (e.g., "2s")
-	PayloadSize    *int    `yaml:"payload_size"`    // ICMP echo payload size in bytes
-}
-
 type Test struct {
 	Name           string
 	Destination    string
 	ID             int
 	Seq            int
 	RequestType    ipv4.ICMPType
-	Timeout        time.Duration
-	ExpectedResult string
+	Timeout        time.Duration // How long to wait for each probe's reply
+	ExpectedResult string        // "response", "timeout" or "unreachable"; single-probe tests may join several with "|"
+	ExpectedCode   int           // "unreachable" tests: Destination Unreachable code expected, or -1 for any
 	PayloadSize    int
+	PayloadPreset  string // Echo requests: key of payloadPresets ("" = "icmp-test")
+
+	Raw      bool   // request_type "raw": RequestType is the configured icmp_type
+	ICMPCode int    // Raw requests: ICMP code
+	Payload  []byte // Raw requests: body after ID/Seq
+
+	FailOnFragmentation bool
+	VerifySource        bool
+	VerifyChecksum      bool          // Echo requests: end the payload with a CRC32 and check it in replies
+	TTL                 int           // 0 = system default
+	Linger              time.Duration // Single-probe tests: keep listening this long after a timeout (0 = off)
+	Retries             int           // Single-probe tests: resends after a timeout or transient ICMP error
+	CollectGrace        time.Duration // Multi-probe tests: keep listening this long after the last probe (0 = off; default Timeout)
+
+	Count      int
+	Warmup     int
+	StopOnLoss bool          // Stop sending at the first unanswered probe
+	Deadline   time.Duration // Stop sending probes once this much time has passed (0 = no deadline)
+	Interval   time.Duration
+	MaxJitter  time.Duration
+	MaxP99     time.Duration
+
+	AssertRTTBelow time.Duration // 0 = no latency assertion
+	AssertRTTStat  string        // "min", "avg", "max", "p50", "p95" or "p99"
+	AssertLoss     bool          // Set by assert_loss_below; otherwise count > 1 requires no loss and flood any reply
+	MaxLoss        float64       // Loss percentage allowed when AssertLoss is set
+	MaxUnexpected  int           // "timeout" tests with count > 1 or flood: replies tolerated
+	AssertTTLMin   int           // Fail if a reply's TTL is below this (0 = no lower bound)
+	AssertTTLMax   int           // Fail if a reply's TTL is above this (0 = no upper bound)
+
+	Mode          string
+	Rate          int
+	FloodDuration time.Duration
+	MinResponders int // Broadcast mode: distinct hosts that must reply; several destinations: see applyMinResponders
+
+	dns           *DNSCache     // Resolves Destination; set by runSuite (nil = no caching)
+	resolved      *resolvedDest // Destination as already resolved by runSuite, if it was
+	maxRTTSamples int           // RTT samples kept for percentiles; set by runSuite (0 = all)
+}
+
+// resolvedDest is the outcome of resolving a test's destination.
+type resolvedDest struct {
+	addr *net.IPAddr
+	time time.Duration
+	err  error
+}
+
+// resolve returns the address of t.Destination, its resolution time and any error. The
+// outcome runSuite already got is reused; otherwise the name is resolved through t.dns
+// within general.resolve_timeout.
+func (t Test) resolve(ctx context.Context, cfg *config.Config) (*net.IPAddr, time.Duration, error) {
+	if t.resolved != nil {
+		return t.resolved.addr, t.resolved.time, t.resolved.err
+	}
+	return t.dns.resolve(ctx, t.Destination, cfg.General.ResolveTimeout)
 }
 
 // icmpTimestamp represents the ICMP Timestamp message body.
@@ -86,18 +111,120 @@ type icmpTimestamp struct {
 
 var pid = os.Getpid() & 0xffff
 
-const (
-	defaultOutput      = "text"
-	defaultParallelism = 1
-	defaultTOS         = 0
-	defaultTimeout     = "1s"
-	defaultPayloadSize = 32
-	defaultSetDFBit    = false
+// SeqAllocator is the single source of ICMP sequence numbers. It hands out contiguous
+// blocks, one number per probe, monotonically and wrapping at 16 bits, so tests and
+// repeated suite runs sharing an allocator never reuse a number until 65536 have been used.
+type SeqAllocator struct {
+	mu   sync.Mutex
+	next int
+}
+
+// NewSeqAllocator returns an allocator whose first sequence number is start (mod 65536).
+// A negative start picks a random one, so that stale replies from earlier runs are
+// unlikely to match.
+func NewSeqAllocator(start int) *SeqAllocator {
+	if start < 0 {
+		start = rand.Intn(0x10000)
+	}
+	return &SeqAllocator{next: start & 0xffff}
+}
+
+// alloc reserves n consecutive sequence numbers and returns the first; the block may
+// wrap past 65535 to 0.
+func (a *SeqAllocator) alloc(n int) int {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	first := a.next
+	a.next = (a.next + n) & 0xffff
+	return first
+}
+
+// assignFloodIDs returns an ICMP ID of its own for every flood test in tests that does
+// not set id, keyed by test index. A flood test sends sequence numbers for as long as it
+// runs, far beyond the block runSuite reserves for it, so sharing the process ID with
+// other tests would let their replies match each other. A flood test that sets id must
+// not share it with any other test.
+func assignFloodIDs(tests []config.TestInput) (map[int]int, error) {
+	isFlood := func(t config.TestInput) bool { return t.Mode != nil && *t.Mode == "flood" }
+	used := map[int]int{pid: -1} // ID -> index of the test using it; -1 for tests without id
+	for i, t := range tests {
+		if t.ID == nil {
+			continue
+		}
+		other, ok := used[*t.ID]
+		switch {
+		case ok && other < 0 && isFlood(t):
+			return nil, fmt.Errorf("flood test %q uses id %d, the default ID of the other tests; set a different id or leave it unset", t.Name, *t.ID)
+		case ok && other >= 0 && (isFlood(t) || isFlood(tests[other])):
+			return nil, fmt.Errorf("tests %q and %q both use id %d, but a flood test needs an ICMP ID of its own", tests[other].Name, t.Name, *t.ID)
+		}
+		used[*t.ID] = i
+	}
+	ids := make(map[int]int)
+	next := pid
+	for i, t := range tests {
+		if !isFlood(t) || t.ID != nil {
+			continue
+		}
+		for {
+			next = (next + 1) & 0xffff
+			if _, ok := used[next]; !ok {
+				break
+			}
+		}
+		used[next] = i
+		ids[i] = next
+	}
+	return ids, nil
+}
+
+// logLevel controls the verbosity of logger; see SetLogLevel.
+var logLevel = new(slog.LevelVar)
+
+// SetLogLevel sets the minimum level of the diagnostics logged to stderr. The default
+// is slog.LevelInfo.
+func SetLogLevel(level slog.Level) {
+	logLevel.Set(level)
+}
+
+// logOutput is where logger and debug hex dumps are written.
+var logOutput io.Writer = os.Stderr
+
+// logger writes diagnostics to stderr so that results on stdout stay machine-readable.
+var logger = slog.New(slog.NewTextHandler(logOutput, &slog.HandlerOptions{Level: logLevel}))
+
+// SetLogOutput sets where diagnostics and debug hex dumps are written. The default is
+// os.Stderr. It must not be called while a suite is running.
+func SetLogOutput(w io.Writer) {
+	logOutput = w
+	logger = slog.New(slog.NewTextHandler(w, &slog.HandlerOptions{Level: logLevel}))
+}
 
+// dumpMu keeps multi-line hex dumps from concurrent tests from interleaving.
+var dumpMu sync.Mutex
+
+// debugDump logs msg with args and writes a hex dump of b beneath it.
+// Nothing is formatted unless debug logging is enabled.
+func debugDump(ctx context.Context, msg string, b []byte, args ...any) {
+	if !logger.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	dumpMu.Lock()
+	defer dumpMu.Unlock()
+	logger.Debug(msg, append(args, "bytes", len(b))...)
+	fmt.Fprint(logOutput, hex.Dump(b))
+}
+
+const (
 	// Socket options for DF bit setting
 	IP_DONTFRAG     = 28 // macOS: Don't fragment flag
 	IP_MTU_DISCOVER = 10 // Linux: Path MTU discovery option
 	IP_PMTUDISC_DO  = 2  // Linux: Always send DF bit
+
+	// Header sizes used to derive the largest unfragmented payload from the MTU
+	ipv4HeaderLen = 20
+	ipv6HeaderLen = 40
+	icmpHeaderLen = 8
 )
 
 // Len returns the length of the ICMP Timestamp message body.
@@ -127,22 +254,38 @@ func (t *icmpTimestamp) Marshal(_ int) ([]byte, error) {
 	return b, nil
 }
 
+// defaultPayloadPattern is repeated to fill echo request payloads.
+const defaultPayloadPattern = "0123456789abcdefghijklmnopqrstuvwxyz"
+
+// buildPayload returns size bytes of pattern repeated from its start, truncated at the
+// end. An empty pattern yields zero bytes.
+func buildPayload(size int, pattern string) []byte {
+	data := make([]byte, size)
+	if pattern == "" {
+		return data
+	}
+	for i := range data {
+		data[i] = pattern[i%len(pattern)]
+	}
+	return data
+}
+
 // createICMPMessage builds an ICMP message based on the provided request type,
-// using the given id, sequence number, and payload size.
-func createICMPMessage(reqType ipv4.ICMPType, id, seq, payloadSize int) (*icmp.Message, error) {
+// using the given id, sequence number, and payload size. Echo data follows preset, a
+// key of payloadPresets; "" means "icmp-test".
+func createICMPMessage(reqType ipv4.ICMPType, id, seq, payloadSize int, preset string) (*icmp.Message, error) {
 	if reqType == ipv4.ICMPTypeEcho {
-		// Create payload data with specified size
-		data := make([]byte, payloadSize)
-		// Fill with [0-9a-z] pattern (36 characters total)
-		pattern := "0123456789abcdefghijklmnopqrstuvwxyz"
-		for i := 0; i < payloadSize; i++ {
-			data[i] = pattern[i%len(pattern)]
+		if preset == "" {
+			preset = "icmp-test"
+		}
+		p, ok := payloadPresets[preset]
+		if !ok {
+			return nil, fmt.Errorf("unknown payload preset %q", preset)
 		}
-
 		echo := &icmp.Echo{
 			ID:   id,
 			Seq:  seq,
-			Data: data,
+			Data: p.build(payloadSize, time.Now()),
 		}
 		return &icmp.Message{
 			Type: reqType,
@@ -166,33 +309,212 @@ func createICMPMessage(reqType ipv4.ICMPType, id, seq, payloadSize int) (*icmp.M
 	return nil, fmt.Errorf("unsupported request type: %v", reqType)
 }
 
+// createRawICMPMessage builds an ICMP message of an arbitrary type and code. The body
+// starts with id and seq, like echo and timestamp requests, so that replies quoting them
+// can be matched, followed by payload.
+func createRawICMPMessage(reqType ipv4.ICMPType, code, id, seq int, payload []byte) *icmp.Message {
+	data := make([]byte, 4+len(payload))
+	binary.BigEndian.PutUint16(data[0:2], uint16(id))
+	binary.BigEndian.PutUint16(data[2:4], uint16(seq))
+	copy(data[4:], payload)
+	return &icmp.Message{
+		Type: reqType,
+		Code: code,
+		Body: &icmp.RawBody{Data: data},
+	}
+}
+
+// requestTypeName returns the request type as reported in results, e.g. "echo" or
+// "raw(type=15,code=0)".
+func (t Test) requestTypeName() string {
+	if t.Raw {
+		return fmt.Sprintf("raw(type=%d,code=%d)", int(t.RequestType), t.ICMPCode)
+	}
+	return t.RequestType.String()
+}
+
+// expects reports whether outcome ("response", "timeout" or "unreachable") passes the
+// test, i.e. whether it is any of its expected results.
+func (t Test) expects(outcome string) bool {
+	return slices.Contains(strings.Split(t.ExpectedResult, "|"), outcome)
+}
+
 // TestResult holds the result of a test scenario.
 type TestResult struct {
-	Name            string        `json:"name"`
-	SourceInterface string        `json:"source_interface"`
-	SourceIPAddress string        `json:"source_ip_address"`
-	Destination     string        `json:"destination"`
-	RequestType     string        `json:"request_type"`
-	ExpectedResult  string        `json:"expected_result"`
-	ActualResult    string        `json:"actual_result"`
-	Duration        time.Duration `json:"duration"`
-	Status          string        `json:"status"` // "PASSED" or "FAILED"
-	Details         string        `json:"details,omitempty"`
-	Timestamp       time.Time     `json:"timestamp"`
+	Name                   string        `json:"name"`
+	SourceInterface        string        `json:"source_interface"`
+	SourceIPAddress        string        `json:"source_ip_address"`
+	VRF                    string        `json:"vrf,omitempty"` // VRF the test ran in, if general.vrf is set
+	Destination            string        `json:"destination"`
+	RequestType            string        `json:"request_type"`
+	ExpectedResult         string        `json:"expected_result"`
+	ActualResult           string        `json:"actual_result"`
+	Duration               time.Duration `json:"duration"`                  // Measured on the monotonic clock: single probe: its RTT; otherwise the time spent probing
+	FirstReplyRTT          time.Duration `json:"first_reply_rtt,omitempty"` // RTT of the first answered (counted) probe
+	TotalTime              time.Duration `json:"total_time,omitempty"`      // Wall time of the whole test, including waits and timeouts
+	PacketsSent            int           `json:"packets_sent"`
+	PacketsReceived        int           `json:"packets_received"`
+	MinRTT                 time.Duration `json:"min_rtt,omitempty"` // Multi-probe RTT statistics
+	AvgRTT                 time.Duration `json:"avg_rtt,omitempty"`
+	MaxRTT                 time.Duration `json:"max_rtt,omitempty"`
+	Jitter                 time.Duration `json:"jitter,omitempty"` // Mean absolute difference between consecutive RTTs
+	P50RTT                 time.Duration `json:"p50_rtt,omitempty"`
+	P95RTT                 time.Duration `json:"p95_rtt,omitempty"`
+	P99RTT                 time.Duration `json:"p99_rtt,omitempty"`
+	ThroughputPPS          float64       `json:"throughput_pps,omitempty"`           // Flood mode: packets sent per second
+	ResolveTime            time.Duration `json:"resolve_time,omitempty"`             // Time spent resolving Destination; not part of any RTT
+	RequestSize            int           `json:"request_size,omitempty"`             // ICMP message bytes of each request
+	ReplySize              int           `json:"reply_size,omitempty"`               // ICMP message bytes of the (last) reply
+	ReplyTTL               int           `json:"reply_ttl,omitempty"`                // IP TTL of the (last) reply; 0 if unknown
+	RawReply               []byte        `json:"raw_reply,omitempty"`                // general.include_raw_reply: ICMP message bytes of the (last) reply, base64 in JSON
+	Fragmented             bool          `json:"fragmented,omitempty"`               // Requests exceed the interface MTU without DF, so the kernel sends them in fragments
+	ReplyFragmented        bool          `json:"reply_fragmented,omitempty"`         // The (last) reply exceeds the interface MTU, so it must have arrived in fragments
+	BytesSent              int           `json:"bytes_sent,omitempty"`               // ICMP message bytes of all counted requests and retries
+	BytesReceived          int           `json:"bytes_received,omitempty"`           // ICMP message bytes of all counted replies
+	UnexpectedReplies      int           `json:"unexpected_replies,omitempty"`       // "timeout" tests: replies received although none were expected
+	Retries                int           `json:"retries,omitempty"`                  // Single-probe tests: requests resent after a timeout or transient ICMP error
+	Responders             []string      `json:"responders,omitempty"`               // Broadcast mode: distinct hosts that replied, in order of arrival
+	ReplyInterface         string        `json:"reply_interface,omitempty"`          // Interface the (last) reply arrived on
+	ReplyInterfaceMismatch bool          `json:"reply_interface_mismatch,omitempty"` // A reply arrived on a different interface than the egress one
+	Runs                   int           `json:"runs,omitempty"`                     // -repeat: number of suite runs aggregated
+	RunsPassed             int           `json:"runs_passed,omitempty"`              // -repeat: runs in which the test passed
+	Status                 string        `json:"status"`                             // "PASSED", "FAILED", "SKIPPED" or (with -repeat) "FLAKY"
+	Details                string        `json:"details,omitempty"`
+	Notes                  []string      `json:"notes,omitempty"` // Informational notes (e.g. expected fragmentation)
+	Timestamp              time.Time     `json:"timestamp"`       // Wall-clock start of the test, for correlation with other systems; never used to compute durations
+
+	Annotations map[string]string `json:"annotations,omitempty"` // general.labels and the test's annotations, verbatim
 }
 
-// runICMPTest sends an ICMP request and waits until a reply with a matching (ID, Seq) is received.
-// It ignores any replies whose (ID, Seq) pair does not match the one sent. The overall timeout is applied.
-func runICMPTest(config *Config, test Test) TestResult {
-	result := TestResult{
+// MarshalJSON writes the result with its declared JSON keys plus duration_ms, Duration
+// in (fractional) milliseconds, which is easier for consumers than the nanosecond
+// integer in duration, kept for compatibility.
+func (r TestResult) MarshalJSON() ([]byte, error) {
+	type plain TestResult // without this method
+	return json.Marshal(struct {
+		plain
+		DurationMS float64 `json:"duration_ms"`
+	}{plain(r), float64(r.Duration) / float64(time.Millisecond)})
+}
+
+// resolveDestination resolves dest to its first IPv4 address, allowing the lookup at most
+// timeout (0 = no limit), and returns how long resolution took. Addresses and localhost
+// names are returned without a lookup.
+// A lookup that runs out of time fails with a "DNS timeout" error rather than blocking
+// the test; if ctx itself is done, its error is returned.
+func resolveDestination(ctx context.Context, dest string, timeout time.Duration) (*net.IPAddr, time.Duration, error) {
+	if ip := net.ParseIP(dest); ip != nil {
+		if ip.To4() == nil {
+			return nil, 0, fmt.Errorf("%s is not an IPv4 address", dest)
+		}
+		return &net.IPAddr{IP: ip}, 0, nil
+	}
+	// What "localhost" resolves to depends on the host: some list ::1 first, some do not
+	// list it at all. It always means this host's loopback (RFC 6761), so skip the lookup.
+	if isLocalhostName(dest) {
+		return &net.IPAddr{IP: net.IPv4(127, 0, 0, 1).To4()}, 0, nil
+	}
+
+	start := time.Now()
+	lookupCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		lookupCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(lookupCtx, dest)
+	elapsed := time.Since(start)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, elapsed, ctx.Err()
+		}
+		var dnsErr *net.DNSError
+		if lookupCtx.Err() != nil || (errors.As(err, &dnsErr) && dnsErr.IsTimeout) {
+			return nil, elapsed, fmt.Errorf("DNS timeout: resolving %s took longer than resolve_timeout %v", dest, timeout)
+		}
+		return nil, elapsed, fmt.Errorf("resolve error: %v", err)
+	}
+	for _, addr := range addrs {
+		if addr.IP.To4() != nil {
+			return &net.IPAddr{IP: addr.IP.To4()}, elapsed, nil
+		}
+	}
+	return nil, elapsed, fmt.Errorf("resolve error: %s has no IPv4 address", dest)
+}
+
+// isLocalhostName reports whether dest is "localhost" or a name under it, with or without
+// the trailing dot, in any case.
+func isLocalhostName(dest string) bool {
+	name := strings.ToLower(strings.TrimSuffix(dest, "."))
+	return name == "localhost" || strings.HasSuffix(name, ".localhost")
+}
+
+// maxUnfragmentedPayload returns the largest ICMP payload that fits in a single
+// packet on a link with the given MTU, accounting for the IP header of dst's family.
+func maxUnfragmentedPayload(mtu int, dst net.IP) int {
+	ipHeaderLen := ipv4HeaderLen
+	if dst != nil && dst.To4() == nil {
+		ipHeaderLen = ipv6HeaderLen
+	}
+	return mtu - ipHeaderLen - icmpHeaderLen
+}
+
+// willFragment reports whether requests for test exceed the MTU of the interface without
+// DF, so that the kernel sends them in fragments. It is false if the MTU is unknown.
+func willFragment(cfg *config.Config, test Test, dst net.IP) bool {
+	mtu := cfg.General.Interface.MTU
+	return mtu > 0 && !cfg.General.SetDFBit && test.PayloadSize > maxUnfragmentedPayload(mtu, dst)
+}
+
+// replyFragmented reports whether an ICMP reply of size bytes is too large for the MTU of
+// the interface. The kernel reassembles fragments before the socket sees them, so this is
+// how a fragmented reply is detected; fragmentation elsewhere on the path goes unnoticed.
+func replyFragmented(cfg *config.Config, size int, src net.IP) bool {
+	mtu := cfg.General.Interface.MTU
+	return mtu > 0 && size-icmpHeaderLen > maxUnfragmentedPayload(mtu, src)
+}
+
+// newTestResult returns a result for test populated with the fields known before it runs.
+func newTestResult(cfg *config.Config, test Test) TestResult {
+	return TestResult{
 		Name:            test.Name,
 		Destination:     test.Destination,
-		RequestType:     test.RequestType.String(),
+		RequestType:     test.requestTypeName(),
 		ExpectedResult:  test.ExpectedResult,
 		Timestamp:       time.Now(),
-		SourceInterface: config.General.Interface.Name,
-		SourceIPAddress: config.General.SourceIPAddress.String(),
+		SourceInterface: cfg.General.Interface.Name,
+		SourceIPAddress: cfg.General.SourceIPAddress.String(),
+		VRF:             cfg.General.VRF,
+	}
+}
+
+// buildICMPPacket creates the ICMP request for test and marshals it to wire format.
+func buildICMPPacket(test Test) ([]byte, error) {
+	var msg *icmp.Message
+	var err error
+	if test.Raw {
+		msg = createRawICMPMessage(test.RequestType, test.ICMPCode, test.ID, test.Seq, test.Payload)
+	} else {
+		msg, err = createICMPMessage(test.RequestType, test.ID, test.Seq, test.PayloadSize, test.PayloadPreset)
+		if err != nil {
+			return nil, fmt.Errorf("createICMPMessage error: %w", err)
+		}
+		if echo, ok := msg.Body.(*icmp.Echo); ok && test.VerifyChecksum {
+			addChecksumMarker(echo.Data)
+		}
 	}
+	b, err := msg.Marshal(nil)
+	if err != nil {
+		return nil, fmt.Errorf("message marshal error: %w", err)
+	}
+	return b, nil
+}
+
+// dryRunICMPTest builds the packet for test without opening a socket or sending anything,
+// and returns a "DRY-RUN" result describing what would have been sent.
+func dryRunICMPTest(ctx context.Context, cfg *config.Config, test Test) TestResult {
+	result := newTestResult(cfg, test)
+	result.ActualResult = "N/A"
 
 	fail := func(format string, args ...interface{}) TestResult {
 		result.Status = "FAILED"
@@ -200,165 +522,1018 @@ func runICMPTest(config *Config, test Test) TestResult {
 		return result
 	}
 
-	localAddr := &net.IPAddr{IP: config.General.SourceIPAddress}
+	dst, resolveTime, err := test.resolve(ctx, cfg)
+	result.ResolveTime = resolveTime
+	if err != nil {
+		return fail("[error] test name: %s, %v", test.Name, err)
+	}
+
+	b, err := buildICMPPacket(test)
+	if err != nil {
+		return fail("[error] test name: %s, %v", test.Name, err)
+	}
+
+	result.RequestSize = len(b)
+	result.Fragmented = willFragment(cfg, test, dst.IP)
+	result.Status = "DRY-RUN"
+	result.Details = fmt.Sprintf("would send %s (%d bytes ICMP) to %v: id=%d seq=%d tos=0x%02x df=%t",
+		test.requestTypeName(), len(b), dst, test.ID, test.Seq, cfg.General.TOS, cfg.General.SetDFBit)
+	if test.TTL > 0 {
+		result.Details += fmt.Sprintf(" ttl=%d", test.TTL)
+	}
+	if cfg.General.SpoofSource != nil {
+		result.Details += fmt.Sprintf(" spoof_source=%v", cfg.General.SpoofSource)
+	}
+	if result.Fragmented {
+		result.Details += " (fragmented)"
+	}
+	return result
+}
+
+// applyRoutingOptions applies the general options that choose how conn's packets are
+// routed: vrf or bind_to_device, and fwmark.
+func applyRoutingOptions(cfg *config.Config, test Test, conn *net.IPConn) error {
+	if cfg.General.VRF != "" {
+		if err := bindToDevice(conn, cfg.General.VRF); err != nil {
+			return fmt.Errorf("binding to vrf failed: %v", err)
+		}
+		logger.Debug("socket option set", "test", test.Name, "option", "SO_BINDTODEVICE", "value", cfg.General.VRF)
+	} else if cfg.General.BindToDevice {
+		if err := bindToDevice(conn, cfg.General.Interface.Name); err != nil {
+			return fmt.Errorf("bind_to_device failed: %v", err)
+		}
+		logger.Debug("socket option set", "test", test.Name, "option", "SO_BINDTODEVICE", "value", cfg.General.Interface.Name)
+	}
+
+	// The mark selects the routing table through "ip rule add fwmark ..." policy rules.
+	if cfg.General.FWMark != 0 {
+		if err := setMark(conn, cfg.General.FWMark); err != nil {
+			return fmt.Errorf("fwmark failed: %v", err)
+		}
+		logger.Debug("socket option set", "test", test.Name, "option", "SO_MARK", "value", cfg.General.FWMark)
+	}
+	return nil
+}
+
+// openPacketConn opens the raw ICMP socket used by test and applies the general
+// socket options (device binding, DF bit, TOS, control messages) and the test's TTL. Closing the returned conn closes the socket.
+func openPacketConn(cfg *config.Config, test Test) (*ipv4.PacketConn, error) {
+	localAddr := &net.IPAddr{IP: cfg.General.SourceIPAddress}
 
 	ipconn, err := net.ListenIP("ip4:icmp", localAddr)
 	if err != nil {
-		log.Fatalf("ListenIP failed: %v\n", err)
+		if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EACCES) {
+			return nil, fmt.Errorf("ListenIP failed: %v: raw ICMP sockets need extra privileges; %s", err, PrivilegeRemedy)
+		}
+		return nil, fmt.Errorf("ListenIP failed: %v", err)
+	}
+
+	if err := applyRoutingOptions(cfg, test, ipconn); err != nil {
+		ipconn.Close()
+		return nil, err
 	}
-	defer ipconn.Close()
 
 	// Set DF bit at socket level if requested
-	if config.General.SetDFBit {
+	if cfg.General.SetDFBit {
 		if rawConn, err := ipconn.SyscallConn(); err == nil {
 			rawConn.Control(func(fd uintptr) {
 				// Try to set IP_DONTFRAG (macOS) or IP_MTU_DISCOVER (Linux)
 				if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, IP_DONTFRAG, 1); err != nil {
 					// On Linux, try IP_MTU_DISCOVER with IP_PMTUDISC_DO
 					if err := syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, IP_MTU_DISCOVER, IP_PMTUDISC_DO); err != nil {
-						log.Printf("Warning: Failed to set DF bit: %v", err)
+						logger.Warn("failed to set DF bit", "test", test.Name, "error", err)
+					} else {
+						logger.Debug("socket option set", "test", test.Name, "option", "IP_MTU_DISCOVER", "value", IP_PMTUDISC_DO)
 					}
+				} else {
+					logger.Debug("socket option set", "test", test.Name, "option", "IP_DONTFRAG", "value", 1)
 				}
 			})
 		}
 	}
 
-	pconn := ipv4.NewPacketConn(ipconn)
-	if err := pconn.SetTOS(config.General.TOS); err != nil {
-		log.Fatalf("SetTOS failed: %v\n", err)
+	// A larger receive buffer keeps replies from being dropped under flood and high-count
+	// tests. The kernel may clamp the request (on Linux to net.core.rmem_max), so read
+	// back what was granted.
+	if cfg.General.RcvBuf > 0 {
+		if err := ipconn.SetReadBuffer(cfg.General.RcvBuf); err != nil {
+			ipconn.Close()
+			return nil, fmt.Errorf("SetReadBuffer failed: %v", err)
+		}
+		if rawConn, err := ipconn.SyscallConn(); err == nil {
+			rawConn.Control(func(fd uintptr) {
+				granted, err := syscall.GetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_RCVBUF)
+				if err != nil {
+					return
+				}
+				logger.Debug("socket option set", "test", test.Name, "option", "SO_RCVBUF", "value", cfg.General.RcvBuf, "granted", granted)
+				if granted < cfg.General.RcvBuf {
+					logger.Warn("receive buffer clamped by the OS; replies may be dropped at high rates",
+						"test", test.Name, "requested", cfg.General.RcvBuf, "granted", granted)
+				}
+			})
+		}
 	}
 
-	if err := pconn.SetControlMessage(ipv4.FlagInterface, true); err != nil {
-		log.Fatalf("SetControlMessage failed: %v\n", err)
+	// Without SO_BROADCAST the kernel refuses to send to a broadcast address.
+	if test.Mode == "broadcast" {
+		rawConn, err := ipconn.SyscallConn()
+		if err != nil {
+			ipconn.Close()
+			return nil, fmt.Errorf("SO_BROADCAST failed: %v", err)
+		}
+		var sockErr error
+		rawConn.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_BROADCAST, 1)
+		})
+		if sockErr != nil {
+			ipconn.Close()
+			return nil, fmt.Errorf("SO_BROADCAST failed: %v", sockErr)
+		}
+		logger.Debug("socket option set", "test", test.Name, "option", "SO_BROADCAST", "value", 1)
 	}
 
-	dst, err := net.ResolveIPAddr("ip4", test.Destination)
-	if err != nil {
-		return fail("[error] test name: %s, ResolveIPAddr error: %v", test.Name, err)
+	// SO_DONTROUTE bypasses the routing table: only destinations on a directly connected
+	// subnet can be reached, and sending to any other fails.
+	if cfg.General.DontRoute {
+		rawConn, err := ipconn.SyscallConn()
+		if err != nil {
+			ipconn.Close()
+			return nil, fmt.Errorf("SO_DONTROUTE failed: %v", err)
+		}
+		var sockErr error
+		rawConn.Control(func(fd uintptr) {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_DONTROUTE, 1)
+		})
+		if sockErr != nil {
+			ipconn.Close()
+			return nil, fmt.Errorf("SO_DONTROUTE failed: %v", sockErr)
+		}
+		logger.Debug("socket option set", "test", test.Name, "option", "SO_DONTROUTE", "value", 1)
 	}
 
-	cm := &ipv4.ControlMessage{
-		IfIndex: config.General.Interface.Index,
-		Src:     config.General.SourceIPAddress,
+	// Flood and broadcast modes read replies without per-probe RTTs, so only probes sent
+	// by sendProbe use kernel receive timestamps.
+	if cfg.General.RXTimestamp && test.Mode == "" {
+		if err := enableRXTimestamps(ipconn); err != nil {
+			ipconn.Close()
+			return nil, fmt.Errorf("rx_timestamp failed: %v", err)
+		}
+		logger.Debug("socket option set", "test", test.Name, "option", "SO_TIMESTAMPNS", "value", 1)
 	}
 
-	// Check if fragmentation is needed based on interface MTU
-	mtu := config.General.Interface.MTU
-	maxPayloadSize := mtu - 28 // 20 bytes IP header + 8 bytes ICMP header
+	pconn := ipv4.NewPacketConn(ipconn)
+	if err := pconn.SetTOS(cfg.General.TOS); err != nil {
+		ipconn.Close()
+		return nil, fmt.Errorf("SetTOS failed: %v", err)
+	}
+	logger.Debug("socket option set", "test", test.Name, "option", "IP_TOS", "value", cfg.General.TOS)
 
-	start := time.Now()
+	if test.TTL > 0 {
+		if err := pconn.SetTTL(test.TTL); err != nil {
+			ipconn.Close()
+			return nil, fmt.Errorf("SetTTL failed: %v", err)
+		}
+		logger.Debug("socket option set", "test", test.Name, "option", "IP_TTL", "value", test.TTL)
+	}
 
-	if config.General.SetDFBit && test.PayloadSize > maxPayloadSize {
-		// DF bit is set and payload exceeds MTU - this will likely result in ICMP error
-		// Still attempt to send, but expect potential failure
-		fmt.Printf("Warning: DF bit set with payload size %d exceeding MTU %d. May receive ICMP error.\n",
-			test.PayloadSize, maxPayloadSize)
+	// Multicast requests leave through the test interface, with the test TTL if one is set.
+	if test.Mode == "broadcast" {
+		if err := pconn.SetMulticastInterface(&cfg.General.Interface); err != nil {
+			logger.Warn("failed to set multicast interface", "test", test.Name, "error", err)
+		}
+		if test.TTL > 0 {
+			if err := pconn.SetMulticastTTL(test.TTL); err != nil {
+				logger.Warn("failed to set multicast TTL", "test", test.Name, "error", err)
+			}
+		}
 	}
 
-	// Create and send ICMP message (kernel handles fragmentation automatically if needed)
-	msg, err := createICMPMessage(test.RequestType, test.ID, test.Seq, test.PayloadSize)
-	if err != nil {
-		return fail("[error] test name: %s, createICMPMessage error: %v", test.Name, err)
+	if err := pconn.SetControlMessage(ipv4.FlagInterface, true); err != nil {
+		ipconn.Close()
+		return nil, fmt.Errorf("SetControlMessage failed: %v", err)
+	}
+	// The reply TTL is reported in results, checked by assert_ttl_min/max and needed to
+	// reconstruct the IP header in a capture.
+	if err := pconn.SetControlMessage(ipv4.FlagTTL, true); err != nil {
+		if test.AssertTTLMin > 0 || test.AssertTTLMax > 0 {
+			ipconn.Close()
+			return nil, fmt.Errorf("SetControlMessage failed: %v: reply TTLs cannot be checked", err)
+		}
+		if packetCapture != nil {
+			logger.Warn("failed to enable TTL control message; capture will use TTL 0", "test", test.Name, "error", err)
+		}
 	}
+	return pconn, nil
+}
 
-	b, err := msg.Marshal(nil)
+// runICMPTest sends an ICMP request and waits until a reply with a matching (ID, Seq) is received.
+// It ignores any replies whose (ID, Seq) pair does not match the one sent. The overall timeout is applied.
+// If ctx is cancelled or its deadline passes while waiting, the test is marked as interrupted.
+func runICMPTest(ctx context.Context, cfg *config.Config, test Test) TestResult {
+	result := newTestResult(cfg, test)
+
+	fail := func(format string, args ...interface{}) TestResult {
+		result.Status = "FAILED"
+		result.Details = fmt.Sprintf(format, args...)
+		return result
+	}
+
+	pconn, err := openPacketConn(cfg, test)
 	if err != nil {
-		return fail("[error] test name: %s, message marshal error: %v", test.Name, err)
+		return fail("%v", err)
 	}
+	defer pconn.Close()
 
-	// Send ICMP packet - kernel will fragment automatically if needed and DF bit is not set
-	n, err := pconn.WriteTo(b, cm, dst)
+	// Requests go out through pconn unless spoof_source asks for IP headers built here;
+	// replies are read from pconn either way.
+	var send packetWriter = pconn
+	if cfg.General.SpoofSource != nil {
+		spoofed, err := openSpoofedSender(cfg, test)
+		if err != nil {
+			return fail("%v", err)
+		}
+		defer spoofed.Close()
+		send = spoofed
+		result.Notes = append(result.Notes, fmt.Sprintf("requests sent from spoofed source %v", cfg.General.SpoofSource))
+	}
+
+	dst, resolveTime, err := test.resolve(ctx, cfg)
+	result.ResolveTime = resolveTime
 	if err != nil {
-		return fail("WriteTo error: %v", err)
+		return fail("[error] test name: %s, %v", test.Name, err)
 	}
-	if n != len(b) {
-		return fail("sent %d bytes, expected %d", n, len(b))
+
+	cm := &ipv4.ControlMessage{
+		IfIndex: cfg.General.Interface.Index,
+		Src:     cfg.General.SourceIPAddress,
 	}
 
-	deadline := time.Now().Add(test.Timeout)
-	if err = pconn.SetReadDeadline(deadline); err != nil {
-		return fail("SetReadDeadline error: %v", err)
+	// Check if fragmentation is needed based on interface MTU
+	mtu := cfg.General.Interface.MTU
+	maxPayloadSize := maxUnfragmentedPayload(mtu, dst.IP)
+
+	if willFragment(cfg, test, dst.IP) {
+		result.Fragmented = true
+		if test.FailOnFragmentation {
+			return fail("payload size %d exceeds maximum unfragmented payload %d (MTU %d) and fail_on_fragmentation is set",
+				test.PayloadSize, maxPayloadSize, mtu)
+		}
+		result.Notes = append(result.Notes, fmt.Sprintf("payload size %d exceeds maximum unfragmented payload %d (MTU %d); request will be fragmented",
+			test.PayloadSize, maxPayloadSize, mtu))
+	}
+
+	if cfg.General.SetDFBit && test.PayloadSize > maxPayloadSize {
+		// DF bit is set and payload exceeds MTU - this will likely result in ICMP error
+		// Still attempt to send, but expect potential failure
+		logger.Warn("DF bit set with payload exceeding MTU; may receive ICMP error",
+			"test", test.Name, "payload_size", test.PayloadSize, "max_payload_size", maxPayloadSize)
+	}
+
+	// Unblock ReadFrom as soon as the suite context is done.
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			pconn.SetReadDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	count := test.Count
+	if count < 1 {
+		count = 1
+	}
+
+	// Every probe differs only in Seq, so they all have the size of the first.
+	if b, err := buildICMPPacket(test); err == nil {
+		result.RequestSize = len(b)
 	}
 
 	resp := make([]byte, 1500)
-	for {
-		// ignore control message for now
-		n, _, peer, err := pconn.ReadFrom(resp)
-		elapsed := time.Since(start)
-		if err != nil {
-			// timeout occurred
-			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
-				result.Duration = elapsed
-				result.ActualResult = "timeout"
-				if test.ExpectedResult != "timeout" {
-					return fail("expected response, but timed out after %v waiting for matching message", test.Timeout)
+	start := time.Now()
+	rtts := newRTTSamples(test.maxRTTSamples)
+	var ttlErr error                      // first reply outside assert_ttl_min/assert_ttl_max
+	unanswered := make(map[int]time.Time) // collect_grace: send times of counted probes that timed out, by Seq
+	// collect_grace: from the first unanswered probe on, counted probes wait here in send
+	// order, so that late replies join rtts at their probe's place and jitter sees RTTs in order.
+	var pending []pendingProbe
+	for k := 0; k < test.Warmup+count; k++ {
+		if k > 0 && test.Interval > 0 {
+			select {
+			case <-time.After(test.Interval):
+			case <-ctx.Done():
+			}
+		}
+		if test.Deadline > 0 && k > test.Warmup && time.Since(start) >= test.Deadline {
+			result.Notes = append(result.Notes, fmt.Sprintf("deadline %v reached after %d of %d probes", test.Deadline, result.PacketsSent, count))
+			break
+		}
+		if ctx.Err() != nil {
+			result.Duration = time.Since(start)
+			result.ActualResult = "interrupted"
+			return fail("interrupted: %v", ctx.Err())
+		}
+
+		probe := test
+		probe.Seq = (test.Seq + k) & 0xffff
+		sent := time.Now()
+		reply, err := sendProbe(ctx, cfg, pconn, send, cm, dst, probe, resp)
+		// Single-probe tests may resend a probe that timed out or met a transient ICMP
+		// error, interval apart so as not to trip ICMP rate limits. A permanent error,
+		// such as admin-prohibited, ends the test at once: retrying cannot change it.
+		for retry := 1; retry <= test.Retries && ctx.Err() == nil && retryable(reply, err); retry++ {
+			outcome := "timed out"
+			if err != nil {
+				outcome = err.Error()
+			}
+			result.Notes = append(result.Notes, fmt.Sprintf("probe seq %d %s; retry %d of %d", probe.Seq, outcome, retry, test.Retries))
+			select {
+			case <-time.After(test.Interval):
+			case <-ctx.Done():
+			}
+			probe.Seq = (test.Seq + retry) & 0xffff
+			result.Retries = retry
+			result.BytesSent += result.RequestSize // the probe itself is counted below
+			reply, err = sendProbe(ctx, cfg, pconn, send, cm, dst, probe, resp)
+		}
+		var unreachable *unreachableError
+		unreachableLoss := false // a counted probe of several lost to Destination Unreachable
+		if errors.As(err, &unreachable) {
+			switch {
+			case test.expects("unreachable"):
+				// buildTest limits "unreachable" tests to a single probe.
+				result.PacketsSent = 1
+				result.BytesSent = result.RequestSize
+				result.ReplySize = reply.Size
+				result.RawReply = reply.Raw
+				result.BytesReceived = reply.Size
+				result.Duration = reply.RTT
+				result.ActualResult = fmt.Sprintf("unreachable (%s)", unreachableCodeName(unreachable.Code))
+				if test.ExpectedCode >= 0 && unreachable.Code != test.ExpectedCode {
+					return fail("expected destination unreachable (%s, code %d), but got %v",
+						unreachableCodeName(test.ExpectedCode), test.ExpectedCode, err)
 				}
 				result.Status = "PASSED"
-				result.Details = fmt.Sprintf("expected timeout occurred (after %v)", test.Timeout)
+				result.Details = fmt.Sprintf("received expected %v", err)
 				return result
+			case test.expects("timeout"):
+				// No reply is what a "timeout" test expects; the error only explains why.
+				result.Notes = append(result.Notes, fmt.Sprintf("probe seq %d: %v", probe.Seq, err))
+				reply, err = nil, nil
+			case count > 1 && k >= test.Warmup:
+				// One probe of several is lost; the loss threshold decides the test.
+				result.Notes = append(result.Notes, fmt.Sprintf("probe seq %d lost: %v", probe.Seq, err))
+				reply, err = nil, nil
+				unreachableLoss = true
 			}
-			result.Duration = elapsed
-			return fail("ReadFrom error: %v", err)
 		}
-
-		parsedMsg, err := icmp.ParseMessage(1, resp[:n])
-		if err != nil {
-			// if the message is not ICMP, ignore it
+		if k < test.Warmup {
+			// Warmup probes prime ARP and route caches; their outcome is not counted.
+			if ctx.Err() != nil {
+				result.Duration = time.Since(start)
+				result.ActualResult = "interrupted"
+				return fail("interrupted: %v", ctx.Err())
+			}
+			logger.Debug("warmup probe", "test", test.Name, "seq", probe.Seq, "answered", reply != nil, "error", err)
 			continue
 		}
-
-		var matched bool
-		switch body := parsedMsg.Body.(type) {
-		case *icmp.Echo:
-			matched = (body.ID == test.ID && body.Seq == test.Seq)
-		case *icmpTimestamp:
-			matched = (body.ID == test.ID && body.Seq == test.Seq)
-		case *icmp.RawBody:
-			if len(body.Data) >= 4 {
-				replyID := int(body.Data[0])<<8 | int(body.Data[1])
-				replySeq := int(body.Data[2])<<8 | int(body.Data[3])
-				matched = (replyID == test.ID && replySeq == test.Seq)
+		if err != nil {
+			result.Duration = time.Since(start)
+			// suite context done (timeout budget exceeded or cancelled)
+			if ctx.Err() != nil {
+				result.ActualResult = "interrupted"
+				return fail("interrupted: %v", ctx.Err())
 			}
+			if reply != nil {
+				result.ActualResult = fmt.Sprintf("%s", reply.Type)
+				result.ReplySize = reply.Size
+				result.RawReply = reply.Raw
+			}
+			return fail("%v", err)
 		}
-		if !matched {
-			// ignore non-matching messages
-			continue
+		result.PacketsSent++
+		result.BytesSent += result.RequestSize
+		if reply != nil {
+			result.ReplySize = reply.Size
+			result.ReplyTTL = reply.TTL
+			result.RawReply = reply.Raw
+			result.ReplyFragmented = replyFragmented(cfg, reply.Size, dst.IP)
+			result.BytesReceived += reply.Size
 		}
 
-		// At this point, we have received a matching reply.
-		result.Duration = elapsed
-		result.ActualResult = fmt.Sprintf("%s", parsedMsg.Type)
-
-		// Check if a response was not expected.
-		if test.ExpectedResult == "timeout" {
-			return fail("received response %s from %v, but expected timeout", parsedMsg.Type, peer)
+		if count == 1 {
+			// Single-probe test: the outcome of the one probe is the outcome of the test.
+			if reply == nil {
+				result.Duration = time.Since(start)
+				result.ActualResult = "timeout"
+				if test.Linger > 0 {
+					// Late replies do not change the outcome; they only tell "slow" from "none".
+					if late := awaitLateReply(ctx, cfg, pconn, probe, start, resp); late != nil {
+						result.Notes = append(result.Notes, fmt.Sprintf("late reply %s from %v after %v (linger %v)",
+							late.Type, late.Peer, late.RTT.Round(time.Microsecond), test.Linger))
+					} else if ctx.Err() == nil {
+						result.Notes = append(result.Notes, fmt.Sprintf("no late reply within linger %v", test.Linger))
+					}
+				}
+				if !test.expects("timeout") {
+					return fail("expected %s, but timed out after %v waiting for matching message", test.ExpectedResult, test.Timeout)
+				}
+				result.Status = "PASSED"
+				result.Details = fmt.Sprintf("expected timeout occurred (after %v)", test.Timeout)
+				return result
+			}
+			result.PacketsReceived = 1
+			result.Duration = reply.RTT
+			result.FirstReplyRTT = reply.RTT
+			recordReplyInterface(&result, cfg, dst, reply)
+			result.ActualResult = fmt.Sprintf("%s", reply.Type)
+			if !test.expects("response") {
+				return fail("received response %s from %v, but expected %s", reply.Type, reply.Peer, test.ExpectedResult)
+			}
+			if test.AssertRTTBelow > 0 && reply.RTT >= test.AssertRTTBelow {
+				return fail("RTT %v is not below assert_rtt_below %v (response %s from %v)",
+					reply.RTT, test.AssertRTTBelow, reply.Type, reply.Peer)
+			}
+			if err := checkTTLAssertion(test, reply); err != nil {
+				return fail("%v", err)
+			}
+			result.Status = "PASSED"
+			result.Details = fmt.Sprintf("received expected response %s from %v", reply.Type, reply.Peer)
+			return result
 		}
 
-		expectedICMPResponseType, err := getICMPResponseType(test)
-		if err != nil {
-			continue
-		}
-		if parsedMsg.Type != expectedICMPResponseType {
-			isSelf := false
-			ip := net.ParseIP(test.Destination)
-			if ip != nil && (ip.IsLoopback() || ip.Equal(config.General.SourceIPAddress)) {
-				isSelf = true
+		if reply != nil {
+			if err := checkTTLAssertion(test, reply); err != nil && ttlErr == nil {
+				ttlErr = fmt.Errorf("probe seq %d: %v", probe.Seq, err)
 			}
-			if isSelf && (parsedMsg.Type == ipv4.ICMPTypeEcho || parsedMsg.Type == ipv4.ICMPTypeTimestamp) {
-				continue
+			if pending != nil {
+				pending = append(pending, pendingProbe{Seq: probe.Seq, RTT: reply.RTT, Answered: true})
+			} else {
+				if rtts.count() == 0 {
+					result.FirstReplyRTT = reply.RTT
+				}
+				rtts.add(reply.RTT)
 			}
-			return fail("received unexpected ICMP type %s from %v (expected %s)", parsedMsg.Type, peer, expectedICMPResponseType)
+			recordReplyInterface(&result, cfg, dst, reply)
+		} else if test.CollectGrace > 0 && !unreachableLoss {
+			unanswered[probe.Seq] = sent
+			pending = append(pending, pendingProbe{Seq: probe.Seq})
 		}
+		if reply == nil && test.StopOnLoss {
+			result.Notes = append(result.Notes, fmt.Sprintf("stopped at the first loss (probe %d of %d); %d answered before it",
+				result.PacketsSent, count, rtts.count()))
+			break
+		}
+	}
 
+	// Multi-probe test: evaluate the collected samples.
+	result.Duration = time.Since(start)
+	if len(unanswered) > 0 {
+		// The grace period counts against the deadline; the suite timeout bounds it too.
+		grace := test.CollectGrace
+		if test.Deadline > 0 && test.Deadline-result.Duration < grace {
+			grace = test.Deadline - result.Duration
+		}
+		var late map[int]probeReply
+		if grace > 0 {
+			late = collectLateReplies(ctx, cfg, pconn, test, unanswered, grace, resp)
+		}
+		addPendingRTTs(&result, rtts, pending, late)
+		result.Notes = append(result.Notes, fmt.Sprintf("collect_grace %v: %d of %d unanswered probes answered late",
+			grace, len(late), len(late)+len(unanswered)))
+	}
+	setRTTStats(&result, rtts)
+	result.ActualResult = fmt.Sprintf("%d/%d replies", result.PacketsReceived, result.PacketsSent)
+	summary := probeSummary(result)
+
+	if test.ExpectedResult == "timeout" {
+		result.UnexpectedReplies = result.PacketsReceived
+		if result.PacketsReceived > test.MaxUnexpected {
+			return fail("expected timeout, but %s%s", summary, unexpectedAllowance(test))
+		}
 		result.Status = "PASSED"
-		result.Details = fmt.Sprintf("received expected response %s from %v", parsedMsg.Type, peer)
+		if result.PacketsReceived > 0 {
+			result.Details = fmt.Sprintf("expected timeout occurred; %d unexpected replies tolerated by max_unexpected_replies %d (%s)",
+				result.PacketsReceived, test.MaxUnexpected, summary)
+		} else {
+			result.Details = fmt.Sprintf("expected timeout occurred for all probes (%s)", summary)
+		}
 		return result
 	}
+	if test.AssertLoss {
+		if loss := lossPercent(result); loss > test.MaxLoss {
+			return fail("packet loss %.1f%% exceeds assert_loss_below %g%% (%s)", loss, test.MaxLoss, summary)
+		}
+	} else if result.PacketsReceived < result.PacketsSent {
+		return fail("expected response to every probe, but %s", summary)
+	}
+	if err := checkRTTLimits(result, test); err != nil {
+		return fail("%v (%s)", err, summary)
+	}
+	if err := checkRTTAssertion(result, test); err != nil {
+		return fail("%v (%s)", err, summary)
+	}
+	if ttlErr != nil {
+		return fail("%v (%s)", ttlErr, summary)
+	}
+	result.Status = "PASSED"
+	result.Details = summary
+	return result
 }
 
-// getICMPResponseType returns expected response types based on the test.
-func getICMPResponseType(test Test) (ipv4.ICMPType, error) {
-	switch test.RequestType.String() {
+// lossPercent returns the percentage of sent probes in result that were not answered.
+func lossPercent(result TestResult) float64 {
+	if result.PacketsSent == 0 {
+		return 0
+	}
+	return 100 * float64(result.PacketsSent-result.PacketsReceived) / float64(result.PacketsSent)
+}
+
+// parsePercent parses a percentage such as "10%", "0.5%" or "10" in the range 0-100.
+func parsePercent(s string) (float64, error) {
+	v, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(s), "%")), 64)
+	if err != nil || v < 0 || v > 100 {
+		return 0, fmt.Errorf("must be a percentage between 0%% and 100%%")
+	}
+	return v, nil
+}
+
+// rttStat returns the RTT statistic stat of result.
+func rttStat(result TestResult, stat string) time.Duration {
+	switch stat {
+	case "min":
+		return result.MinRTT
+	case "max":
+		return result.MaxRTT
+	case "p50":
+		return result.P50RTT
+	case "p95":
+		return result.P95RTT
+	case "p99":
+		return result.P99RTT
+	}
+	return result.AvgRTT
+}
+
+// checkTTLAssertion returns an error if test has an assert_ttl_min or assert_ttl_max bound
+// and the TTL of reply is outside it, e.g. because a routing change added or removed hops.
+func checkTTLAssertion(test Test, reply *probeReply) error {
+	switch {
+	case test.AssertTTLMin == 0 && test.AssertTTLMax == 0:
+		return nil
+	case reply.TTL == 0:
+		return fmt.Errorf("TTL of the reply from %v is unknown; cannot check assert_ttl_min/assert_ttl_max", reply.Peer)
+	case test.AssertTTLMin > 0 && reply.TTL < test.AssertTTLMin:
+		return fmt.Errorf("reply TTL %d from %v is below assert_ttl_min %d", reply.TTL, reply.Peer, test.AssertTTLMin)
+	case test.AssertTTLMax > 0 && reply.TTL > test.AssertTTLMax:
+		return fmt.Errorf("reply TTL %d from %v is above assert_ttl_max %d", reply.TTL, reply.Peer, test.AssertTTLMax)
+	}
+	return nil
+}
+
+// checkRTTAssertion returns an error if test has an assert_rtt_below threshold and the
+// chosen RTT statistic of result is not below it.
+func checkRTTAssertion(result TestResult, test Test) error {
+	if test.AssertRTTBelow <= 0 {
+		return nil
+	}
+	if rtt := rttStat(result, test.AssertRTTStat); rtt >= test.AssertRTTBelow {
+		return fmt.Errorf("%s RTT %v is not below assert_rtt_below %v", test.AssertRTTStat, rtt, test.AssertRTTBelow)
+	}
+	return nil
+}
+
+// checkRTTLimits returns an error if result's jitter exceeds test's max_jitter or its
+// p99 RTT exceeds max_p99.
+func checkRTTLimits(result TestResult, test Test) error {
+	if test.MaxJitter > 0 && result.Jitter > test.MaxJitter {
+		return fmt.Errorf("jitter %v exceeds max_jitter %v", result.Jitter, test.MaxJitter)
+	}
+	if test.MaxP99 > 0 && result.P99RTT > test.MaxP99 {
+		return fmt.Errorf("p99 RTT %v exceeds max_p99 %v", result.P99RTT, test.MaxP99)
+	}
+	return nil
+}
+
+// probeReply describes a reply matched to a single probe.
+type probeReply struct {
+	Type    icmp.Type
+	Peer    net.Addr
+	RTT     time.Duration
+	IfIndex int    // Interface the reply arrived on; 0 if unknown
+	Size    int    // ICMP message bytes received
+	TTL     int    // IP TTL of the reply; 0 if unknown
+	Raw     []byte // Copy of the received ICMP message if general.include_raw_reply is set
+}
+
+// sendProbe sends one request for test and waits up to test.Timeout for a message with a
+// matching (ID, Seq), ignoring everything else. It returns a nil reply if none arrives in time.
+// A non-nil error means the test cannot pass; reply is also set when the error is about a
+// received message (e.g. an unexpected ICMP type). The request is written to send, normally
+// pconn itself, and replies are read from pconn.
+func sendProbe(ctx context.Context, cfg *config.Config, pconn *ipv4.PacketConn, send packetWriter, cm *ipv4.ControlMessage, dst *net.IPAddr, test Test, resp []byte) (*probeReply, error) {
+	start := time.Now()
+
+	// Create and send ICMP message (kernel handles fragmentation automatically if needed)
+	b, err := buildICMPPacket(test)
+	if err != nil {
+		return nil, fmt.Errorf("[error] test name: %s, %v", test.Name, err)
+	}
+
+	// Send ICMP packet - kernel will fragment automatically if needed and DF bit is not set
+	n, err := send.WriteTo(b, cm, dst)
+	if err != nil {
+		if cfg.General.DontRoute && errors.Is(err, syscall.ENETUNREACH) {
+			return nil, fmt.Errorf("%v is not on a directly connected subnet (dont_route): %v", dst, err)
+		}
+		return nil, fmt.Errorf("WriteTo error: %v", err)
+	}
+	if err := checkWrite(n, len(b), dst); err != nil {
+		return nil, err
+	}
+	if packetCapture != nil || logger.Enabled(ctx, slog.LevelDebug) {
+		ttl, _ := pconn.TTL()
+		recordSent(ctx, cfg, test, dst, ttl, b)
+	}
+
+	self := isSelfDestination(cfg, dst)
+	deadline := time.Now().Add(test.Timeout)
+	if err = pconn.SetReadDeadline(deadline); err != nil {
+		return nil, fmt.Errorf("SetReadDeadline error: %v", err)
+	}
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+
+	for {
+		n, rcm, peer, elapsed, err := readReply(cfg, pconn, resp, start)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, ctx.Err()
+			}
+			// timeout occurred
+			if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+				return nil, nil
+			}
+			return nil, fmt.Errorf("ReadFrom error: %v", err)
+		}
+		recordReceived(ctx, cfg, test, peer, rcm, resp[:n])
+
+		parsedMsg, err := icmp.ParseMessage(1, resp[:n])
+		if err != nil {
+			// if the message is not ICMP, ignore it
+			continue
+		}
+
+		// ICMP errors quote our request; match the ID/Seq of the quoted inner packet,
+		// since the outer message carries none of its own.
+		if quoted, ok := quotedErrorRequest(parsedMsg); ok {
+			if !quoted.matches(test, dst.IP) {
+				continue
+			}
+			reply := &probeReply{Type: parsedMsg.Type, Peer: peer, RTT: elapsed, Size: n, Raw: rawReply(cfg, resp[:n])}
+			switch parsedMsg.Type {
+			case ipv4.ICMPTypeDestinationUnreachable:
+				return reply, &unreachableError{Code: parsedMsg.Code, Peer: peer}
+			case ipv4.ICMPTypeTimeExceeded:
+				// A TTL too low to reach dst ends up here.
+				if test.TTL > 0 {
+					return reply, fmt.Errorf("TTL %d exceeded in transit: time exceeded from %v", test.TTL, peer)
+				}
+				return reply, fmt.Errorf("time exceeded in transit from %v", peer)
+			default:
+				return reply, fmt.Errorf("received %s (code %d) for the request from %v", parsedMsg.Type, parsedMsg.Code, peer)
+			}
+		}
+		if !matchesProbe(parsedMsg, test) {
+			// ignore non-matching messages
+			continue
+		}
+
+		// A request carrying our ID/Seq is never the reply: for self destinations it is
+		// the looped-back copy of our own request, otherwise a peer pinging us with the
+		// same ID/Seq, as can happen when two hosts ping each other simultaneously.
+		if isRequestNotReply(test, parsedMsg.Type) {
+			if !self {
+				logger.Debug("ignoring request carrying this probe's ID/Seq", "test", test.Name, "type", parsedMsg.Type, "peer", peer)
+			}
+			continue
+		}
+
+		// At this point, we have received a matching reply.
+		reply := &probeReply{Type: parsedMsg.Type, Peer: peer, RTT: elapsed, Size: n, Raw: rawReply(cfg, resp[:n])}
+		if rcm != nil {
+			reply.IfIndex = rcm.IfIndex
+			reply.TTL = rcm.TTL
+		}
+
+		// A response was not expected; let the caller decide how to report it.
+		if !test.expects("response") {
+			return reply, nil
+		}
+
+		// The reply type to a raw request cannot be predicted; any matching message counts.
+		if test.Raw {
+			return reply, nil
+		}
+
+		expectedICMPResponseType, err := getICMPResponseType(test)
+		if err != nil {
+			continue
+		}
+		if parsedMsg.Type != expectedICMPResponseType {
+			return reply, fmt.Errorf("received unexpected ICMP type %s from %v (expected %s)", parsedMsg.Type, peer, expectedICMPResponseType)
+		}
+
+		// Replies to loopback/self destinations come from a local address and are exempt.
+		if test.VerifySource && !self {
+			if peerAddr, ok := peer.(*net.IPAddr); !ok || !peerAddr.IP.Equal(dst.IP) {
+				return reply, fmt.Errorf("received %s from unexpected source %v (expected %v)", parsedMsg.Type, peer, dst.IP)
+			}
+		}
+
+		if echo, ok := parsedMsg.Body.(*icmp.Echo); ok && test.VerifyChecksum {
+			if err := checkChecksumMarker(echo.Data, test.PayloadSize); err != nil {
+				return reply, fmt.Errorf("%v (%s from %v)", err, parsedMsg.Type, peer)
+			}
+		}
+
+		return reply, nil
+	}
+}
+
+// rawReply returns a copy of msg for probeReply.Raw if general.include_raw_reply is set,
+// and nil otherwise; msg itself is the shared receive buffer.
+func rawReply(cfg *config.Config, msg []byte) []byte {
+	if !cfg.General.IncludeRawReply {
+		return nil
+	}
+	return slices.Clone(msg)
+}
+
+// checkWrite returns an error if a write of want bytes to dst reported n bytes sent.
+// Raw IP sockets send each datagram whole or fail with an error (e.g. EMSGSIZE) on
+// every supported platform, so a count other than want is not a partial send to be
+// completed: it means the request did not leave as built, and no reply can be trusted.
+func checkWrite(n, want int, dst net.Addr) error {
+	if n != want {
+		return fmt.Errorf("short write to %v: sent %d of %d bytes; the request was not sent intact", dst, n, want)
+	}
+	return nil
+}
+
+// matchesProbe reports whether msg carries the ID and Seq of test's request.
+func matchesProbe(msg *icmp.Message, test Test) bool {
+	id, seq, ok := probeIDSeq(msg)
+	return ok && id == test.ID && seq == test.Seq
+}
+
+// probeIDSeq returns the ID and Seq carried by msg, if it is of a kind that has them.
+func probeIDSeq(msg *icmp.Message) (id, seq int, ok bool) {
+	switch body := msg.Body.(type) {
+	case *icmp.Echo:
+		return body.ID, body.Seq, true
+	case *icmpTimestamp:
+		return body.ID, body.Seq, true
+	case *icmp.RawBody:
+		if len(body.Data) >= 4 {
+			return int(body.Data[0])<<8 | int(body.Data[1]), int(body.Data[2])<<8 | int(body.Data[3]), true
+		}
+	}
+	return 0, 0, false
+}
+
+// awaitLateReply keeps reading for test.Linger after a probe timed out and returns the
+// first message matching the probe, with its RTT measured from sent. It returns nil if
+// nothing matched before the linger period ended or ctx was done.
+func awaitLateReply(ctx context.Context, cfg *config.Config, pconn *ipv4.PacketConn, test Test, sent time.Time, resp []byte) *probeReply {
+	if err := pconn.SetReadDeadline(time.Now().Add(test.Linger)); err != nil || ctx.Err() != nil {
+		return nil
+	}
+	for {
+		n, _, peer, elapsed, err := readReply(cfg, pconn, resp, sent)
+		if err != nil {
+			return nil
+		}
+		parsedMsg, err := icmp.ParseMessage(1, resp[:n])
+		if err != nil || !matchesProbe(parsedMsg, test) {
+			continue
+		}
+		if isRequestNotReply(test, parsedMsg.Type) {
+			continue
+		}
+		return &probeReply{Type: parsedMsg.Type, Peer: peer, RTT: elapsed}
+	}
+}
+
+// pendingProbe is a counted probe of a multi-probe test whose RTT waits for collect_grace.
+type pendingProbe struct {
+	Seq      int
+	RTT      time.Duration
+	Answered bool // Answered in time; otherwise RTT comes from a late reply, if any
+}
+
+// addPendingRTTs adds the RTTs of pending to rtts in probe order, taking those of
+// unanswered probes from their late replies, by Seq, and skipping the rest.
+func addPendingRTTs(result *TestResult, rtts *rttSamples, pending []pendingProbe, late map[int]probeReply) {
+	for _, p := range pending {
+		if !p.Answered {
+			reply, ok := late[p.Seq]
+			if !ok {
+				continue
+			}
+			p.RTT = reply.RTT
+			result.BytesReceived += reply.Size
+		}
+		if rtts.count() == 0 {
+			result.FirstReplyRTT = p.RTT
+		}
+		rtts.add(p.RTT)
+	}
+}
+
+// collectLateReplies keeps reading for up to grace, or until every probe in unanswered
+// has been answered or ctx's deadline passes, and returns the replies to those probes by
+// Seq, each with its RTT measured from its send time. Answered probes are removed from
+// unanswered. A reply arriving while a later probe was being waited for has already been
+// discarded and is not seen.
+func collectLateReplies(ctx context.Context, cfg *config.Config, pconn *ipv4.PacketConn, test Test, unanswered map[int]time.Time, grace time.Duration, resp []byte) map[int]probeReply {
+	end := time.Now().Add(grace)
+	if d, ok := ctx.Deadline(); ok && d.Before(end) {
+		end = d
+	}
+	if err := pconn.SetReadDeadline(end); err != nil || ctx.Err() != nil {
+		return nil
+	}
+	var base time.Time
+	for _, sent := range unanswered {
+		if base.IsZero() || sent.Before(base) {
+			base = sent
+		}
+	}
+	late := make(map[int]probeReply)
+	for len(unanswered) > 0 {
+		n, _, peer, elapsed, err := readReply(cfg, pconn, resp, base)
+		if err != nil {
+			break
+		}
+		parsedMsg, err := icmp.ParseMessage(1, resp[:n])
+		if err != nil || isRequestNotReply(test, parsedMsg.Type) {
+			continue
+		}
+		id, seq, ok := probeIDSeq(parsedMsg)
+		if !ok || id != test.ID {
+			continue
+		}
+		sent, ok := unanswered[seq]
+		if !ok {
+			continue
+		}
+		delete(unanswered, seq)
+		late[seq] = probeReply{Type: parsedMsg.Type, Peer: peer, RTT: elapsed - sent.Sub(base), Size: n}
+	}
+	return late
+}
+
+// readReply reads one packet from pconn into b and returns the time elapsed since sent.
+// With general.rx_timestamp the elapsed time ends at the kernel's receive timestamp
+// rather than when the read returned, leaving out scheduling delay in this process.
+func readReply(cfg *config.Config, pconn *ipv4.PacketConn, b []byte, sent time.Time) (int, *ipv4.ControlMessage, net.Addr, time.Duration, error) {
+	if !cfg.General.RXTimestamp {
+		n, cm, peer, err := pconn.ReadFrom(b)
+		return n, cm, peer, time.Since(sent), err
+	}
+	n, cm, peer, rx, err := readFromWithTimestamp(pconn, b)
+	elapsed := time.Since(sent)
+	if !rx.IsZero() {
+		// The kernel timestamp is wall-clock time, so compare it with sent's wall clock.
+		elapsed = rx.Sub(sent.Round(0))
+	}
+	return n, cm, peer, elapsed, err
+}
+
+// isSelfDestination reports whether dst is a loopback address, the source address or any
+// other address assigned to this host. Requests to such destinations never leave the host:
+// the kernel answers them itself and also delivers a copy of the request to raw sockets.
+func isSelfDestination(cfg *config.Config, dst *net.IPAddr) bool {
+	if dst.IP.IsLoopback() || dst.IP.Equal(cfg.General.SourceIPAddress) {
+		return true
+	}
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && ipNet.IP.Equal(dst.IP) {
+			return true
+		}
+	}
+	return false
+}
+
+// isRequestNotReply reports whether a message of type msgType carrying test's ID/Seq is a
+// request of test's own type rather than a reply to it: the looped-back copy of the request
+// for a self-addressed destination, or a peer's request with the same ID/Seq. For timestamp
+// requests to loopback the request itself is the expected message (see
+// getICMPResponseType), so it does not count as one.
+func isRequestNotReply(test Test, msgType icmp.Type) bool {
+	if msgType != test.RequestType {
+		return false
+	}
+	if test.Raw {
+		return true
+	}
+	expected, err := getICMPResponseType(test)
+	return err != nil || msgType != expected
+}
+
+// recordReplyInterface sets result.ReplyInterface to the interface reply arrived on and
+// adds a note the first time it differs from the egress interface, which points to
+// asymmetric routing. Replies from loopback or self destinations are not compared.
+func recordReplyInterface(result *TestResult, cfg *config.Config, dst *net.IPAddr, reply *probeReply) {
+	if reply.IfIndex == 0 {
+		return
+	}
+	result.ReplyInterface = interfaceNameByIndex(reply.IfIndex)
+	if isSelfDestination(cfg, dst) {
+		return
+	}
+	if reply.IfIndex != cfg.General.Interface.Index && !result.ReplyInterfaceMismatch {
+		result.ReplyInterfaceMismatch = true
+		result.Notes = append(result.Notes, fmt.Sprintf("reply arrived on interface %s, but requests were sent on %s",
+			result.ReplyInterface, cfg.General.Interface.Name))
+	}
+}
+
+// interfaceNameByIndex returns the name of the interface with the given index,
+// or "ifindex N" if it cannot be looked up.
+func interfaceNameByIndex(index int) string {
+	iface, err := net.InterfaceByIndex(index)
+	if err != nil {
+		return fmt.Sprintf("ifindex %d", index)
+	}
+	return iface.Name
+}
+
+// setRTTStats records the number of answered probes and their RTT statistics in result.
+// Percentiles estimated from a capped sample are noted.
+func setRTTStats(result *TestResult, rtts *rttSamples) {
+	result.PacketsReceived = rtts.count()
+	result.MinRTT, result.AvgRTT, result.MaxRTT, result.Jitter = rtts.summary()
+	if rtts.sampled() {
+		result.Notes = append(result.Notes, fmt.Sprintf("percentiles estimated from %d of %d RTT samples", len(rtts.kept), rtts.count()))
+	}
+	if rtts.count() > 0 {
+		sorted := append([]time.Duration(nil), rtts.kept...)
+		sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+		result.P50RTT = percentile(sorted, 50)
+		result.P95RTT = percentile(sorted, 95)
+		result.P99RTT = percentile(sorted, 99)
+	}
+}
+
+// probeSummary describes the probe counts and RTT statistics of result for Details.
+func probeSummary(result TestResult) string {
+	summary := fmt.Sprintf("%d/%d probes answered", result.PacketsReceived, result.PacketsSent)
+	if result.PacketsReceived > 0 {
+		summary += fmt.Sprintf(", rtt min/avg/max = %v/%v/%v, p50/p95/p99 = %v/%v/%v, jitter %v",
+			result.MinRTT, result.AvgRTT, result.MaxRTT, result.P50RTT, result.P95RTT, result.P99RTT, result.Jitter)
+	}
+	return summary
+}
+
+// unexpectedAllowance returns the suffix noting test's max_unexpected_replies in a
+// failed "timeout" test's Details, or "" if none are tolerated.
+func unexpectedAllowance(test Test) string {
+	if test.MaxUnexpected == 0 {
+		return ""
+	}
+	return fmt.Sprintf(" (max_unexpected_replies %d)", test.MaxUnexpected)
+}
+
+// percentile returns the p-th percentile (0-100) of sorted, linearly interpolating
+// between the two closest ranks. sorted must be in ascending order and non-empty.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 1 {
+		return sorted[0]
+	}
+	rank := p / 100 * float64(len(sorted)-1)
+	lower := int(rank)
+	if lower >= len(sorted)-1 {
+		return sorted[len(sorted)-1]
+	}
+	frac := rank - float64(lower)
+	return sorted[lower] + time.Duration(math.Round(frac*float64(sorted[lower+1]-sorted[lower])))
+}
+
+// getICMPResponseType returns expected response types based on the test.
+func getICMPResponseType(test Test) (ipv4.ICMPType, error) {
+	if test.Raw {
+		return 99, fmt.Errorf("reply type to raw ICMP type %d cannot be predicted", int(test.RequestType))
+	}
+	switch test.RequestType.String() {
 	case "echo":
 		return ipv4.ICMPTypeEchoReply, nil
 	case "timestamp":
@@ -383,7 +1558,7 @@ func parseICMPRequestType(reqType string) (ipv4.ICMPType, error) {
 	}
 }
 
-func buildFailedTestResult(testInput testInput, details string) TestResult {
+func buildFailedTestResult(testInput config.TestInput, details string) TestResult {
 	return TestResult{
 		Name:           testInput.Name,
 		Destination:    testInput.Destination,
@@ -397,378 +1572,1043 @@ func buildFailedTestResult(testInput testInput, details string) TestResult {
 	}
 }
 
-func loadConfig(path string) (*Config, error) {
-	data, err := os.ReadFile(path)
-	if err != nil {
-		return nil, fmt.Errorf("config file read error: %w", err)
+// buildSkippedTestResult returns a SKIPPED result for a test that was not run.
+func buildSkippedTestResult(testInput config.TestInput, details string) TestResult {
+	return TestResult{
+		Name:           testInput.Name,
+		Destination:    testInput.Destination,
+		RequestType:    testInput.RequestType,
+		ExpectedResult: testInput.ExpectedResult,
+		ActualResult:   "N/A",
+		Status:         "SKIPPED",
+		Details:        details,
+		Timestamp:      time.Now(),
 	}
+}
 
-	var input inputConfig
-	if err := yaml.Unmarshal(data, &input); err != nil {
-		return nil, fmt.Errorf("YAML unmarshal error: %w", err)
+// AggregateRuns combines the results of repeated suite runs into one result per test.
+// A test is PASSED if it passed every run, FAILED if it never passed and FLAKY otherwise;
+// a test skipped (or dry-run) in every run keeps that status. Packet counts and TotalTime
+// are summed, Duration is the mean over the runs that measured one, and min/avg/max RTT
+// cover all runs; per-run percentiles and jitter are not combined.
+func AggregateRuns(runs [][]TestResult) []TestResult {
+	if len(runs) == 0 {
+		return nil
 	}
+	aggregated := make([]TestResult, len(runs[0]))
+	for i := range aggregated {
+		agg := runs[len(runs)-1][i]
+		agg.Timestamp = runs[0][i].Timestamp
+		agg.Runs = len(runs)
+		agg.RunsPassed = 0
+		agg.PacketsSent, agg.PacketsReceived = 0, 0
+		agg.BytesSent, agg.BytesReceived = 0, 0
+		agg.MinRTT, agg.AvgRTT, agg.MaxRTT = 0, 0, 0
+		agg.Jitter, agg.P50RTT, agg.P95RTT, agg.P99RTT = 0, 0, 0, 0
+		agg.Duration, agg.TotalTime = 0, 0
+
+		var failed, other, timed int
+		var rttSum, durationSum time.Duration
+		for _, results := range runs {
+			res := results[i]
+			switch res.Status {
+			case "PASSED":
+				agg.RunsPassed++
+			case "FAILED":
+				failed++
+			default:
+				other++
+			}
+			if res.Duration > 0 {
+				durationSum += res.Duration
+				timed++
+			}
+			agg.TotalTime += res.TotalTime
+			agg.PacketsSent += res.PacketsSent
+			agg.PacketsReceived += res.PacketsReceived
+			agg.BytesSent += res.BytesSent
+			agg.BytesReceived += res.BytesReceived
+			if res.PacketsReceived > 0 {
+				min, avg, max := res.MinRTT, res.AvgRTT, res.MaxRTT
+				if max == 0 {
+					// Single-probe results have no RTT statistics, only their one RTT.
+					min, avg, max = res.FirstReplyRTT, res.FirstReplyRTT, res.FirstReplyRTT
+				}
+				if agg.MinRTT == 0 || min < agg.MinRTT {
+					agg.MinRTT = min
+				}
+				if max > agg.MaxRTT {
+					agg.MaxRTT = max
+				}
+				rttSum += avg * time.Duration(res.PacketsReceived)
+			}
+		}
+		if agg.PacketsReceived > 0 {
+			agg.AvgRTT = rttSum / time.Duration(agg.PacketsReceived)
+		}
+		if timed > 0 {
+			agg.Duration = durationSum / time.Duration(timed)
+		}
 
-	var cfg Config
+		switch {
+		case other == len(runs):
+			// Skipped or dry-run every time: keep the last run's status.
+		case failed == 0:
+			agg.Status = "PASSED"
+		case agg.RunsPassed == 0:
+			agg.Status = "FAILED"
+		default:
+			agg.Status = "FLAKY"
+		}
+		agg.Details = fmt.Sprintf("passed %d/%d runs; last: %s", agg.RunsPassed, agg.Runs, agg.Details)
+		aggregated[i] = agg
+	}
+	return aggregated
+}
 
-	if input.General.InterfaceName != nil {
-		iface, err := getIfaceFromInterfaceName(*input.General.InterfaceName)
-		if err != nil {
-			return nil, fmt.Errorf("failed to get interface %s: %v", *input.General.InterfaceName, err)
+// buildTest validates testInput and converts it to a Test using sequence number seq,
+// applying defaults for unset fields. Flood mode is rejected unless allowFlood is set.
+func buildTest(testInput config.TestInput, seq int, allowFlood bool) (Test, error) {
+	expected := strings.Split(testInput.ExpectedResult, "|")
+	for i, r := range expected {
+		if !slices.Contains(config.ExpectedResults, r) {
+			return Test{}, fmt.Errorf("invalid expected_result: %q", r)
 		}
-		input.General.Interface = *iface
+		if slices.Contains(expected[:i], r) {
+			return Test{}, fmt.Errorf("expected_result lists %q more than once", r)
+		}
+	}
+
+	// probe_timeout is the explicit name for the per-probe timeout; timeout is kept for
+	// existing configs and means the same thing.
+	var timeout string
+	switch {
+	case testInput.ProbeTimeout != nil && testInput.Timeout != nil:
+		return Test{}, fmt.Errorf("timeout and probe_timeout both set: they are the same per-probe setting, use probe_timeout")
+	case testInput.ProbeTimeout != nil:
+		timeout = *testInput.ProbeTimeout
+	case testInput.Timeout != nil:
+		timeout = *testInput.Timeout
+	default:
+		timeout = config.DefaultTimeout
+	}
+
+	// LoadConfig has checked the timeout against general.max_timeout.
+	duration, err := time.ParseDuration(timeout)
+	if err != nil {
+		return Test{}, fmt.Errorf("invalid timeout %q: %v", timeout, err)
+	}
+	if duration <= 0 {
+		return Test{}, fmt.Errorf("invalid timeout %q: must be positive", timeout)
+	}
+
+	var reqType ipv4.ICMPType
+	var rawCode int
+	var rawPayload []byte
+	raw := testInput.RequestType == "raw"
+	if raw {
+		reqType, rawCode, rawPayload, err = parseRawRequest(testInput)
 	} else {
-		ifaces, err := net.Interfaces()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get interfaces: %v", err)
+		if testInput.ICMPType != nil || testInput.ICMPCode != nil || testInput.Payload != nil || testInput.PayloadFile != nil {
+			return Test{}, fmt.Errorf("icmp_type, icmp_code, payload and payload_file require request_type \"raw\"")
 		}
-		if len(ifaces) == 0 {
-			return nil, fmt.Errorf("no network interfaces found")
+		reqType, err = parseICMPRequestType(testInput.RequestType)
+	}
+	if err != nil {
+		return Test{}, err
+	}
+
+	// Set payload size (default to 32 bytes if not specified)
+	payloadSize := config.DefaultPayloadSize
+	if raw {
+		if testInput.PayloadSize != nil {
+			return Test{}, fmt.Errorf("payload_size is not supported with request_type \"raw\"; use payload or payload_file")
 		}
-		input.General.Interface = ifaces[0]
+		payloadSize = len(rawPayload)
 	}
-	if input.General.SourceIPAddressString != nil {
-		sourceIP := net.ParseIP(*input.General.SourceIPAddressString)
-		if sourceIP == nil {
-			return nil, fmt.Errorf("invalid source IP address: %s", *input.General.SourceIPAddressString)
+	var payloadPreset string
+	if testInput.PayloadPreset != nil {
+		payloadPreset = *testInput.PayloadPreset
+		p, ok := payloadPresets[payloadPreset]
+		if !ok {
+			return Test{}, fmt.Errorf("invalid payload_preset %q: must be one of %s", payloadPreset, strings.Join(config.PayloadPresets, ", "))
 		}
-		input.General.SourceIPAddress = sourceIP
-	} else {
-		addrs, err := input.General.Interface.Addrs()
-		if err != nil {
-			return nil, fmt.Errorf("failed to get addresses for interface %s: %v", input.General.Interface.Name, err)
+		if reqType != ipv4.ICMPTypeEcho || raw {
+			return Test{}, fmt.Errorf("payload_preset requires request_type \"echo\"")
 		}
-		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-			if ip == nil || ip.To4() == nil {
-				continue
-			}
-			input.General.SourceIPAddress = ip
-			break
+		payloadSize = p.defaultSize
+	}
+	if !raw && testInput.PayloadSize != nil {
+		payloadSize = *testInput.PayloadSize
+		// Validate payload size (must be positive and reasonable)
+		if payloadSize < 0 || payloadSize > 65507 { // 65507 = 65535 - 20 (IP header) - 8 (ICMP header)
+			return Test{}, fmt.Errorf("invalid payload_size %d: must be between 0 and 65507", payloadSize)
 		}
 	}
 
-	if input.General.Output == nil {
-		// Allocate memory for the pointer and set the default value.
-		cfg.General.Output = defaultOutput
-	} else {
-		// Check for valid values.
-		if *input.General.Output != "text" && *input.General.Output != "json" {
-			return nil, fmt.Errorf("invalid output value: %s. It must be 'text' or 'json'", *input.General.Output)
+	count := config.DefaultCount
+	if testInput.Count != nil {
+		count = *testInput.Count
+		if count < 1 {
+			return Test{}, fmt.Errorf("invalid count %d: must be at least 1", count)
 		}
-		// Use the provided output.
-		cfg.General.Output = *input.General.Output
 	}
 
-	if input.General.Parallelism == nil || *input.General.Parallelism <= 0 {
-		cfg.General.Parallelism = defaultParallelism
-	} else {
-		cfg.General.Parallelism = *input.General.Parallelism
+	interval := config.DefaultInterval
+	if testInput.Interval != nil {
+		interval = *testInput.Interval
+	}
+	intervalDuration, err := time.ParseDuration(interval)
+	if err != nil || intervalDuration < 0 {
+		return Test{}, fmt.Errorf("invalid interval %q: must be a non-negative duration", interval)
 	}
 
-	if input.General.TOS == nil {
-		cfg.General.TOS = defaultTOS
-	} else {
-		tosValue, err := strconv.ParseInt(*input.General.TOS, 0, 8)
-		if err != nil || tosValue < 0 || tosValue > 255 {
-			return nil, fmt.Errorf("invalid TOS value in general configuration: %s. It must be a number or hex string (like '0x00') between 0 and 255", *input.General.TOS)
+	// With a deadline, probes stop at count or deadline, whichever comes first. Without
+	// an explicit count, count is the number of probes that fit in the deadline.
+	var deadline time.Duration
+	if testInput.Deadline != nil {
+		deadline, err = time.ParseDuration(*testInput.Deadline)
+		if err != nil || deadline <= 0 {
+			return Test{}, fmt.Errorf("invalid deadline %q: must be a positive duration", *testInput.Deadline)
+		}
+		if testInput.Count == nil {
+			if intervalDuration <= 0 {
+				return Test{}, fmt.Errorf("deadline without count requires a positive interval")
+			}
+			count = config.DeadlineCount(deadline, intervalDuration)
 		}
-		cfg.General.TOS = int(tosValue)
 	}
 
-	cfg.General.Interface, cfg.General.SourceIPAddress = determineNetworkInterfaceAndIPAddress(input)
-
-	if input.General.ResultFilter != nil {
-		cfg.General.ResultFilter = *input.General.ResultFilter
+	var warmup int
+	if testInput.Warmup != nil {
+		warmup = *testInput.Warmup
+		if warmup < 0 {
+			return Test{}, fmt.Errorf("invalid warmup %d: must be non-negative", warmup)
+		}
+		if warmup > 0 && count < 2 {
+			return Test{}, fmt.Errorf("warmup requires count > 1")
+		}
 	}
 
-	if input.General.SetDFBit == nil {
-		cfg.General.SetDFBit = defaultSetDFBit
-	} else {
-		cfg.General.SetDFBit = *input.General.SetDFBit
+	var maxJitter time.Duration
+	if testInput.MaxJitter != nil {
+		maxJitter, err = time.ParseDuration(*testInput.MaxJitter)
+		if err != nil || maxJitter <= 0 {
+			return Test{}, fmt.Errorf("invalid max_jitter %q: must be a positive duration", *testInput.MaxJitter)
+		}
 	}
 
-	if len(input.Tests) == 0 {
-		return nil, fmt.Errorf("no test scenarios found")
+	var maxP99 time.Duration
+	if testInput.MaxP99 != nil {
+		maxP99, err = time.ParseDuration(*testInput.MaxP99)
+		if err != nil || maxP99 <= 0 {
+			return Test{}, fmt.Errorf("invalid max_p99 %q: must be a positive duration", *testInput.MaxP99)
+		}
 	}
-	cfg.Tests = input.Tests
-	return &cfg, nil
-}
 
-func getIfaceFromInterfaceName(interfaceName string) (*net.Interface, error) {
-	if interfaceName == "" {
-		return nil, fmt.Errorf("interface name is empty")
+	var assertRTTBelow time.Duration
+	assertRTTStat := "avg"
+	if testInput.AssertRTTBelow != nil {
+		assertRTTBelow, err = time.ParseDuration(*testInput.AssertRTTBelow)
+		if err != nil || assertRTTBelow <= 0 {
+			return Test{}, fmt.Errorf("invalid assert_rtt_below %q: must be a positive duration", *testInput.AssertRTTBelow)
+		}
+		if testInput.ExpectedResult != "response" {
+			return Test{}, fmt.Errorf("assert_rtt_below requires expected_result \"response\"")
+		}
 	}
-	iface, err := net.InterfaceByName(interfaceName)
-	if err != nil {
-		return nil, fmt.Errorf("InterfaceByName(%s) failed: %v", interfaceName, err)
+	if testInput.AssertRTTStat != nil {
+		if testInput.AssertRTTBelow == nil {
+			return Test{}, fmt.Errorf("assert_rtt_stat requires assert_rtt_below")
+		}
+		assertRTTStat = *testInput.AssertRTTStat
+		if !slices.Contains(config.RTTStatistics, assertRTTStat) {
+			return Test{}, fmt.Errorf("invalid assert_rtt_stat %q: must be one of %s", assertRTTStat, strings.Join(config.RTTStatistics, ", "))
+		}
 	}
-	return iface, nil
-}
 
-func determineNetworkInterfaceAndIPAddress(input inputConfig) (net.Interface, net.IP) {
-	// interface name and source IP address not specified
-	if input.General.InterfaceName == nil && input.General.SourceIPAddressString == nil {
-		// lookup default interface
-		ifaces, err := net.Interfaces()
-		if err != nil {
-			log.Fatalf("Interfaces() failed: %v\n", err)
+	id := pid
+	if testInput.ID != nil {
+		if *testInput.ID < 0 || *testInput.ID > 0xffff {
+			return Test{}, fmt.Errorf("invalid id %d: must be between 0 and 65535", *testInput.ID)
 		}
-		if len(ifaces) == 0 {
-			log.Fatalf("No network interfaces found\n")
-		}
-		iface := ifaces[0]
-		addrs, err := iface.Addrs()
+		id = *testInput.ID
+	}
+
+	var assertLoss bool
+	var maxLoss float64
+	if testInput.AssertLossBelow != nil {
+		maxLoss, err = parsePercent(*testInput.AssertLossBelow)
 		if err != nil {
-			log.Fatalf("Failed to get addresses for interface %s: %v\n", iface.Name, err)
+			return Test{}, fmt.Errorf("invalid assert_loss_below %q: %v", *testInput.AssertLossBelow, err)
 		}
-		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-			if ip == nil || ip.To4() == nil {
-				continue
-			}
-			return iface, ip
+		if testInput.ExpectedResult != "response" {
+			return Test{}, fmt.Errorf("assert_loss_below requires expected_result \"response\"")
+		}
+		if count < 2 && (testInput.Mode == nil || *testInput.Mode != "flood") {
+			return Test{}, fmt.Errorf("assert_loss_below requires count > 1 or flood mode")
 		}
+		assertLoss = true
 	}
 
-	// interface name specified, but source IP address not specified
-	if input.General.InterfaceName != nil && input.General.SourceIPAddressString == nil {
-		iface, err := getIfaceFromInterfaceName(*input.General.InterfaceName)
-		if err != nil {
-			log.Fatalf("%s", err)
+	var mode string
+	var floodRate int
+	var floodDuration time.Duration
+	minResponders := 1
+	if testInput.Mode != nil {
+		mode = *testInput.Mode
+	}
+	switch mode {
+	case "":
+	case "flood":
+		if warmup > 0 {
+			return Test{}, fmt.Errorf("warmup is not supported in flood mode")
 		}
-		addrs, err := iface.Addrs()
-		if err != nil {
-			log.Fatalf("Failed to get addresses for interface %s: %v\n", *input.General.InterfaceName, err)
+		if !allowFlood {
+			return Test{}, fmt.Errorf("flood mode requires the -allow-flood flag")
 		}
-		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-			if ip == nil || ip.To4() == nil {
-				continue
+		if raw || reqType != ipv4.ICMPTypeEcho {
+			return Test{}, fmt.Errorf("flood mode only supports request_type \"echo\"")
+		}
+		if testInput.Duration == nil {
+			return Test{}, fmt.Errorf("flood mode requires an explicit duration (at most %v)", maxFloodDuration)
+		}
+		floodDuration, err = time.ParseDuration(*testInput.Duration)
+		if err != nil || floodDuration <= 0 || floodDuration > maxFloodDuration {
+			return Test{}, fmt.Errorf("invalid duration %q: must be between 1ms and %v", *testInput.Duration, maxFloodDuration)
+		}
+		if testInput.Rate != nil {
+			floodRate = *testInput.Rate
+			if floodRate < 0 {
+				return Test{}, fmt.Errorf("invalid rate %d: must be non-negative", floodRate)
 			}
-			return *iface, ip
+		}
+	case "broadcast":
+		if raw || reqType != ipv4.ICMPTypeEcho {
+			return Test{}, fmt.Errorf("broadcast mode only supports request_type \"echo\"")
+		}
+		if count > 1 {
+			return Test{}, fmt.Errorf("broadcast mode sends a single probe; count must be 1")
+		}
+		// Each responder answers once, so consecutive RTTs come from different hosts.
+		if maxJitter > 0 {
+			return Test{}, fmt.Errorf("max_jitter is not supported in broadcast mode")
+		}
+	default:
+		return Test{}, fmt.Errorf("invalid mode: %q", mode)
+	}
+	if testInput.MinResponders != nil {
+		if mode != "broadcast" && mode != "" {
+			return Test{}, fmt.Errorf("min_responders is not supported in %s mode", mode)
+		}
+		if testInput.ExpectedResult != "response" {
+			return Test{}, fmt.Errorf("min_responders requires expected_result \"response\"")
+		}
+		minResponders = *testInput.MinResponders
+		if minResponders < 1 {
+			return Test{}, fmt.Errorf("invalid min_responders %d: must be at least 1", minResponders)
 		}
 	}
 
-	// source IP address specified, but interface name not specified
-	if input.General.InterfaceName == nil && input.General.SourceIPAddressString != nil {
-		sourceIP := net.ParseIP(*input.General.SourceIPAddressString)
-		if sourceIP == nil {
-			log.Fatalf("Invalid source IP address: %s\n", *input.General.SourceIPAddressString)
+	test := Test{
+		Name:           testInput.Name,
+		Destination:    testInput.Destination,
+		ID:             id,
+		Seq:            seq,
+		RequestType:    reqType,
+		Timeout:        duration,
+		ExpectedResult: testInput.ExpectedResult,
+		PayloadSize:    payloadSize,
+		PayloadPreset:  payloadPreset,
+		Raw:            raw,
+		ICMPCode:       rawCode,
+		Payload:        rawPayload,
+		Count:          count,
+		Warmup:         warmup,
+		Deadline:       deadline,
+		Interval:       intervalDuration,
+		MaxJitter:      maxJitter,
+		MaxP99:         maxP99,
+		AssertRTTBelow: assertRTTBelow,
+		AssertRTTStat:  assertRTTStat,
+		AssertLoss:     assertLoss,
+		MaxLoss:        maxLoss,
+		Mode:           mode,
+		Rate:           floodRate,
+		FloodDuration:  floodDuration,
+		MinResponders:  minResponders,
+	}
+	if testInput.FailOnFragmentation != nil {
+		test.FailOnFragmentation = *testInput.FailOnFragmentation
+	}
+	if testInput.VerifySource != nil {
+		test.VerifySource = *testInput.VerifySource
+	}
+	if testInput.VerifyChecksum != nil && *testInput.VerifyChecksum {
+		if reqType != ipv4.ICMPTypeEcho || raw {
+			return Test{}, fmt.Errorf("verify_checksum requires request_type \"echo\"")
 		}
-		ifaces, err := net.Interfaces()
-		if err != nil {
-			log.Fatalf("Interfaces() failed: %v\n", err)
+		if mode != "" {
+			return Test{}, fmt.Errorf("verify_checksum is not supported in %s mode", mode)
+		}
+		if payloadSize < minChecksumPayload {
+			return Test{}, fmt.Errorf("verify_checksum requires payload_size of at least %d bytes, got %d", minChecksumPayload, payloadSize)
+		}
+		test.VerifyChecksum = true
+	}
+	if testInput.MaxUnexpectedReplies != nil {
+		if testInput.ExpectedResult != "timeout" {
+			return Test{}, fmt.Errorf("max_unexpected_replies requires expected_result \"timeout\"")
+		}
+		if count < 2 && mode != "flood" {
+			return Test{}, fmt.Errorf("max_unexpected_replies requires count > 1 or flood mode")
+		}
+		if *testInput.MaxUnexpectedReplies < 0 {
+			return Test{}, fmt.Errorf("invalid max_unexpected_replies %d: must be non-negative", *testInput.MaxUnexpectedReplies)
+		}
+		test.MaxUnexpected = *testInput.MaxUnexpectedReplies
+	}
+	for _, bound := range []struct {
+		name  string
+		value *int
+		field *int
+	}{
+		{"assert_ttl_min", testInput.AssertTTLMin, &test.AssertTTLMin},
+		{"assert_ttl_max", testInput.AssertTTLMax, &test.AssertTTLMax},
+	} {
+		if bound.value == nil {
+			continue
+		}
+		if *bound.value < 1 || *bound.value > 255 {
+			return Test{}, fmt.Errorf("invalid %s %d: must be between 1 and 255", bound.name, *bound.value)
+		}
+		if testInput.ExpectedResult != "response" {
+			return Test{}, fmt.Errorf("%s requires expected_result \"response\"", bound.name)
+		}
+		if mode != "" {
+			return Test{}, fmt.Errorf("%s is not supported in %s mode", bound.name, mode)
+		}
+		*bound.field = *bound.value
+	}
+	if test.AssertTTLMax > 0 && test.AssertTTLMin > test.AssertTTLMax {
+		return Test{}, fmt.Errorf("assert_ttl_min %d is above assert_ttl_max %d", test.AssertTTLMin, test.AssertTTLMax)
+	}
+	// Several probes, floods and broadcasts have outcomes of their own, such as loss or
+	// responder counts; only a single probe ends in exactly one of the expected results.
+	if len(expected) > 1 || expected[0] == "unreachable" {
+		what := fmt.Sprintf("expected_result %q", testInput.ExpectedResult)
+		if len(expected) > 1 {
+			what = "a list of expected results"
+		}
+		if mode != "" {
+			return Test{}, fmt.Errorf("%s is not supported in %s mode", what, mode)
 		}
-		for _, iface := range ifaces {
-			addrs, err := iface.Addrs()
+		if count > 1 {
+			return Test{}, fmt.Errorf("%s requires a single probe; count must be 1", what)
+		}
+	}
+	test.ExpectedCode = -1
+	if slices.Contains(expected, "unreachable") {
+		if testInput.ExpectedCode != nil {
+			test.ExpectedCode, err = parseUnreachableCode(*testInput.ExpectedCode)
 			if err != nil {
-				log.Fatalf("Failed to get addresses for interface %s: %v\n", iface.Name, err)
-			}
-			for _, addr := range addrs {
-				var ip net.IP
-				switch v := addr.(type) {
-				case *net.IPNet:
-					ip = v.IP
-				case *net.IPAddr:
-					ip = v.IP
-				}
-				if ip == nil || ip.To4() == nil {
-					continue
-				}
-				if ip.Equal(sourceIP) {
-					return iface, sourceIP
-				}
+				return Test{}, fmt.Errorf("invalid expected_code %q: %v", *testInput.ExpectedCode, err)
 			}
-			log.Fatalf("No network interface found with IP address %s\n", sourceIP)
+		}
+	} else if testInput.ExpectedCode != nil {
+		return Test{}, fmt.Errorf("expected_code requires expected_result \"unreachable\"")
+	}
+	if testInput.StopOnLoss != nil && *testInput.StopOnLoss {
+		if mode != "" {
+			return Test{}, fmt.Errorf("stop_on_loss is not supported in %s mode", mode)
+		}
+		if count < 2 {
+			return Test{}, fmt.Errorf("stop_on_loss requires count > 1")
+		}
+		if testInput.ExpectedResult != "response" || assertLoss {
+			return Test{}, fmt.Errorf("stop_on_loss requires expected_result \"response\" without assert_loss_below")
+		}
+		test.StopOnLoss = true
+	}
+	if testInput.TTL != nil {
+		if *testInput.TTL < 1 || *testInput.TTL > 255 {
+			return Test{}, fmt.Errorf("invalid ttl %d: must be between 1 and 255", *testInput.TTL)
+		}
+		test.TTL = *testInput.TTL
+	}
+	if testInput.Linger != nil {
+		test.Linger, err = time.ParseDuration(*testInput.Linger)
+		if err != nil || test.Linger <= 0 || test.Linger > 10*time.Second {
+			return Test{}, fmt.Errorf("invalid linger %q: must be between 1ms and 10s", *testInput.Linger)
+		}
+		if count > 1 || mode == "flood" {
+			return Test{}, fmt.Errorf("linger is only supported for single-probe tests")
+		}
+	}
+	if testInput.Retries != nil {
+		if *testInput.Retries < 0 || *testInput.Retries > config.MaxRetries {
+			return Test{}, fmt.Errorf("invalid retries %d: must be between 0 and %d", *testInput.Retries, config.MaxRetries)
+		}
+		if testInput.ExpectedResult != "response" {
+			return Test{}, fmt.Errorf("retries requires expected_result \"response\"")
+		}
+		if count > 1 || mode != "" {
+			return Test{}, fmt.Errorf("retries is only supported for single-probe tests")
+		}
+		test.Retries = *testInput.Retries
+	}
+	if testInput.CollectGrace != nil {
+		test.CollectGrace, err = time.ParseDuration(*testInput.CollectGrace)
+		if err != nil || test.CollectGrace < 0 || test.CollectGrace > 10*time.Second {
+			return Test{}, fmt.Errorf("invalid collect_grace %q: must be between 0 (off) and 10s", *testInput.CollectGrace)
+		}
+		if count < 2 || mode != "" {
+			return Test{}, fmt.Errorf("collect_grace is only supported for multi-probe tests")
+		}
+	} else if count > 1 && mode == "" {
+		// A reply slower than its probe's timeout is still a reply: wait as long again for it.
+		test.CollectGrace = test.Timeout
+	}
+	return test, nil
+}
+
+// parseRawRequest validates the raw request fields of testInput and returns the ICMP
+// type, code and body payload to send.
+func parseRawRequest(testInput config.TestInput) (ipv4.ICMPType, int, []byte, error) {
+	if testInput.ICMPType == nil {
+		return 0, 0, nil, fmt.Errorf("request_type \"raw\" requires icmp_type")
+	}
+	if *testInput.ICMPType < 0 || *testInput.ICMPType > 255 {
+		return 0, 0, nil, fmt.Errorf("invalid icmp_type %d: must be between 0 and 255", *testInput.ICMPType)
+	}
+	var code int
+	if testInput.ICMPCode != nil {
+		code = *testInput.ICMPCode
+		if code < 0 || code > 255 {
+			return 0, 0, nil, fmt.Errorf("invalid icmp_code %d: must be between 0 and 255", code)
 		}
 	}
 
-	// both interface name and source IP address specified
-	if input.General.InterfaceName != nil && input.General.SourceIPAddressString != nil {
-		iface, err := getIfaceFromInterfaceName(*input.General.InterfaceName)
+	var payload []byte
+	switch {
+	case testInput.Payload != nil && testInput.PayloadFile != nil:
+		return 0, 0, nil, fmt.Errorf("payload and payload_file are mutually exclusive")
+	case testInput.Payload != nil:
+		b, err := hex.DecodeString(*testInput.Payload)
 		if err != nil {
-			log.Fatalf("%s", err)
+			return 0, 0, nil, fmt.Errorf("invalid payload %q: must be hex-encoded: %v", *testInput.Payload, err)
 		}
-		sourceIP := net.ParseIP(*input.General.SourceIPAddressString)
-		if sourceIP == nil {
-			log.Fatalf("Invalid source IP address: %s\n", *input.General.SourceIPAddressString)
+		payload = b
+	case testInput.PayloadFile != nil:
+		b, err := os.ReadFile(*testInput.PayloadFile)
+		if err != nil {
+			return 0, 0, nil, fmt.Errorf("payload_file: %w", err)
 		}
-		addrs, err := iface.Addrs()
+		payload = b
+	}
+	if len(payload) > 65507-4 {
+		return 0, 0, nil, fmt.Errorf("raw payload of %d bytes is too large: at most %d", len(payload), 65507-4)
+	}
+	return ipv4.ICMPType(*testInput.ICMPType), code, payload, nil
+}
+
+// withTestOverrides returns cfg with the general settings a test may override replaced by
+// testInput's own: the source address of tests expanded by source "all" and a per-test
+// set_df_bit, so that sockets, control messages and results use them.
+func withTestOverrides(cfg *config.Config, testInput config.TestInput) *config.Config {
+	if testInput.SourceIP == nil && testInput.SetDFBit == nil {
+		return cfg
+	}
+	c := *cfg
+	if testInput.SourceIP != nil {
+		c.General.SourceIPAddress = testInput.SourceIP
+	}
+	if testInput.SetDFBit != nil {
+		c.General.SetDFBit = *testInput.SetDFBit
+	}
+	return &c
+}
+
+// ListTests writes one line per test as it would run after destination expansion and
+// defaults are applied, including the resolved destination address, without sending anything.
+// Names are resolved as in a run, within general.resolve_timeout and through a DNS cache
+// honoring general.dns_cache_ttl, so that a dead name cannot hang the listing.
+func ListTests(w io.Writer, cfg *config.Config, allowFlood bool) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "NAME\tDEST\tRESOLVED\tSOURCE\tTYPE\tEXPECTED\tPAYLOAD\tTIMEOUT\tCOUNT\tNOTE")
+	dns := NewDNSCache(cfg.General.DNSCacheTTL)
+	for _, testInput := range cfg.Tests {
+		resolved := "unresolved"
+		if addr, _, err := dns.resolve(context.Background(), testInput.Destination, cfg.General.ResolveTimeout); err == nil {
+			resolved = addr.IP.String()
+		}
+		testCfg := withTestOverrides(cfg, testInput)
+		source := fmt.Sprintf("%s (%s)", testCfg.General.SourceIPAddress, testCfg.General.Interface.Name)
+
+		test, err := buildTest(testInput, 0, allowFlood)
 		if err != nil {
-			log.Fatalf("Failed to get addresses for interface %s: %v\n", *input.General.InterfaceName, err)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t-\t-\t-\tinvalid: %v\n", testInput.Name, testInput.Destination,
+				resolved, source, testInput.RequestType, testInput.ExpectedResult, err)
+			continue
 		}
-		for _, addr := range addrs {
-			var ip net.IP
-			switch v := addr.(type) {
-			case *net.IPNet:
-				ip = v.IP
-			case *net.IPAddr:
-				ip = v.IP
-			}
-			if ip == nil || ip.To4() == nil {
-				continue
-			}
-			if ip.Equal(sourceIP) {
-				return *iface, sourceIP
+		var note string
+		switch {
+		case testInput.Skip:
+			note = "skip"
+		case len(testInput.SkipIf) > 0:
+			note = "skip_if " + strings.Join(testInput.SkipIf, ",")
+		case test.Mode != "":
+			note = "mode " + test.Mode
+		}
+		if len(testInput.DependsOn) > 0 {
+			if note != "" {
+				note += "; "
 			}
+			note += "depends_on " + strings.Join(testInput.DependsOn, ",")
 		}
-		log.Fatalf("No network interface found with IP address %s\n", sourceIP)
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\t%s\t%d\t%v\t%d\t%s\n", test.Name, test.Destination, resolved, source,
+			test.requestTypeName(), test.ExpectedResult, test.PayloadSize, test.Timeout, test.Count, note)
 	}
-	log.Fatalf("Failed to determine network interface and source IP address\n")
-	return net.Interface{}, nil // unreachable
+	return tw.Flush()
 }
 
-func main() {
-	configFilePath := flag.String("config", "config.yaml", "Path to YAML test configuration file")
-	flag.Parse()
-	config, err := loadConfig(*configFilePath)
-	if err != nil {
-		log.Fatalf("config load error: %v", err)
+// destLimiter bounds the number of tests running concurrently against the same
+// destination, keyed by resolved IP address so that names and addresses of one host
+// share a limit.
+type destLimiter struct {
+	max  int
+	mu   sync.Mutex
+	sems map[string]chan struct{}
+}
+
+// newDestLimiter returns a limiter allowing max concurrent tests per destination;
+// max <= 0 means unlimited.
+func newDestLimiter(max int) *destLimiter {
+	return &destLimiter{max: max, sems: make(map[string]chan struct{})}
+}
+
+// acquire waits for a slot for key, the destination address, and returns a function
+// releasing it. It fails only if ctx is done first.
+func (l *destLimiter) acquire(ctx context.Context, key string) (func(), error) {
+	if l.max <= 0 {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return func() {}, nil
+	}
+
+	l.mu.Lock()
+	sem, ok := l.sems[key]
+	if !ok {
+		sem = make(chan struct{}, l.max)
+		l.sems[key] = sem
+	}
+	l.mu.Unlock()
+
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// drawStartDelays returns n random delays in [0, max], one per test in config order,
+// so that a given seed always yields the same delays. All are zero if max is zero.
+func drawStartDelays(n int, max time.Duration, rng *rand.Rand) []time.Duration {
+	delays := make([]time.Duration, n)
+	if max <= 0 || rng == nil {
+		return delays
+	}
+	for i := range delays {
+		delays[i] = time.Duration(rng.Int63n(int64(max) + 1))
+	}
+	return delays
+}
+
+// shuffleOrder randomly permutes the launch order in place, so that a given seed always
+// yields the same order. Tests still wait for their dependencies, so any order is safe.
+func shuffleOrder(order []int, rng *rand.Rand) {
+	if rng == nil {
+		rng = rand.New(rand.NewSource(time.Now().UnixNano()))
+	}
+	rng.Shuffle(len(order), func(a, b int) { order[a], order[b] = order[b], order[a] })
+}
+
+// RunOptions carries settings that affect how tests are run; the zero value runs every
+// test for real.
+type RunOptions struct {
+	DryRun     bool
+	AllowFlood bool
+	AllowSpoof bool          // Allow general.spoof_source; without it a config that sets it cannot run
+	Rand       *rand.Rand    // Source of start_jitter delays; seeded by -seed for reproducible runs
+	FailFast   bool          // Stop the suite at the first FAILED result; tests not yet finished are SKIPPED
+	Shuffle    bool          // Start tests in an order drawn from Rand instead of config order; results stay in config order
+	Seqs       *SeqAllocator // Sequence numbers; share one across repeated runs (default: a new random start)
+	DNS        *DNSCache     // Resolved destination names; share one across repeated runs (default: a new cache with general.dns_cache_ttl)
+	// MaxRTTSamples caps the RTT samples each test keeps for percentiles (0 = keep all).
+	// Beyond the cap, percentiles are estimated from a uniform random sample.
+	MaxRTTSamples int
+	// Progress, if set, is called each time a test finishes (including skipped ones) with
+	// the number of finished tests, the total and how many of them FAILED. Calls are
+	// serialized but come from the tests' goroutines, so it must return quickly.
+	Progress func(completed, total, failed int)
+}
+
+// Run runs every test in cfg once and returns the results in config order. cfg is
+// normally obtained from LoadConfig. Failed tests are reported in the results; an error
+// means the suite could not start, e.g. because of a circular depends_on.
+func Run(ctx context.Context, cfg config.Config) ([]TestResult, error) {
+	return RunWithOptions(ctx, cfg, RunOptions{})
+}
+
+// RunWithOptions is Run with dry-run, flood, fail-fast, sequence number and DNS cache settings.
+func RunWithOptions(ctx context.Context, cfg config.Config, opts RunOptions) ([]TestResult, error) {
+	return runSuite(ctx, &cfg, opts)
+}
+
+// applyMinResponders evaluates min_responders for tests expanded from one config entry
+// with several destinations: once all of them have finished, the failures of the others
+// are tolerated if at least min_responders of them passed. Every result of such a group
+// gets a note listing which destinations passed and which did not. Skipped results
+// count as neither.
+func applyMinResponders(cfg *config.Config, results []TestResult) {
+	for _, members := range minResponderGroups(cfg) {
+		applyGroupVerdict(cfg, results, members)
+	}
+}
+
+// applyGroupVerdict applies min_responders to the finished results of one group from
+// minResponderGroups, as described for applyMinResponders.
+func applyGroupVerdict(cfg *config.Config, results []TestResult, members []int) {
+	min := *cfg.Tests[members[0]].MinResponders
+	var passed, failed []string
+	for _, i := range members {
+		switch results[i].Status {
+		case "PASSED":
+			passed = append(passed, results[i].Destination)
+		case "FAILED":
+			failed = append(failed, results[i].Destination)
+		}
+	}
+	note := fmt.Sprintf("min_responders %d: %d of %d destinations passed", min, len(passed), len(members))
+	if len(passed) > 0 {
+		note += "; passed: " + strings.Join(passed, ", ")
+	}
+	if len(failed) > 0 {
+		note += "; failed: " + strings.Join(failed, ", ")
+	}
+	for _, i := range members {
+		results[i].Notes = append(results[i].Notes, note)
+		if len(passed) >= min && results[i].Status == "FAILED" {
+			results[i].Status = "PASSED"
+			results[i].Details = "tolerated by min_responders: " + results[i].Details
+		}
+	}
+}
+
+// minResponderGroups returns the indexes of the tests in cfg that applyMinResponders
+// evaluates together, one group per config entry, in config order.
+func minResponderGroups(cfg *config.Config) [][]int {
+	byName := make(map[string]int)
+	var groups [][]int
+	for i, t := range cfg.Tests {
+		if t.MinResponders == nil || (t.Mode != nil && *t.Mode == "broadcast") {
+			continue
+		}
+		g, ok := byName[t.BaseName()]
+		if !ok {
+			g = len(groups)
+			byName[t.BaseName()] = g
+			groups = append(groups, nil)
+		}
+		groups[g] = append(groups[g], i)
+	}
+	return groups
+}
+
+// mergeAnnotations returns general.labels overlaid with a test's annotations, which win
+// on conflicting keys, or nil if both are empty.
+func mergeAnnotations(labels, annotations map[string]string) map[string]string {
+	if len(labels) == 0 {
+		return annotations
+	}
+	if len(annotations) == 0 {
+		return labels
+	}
+	merged := make(map[string]string, len(labels)+len(annotations))
+	for k, v := range labels {
+		merged[k] = v
 	}
+	for k, v := range annotations {
+		merged[k] = v
+	}
+	return merged
+}
+
+// runSuite runs every test in cfg once and returns the results in config order.
+// Each test gets a block of sequence numbers from opts.Seqs, in config order. An error
+// means the suite could not start; failed tests are reported in the results.
+func runSuite(ctx context.Context, cfg *config.Config, opts RunOptions) ([]TestResult, error) {
+	if cfg.General.SpoofSource != nil && !opts.AllowSpoof {
+		return nil, fmt.Errorf("general.spoof_source requires the -allow-spoof flag")
+	}
+	if cfg.General.SuiteTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, cfg.General.SuiteTimeout)
+		defer cancel()
+	}
+
+	// With -fail-fast, the first FAILED result cancels ctx; suiteCtx stays live so that
+	// interruptions caused by the stop can be told apart from a suite timeout.
+	suiteCtx := ctx
+	ctx, stopSuite := context.WithCancel(ctx)
+	defer stopSuite()
 
 	var (
-		results   = make([]TestResult, len(config.Tests))
-		allPassed = true
-		wg        sync.WaitGroup
-		sem       = make(chan struct{}, config.General.Parallelism) // semaphore to limit concurrency
+		results = make([]TestResult, len(cfg.Tests))
+		wg      sync.WaitGroup
+		sem     = make(chan struct{}, cfg.General.Parallelism) // semaphore to limit concurrency
+		perDest = newDestLimiter(cfg.General.MaxPerDest)
+		stopped atomic.Bool
+
+		progressMu        sync.Mutex
+		completed, failed int
 	)
 
-	// Launch tests concurrently.
-	for i, test := range config.Tests {
-		wg.Add(1)
-		sem <- struct{}{}
-		go func(i int, testInput testInput) {
-			defer func() {
-				wg.Done()
-				<-sem
-			}()
+	// The members of a min_responders group are judged together once the last of them
+	// has finished; only then are their dependents released and -fail-fast consulted.
+	groups := minResponderGroups(cfg)
+	groupOf := make(map[int]int)
+	groupLeft := make([]int, len(groups))
+	for g, members := range groups {
+		for _, i := range members {
+			groupOf[i] = g
+		}
+		groupLeft[g] = len(members)
+	}
+	var groupMu sync.Mutex
+	checkFailFast := func(i int) {
+		if opts.FailFast && results[i].Status == "FAILED" && stopped.CompareAndSwap(false, true) {
+			logger.Info("stopping suite after first failure", "test", results[i].Name)
+			stopSuite()
+		}
+	}
 
-			if testInput.ExpectedResult != "response" && testInput.ExpectedResult != "timeout" {
-				results[i] = buildFailedTestResult(testInput,
-					fmt.Sprintf("invalid expected_result: %q", testInput.ExpectedResult))
-				return
-			}
+	startDelays := drawStartDelays(len(cfg.Tests), cfg.General.StartJitter, opts.Rand)
 
-			var timeout string
-			if testInput.Timeout == nil {
-				timeout = defaultTimeout
-			} else {
-				timeout = *testInput.Timeout
+	// Allocate a contiguous block of sequence numbers to each test, one per probe.
+	seqs := opts.Seqs
+	if seqs == nil {
+		seqs = NewSeqAllocator(-1)
+	}
+	dns := opts.DNS
+	if dns == nil {
+		dns = NewDNSCache(cfg.General.DNSCacheTTL)
+	}
+	seqStarts := make([]int, len(cfg.Tests))
+	for i, test := range cfg.Tests {
+		seqStarts[i] = seqs.alloc(test.ProbeCount())
+	}
+	floodIDs, err := assignFloodIDs(cfg.Tests)
+	if err != nil {
+		return nil, err
+	}
+
+	deps, err := config.ResolveDependencies(cfg.Tests)
+	if err != nil {
+		return nil, err
+	}
+	order, err := config.OrderTests(cfg.Tests, deps)
+	if err != nil {
+		return nil, err
+	}
+	if opts.Shuffle {
+		shuffleOrder(order, opts.Rand)
+	}
+	done := make([]chan struct{}, len(cfg.Tests))
+	for i := range done {
+		done[i] = make(chan struct{})
+	}
+
+	// finish marks test i as done, releasing its dependents, applies -fail-fast and
+	// reports progress. A group member waits for the rest of its group.
+	finish := func(i int) {
+		// Read before the group verdict, which another member's finish may apply.
+		testFailed := results[i].Status == "FAILED"
+		judged := []int{i}
+		if g, ok := groupOf[i]; ok {
+			groupMu.Lock()
+			groupLeft[g]--
+			left := groupLeft[g]
+			groupMu.Unlock()
+			judged = nil
+			if left == 0 {
+				judged = groups[g]
+				applyGroupVerdict(cfg, results, judged)
 			}
+		}
+		for _, j := range judged {
+			checkFailFast(j)
+			close(done[j])
+		}
+		if opts.Progress == nil {
+			return
+		}
+		progressMu.Lock()
+		defer progressMu.Unlock()
+		completed++
+		if testFailed {
+			failed++
+		}
+		opts.Progress(completed, len(results), failed)
+	}
 
-			duration, err := time.ParseDuration(timeout)
-			// Check if the timeout is valid.
-			if err != nil {
-				results[i] = buildFailedTestResult(testInput,
-					fmt.Sprintf("invalid timeout %q: %v", timeout, err))
-				return
+	// runTest validates testInput, builds the Test and runs it, storing the result in results[i].
+	runTest := func(i int, testInput config.TestInput) {
+		if testInput.Skip {
+			results[i] = buildSkippedTestResult(testInput, "skipped by config (skip: true)")
+			return
+		}
+		if reason, skip := config.EvaluateSkipIf(testInput.SkipIf); skip {
+			results[i] = buildSkippedTestResult(testInput, reason)
+			return
+		}
+
+		if ctx.Err() != nil {
+			results[i] = buildFailedTestResult(testInput, fmt.Sprintf("interrupted: %v", ctx.Err()))
+			return
+		}
+
+		test, err := buildTest(testInput, seqStarts[i], opts.AllowFlood)
+		if err != nil {
+			results[i] = buildFailedTestResult(testInput, err.Error())
+			return
+		}
+		if id, ok := floodIDs[i]; ok {
+			test.ID = id
+		}
+		test.dns = dns
+		test.maxRTTSamples = opts.MaxRTTSamples
+		if cfg.General.SpoofSource != nil && test.Mode != "" {
+			results[i] = buildFailedTestResult(testInput, fmt.Sprintf("general.spoof_source is not supported in %s mode", test.Mode))
+			return
+		}
+
+		testCfg := withTestOverrides(cfg, testInput)
+		if opts.DryRun {
+			results[i] = dryRunICMPTest(ctx, testCfg, test)
+			return
+		}
+
+		// Names sharing an address share its limit, so resolve before waiting for a slot;
+		// the test reuses the outcome, including a failure, instead of resolving again.
+		key := test.Destination
+		if perDest.max > 0 {
+			addr, resolveTime, err := test.resolve(ctx, testCfg)
+			test.resolved = &resolvedDest{addr: addr, time: resolveTime, err: err}
+			if err == nil {
+				key = addr.IP.String()
 			}
-			if duration <= 0 || duration > 10*time.Second {
-				results[i] = buildFailedTestResult(testInput,
-					fmt.Sprintf("invalid timeout %q: must be between 1ms and 10s", timeout))
+		}
+		release, err := perDest.acquire(ctx, key)
+		if err != nil {
+			results[i] = buildFailedTestResult(testInput, fmt.Sprintf("interrupted: %v", err))
+			return
+		}
+		defer release()
+
+		if startDelays[i] > 0 {
+			select {
+			case <-time.After(startDelays[i]):
+			case <-ctx.Done():
+				results[i] = buildFailedTestResult(testInput, fmt.Sprintf("interrupted: %v", ctx.Err()))
 				return
 			}
+		}
 
-			reqType, err := parseICMPRequestType(testInput.RequestType)
-			if err != nil {
-				results[i] = buildFailedTestResult(testInput, err.Error())
-				return
+		logger.Info("test started", "test", test.Name, "dest", test.Destination, "seq", test.Seq)
+		started := time.Now()
+		switch test.Mode {
+		case "flood":
+			results[i] = runFloodTest(ctx, testCfg, test)
+		case "broadcast":
+			results[i] = runBroadcastTest(ctx, testCfg, test)
+		default:
+			results[i] = runICMPTest(ctx, testCfg, test)
+		}
+		results[i].TotalTime = time.Since(started)
+		logger.Info("test finished", "test", test.Name, "status", results[i].Status, "duration", results[i].Duration)
+	}
+
+	// Launch tests concurrently in dependency order. Tests without dependencies start in
+	// config order as slots free up; tests with dependencies wait for them first and are
+	// SKIPPED if any did not pass. Tests that cannot start before the suite timeout
+	// expires are marked as interrupted.
+	for _, i := range order {
+		test := cfg.Tests[i]
+		wg.Add(1)
+		if len(deps[i]) == 0 {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i] = buildFailedTestResult(test, fmt.Sprintf("interrupted: %v", ctx.Err()))
+				finish(i)
+				wg.Done()
+				continue
 			}
+			go func(i int, testInput config.TestInput) {
+				defer func() {
+					finish(i) // before freeing the slot, so -fail-fast stops the next test
+					<-sem
+					wg.Done()
+				}()
+				runTest(i, testInput)
+			}(i, test)
+			continue
+		}
 
-			// Set payload size (default to 32 bytes if not specified)
-			payloadSize := defaultPayloadSize
-			if testInput.PayloadSize != nil {
-				payloadSize = *testInput.PayloadSize
-				// Validate payload size (must be positive and reasonable)
-				if payloadSize < 0 || payloadSize > 65507 { // 65507 = 65535 - 20 (IP header) - 8 (ICMP header)
-					results[i] = buildFailedTestResult(testInput,
-						fmt.Sprintf("invalid payload_size %d: must be between 0 and 65507", payloadSize))
+		go func(i int, testInput config.TestInput) {
+			var holding bool
+			defer func() {
+				finish(i)
+				if holding {
+					<-sem
+				}
+				wg.Done()
+			}()
+			for _, j := range deps[i] {
+				<-done[j]
+			}
+			for _, j := range deps[i] {
+				if results[j].Status != "PASSED" {
+					results[i] = buildSkippedTestResult(testInput,
+						fmt.Sprintf("dependency %q did not pass (status %s)", results[j].Name, results[j].Status))
 					return
 				}
 			}
-
-			test := Test{
-				Name:           testInput.Name,
-				Destination:    testInput.Destination,
-				ID:             pid,
-				Seq:            i + 1,
-				RequestType:    reqType,
-				Timeout:        duration,
-				ExpectedResult: testInput.ExpectedResult,
-				PayloadSize:    payloadSize,
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i] = buildFailedTestResult(testInput, fmt.Sprintf("interrupted: %v", ctx.Err()))
+				return
 			}
-
-			results[i] = runICMPTest(config, test)
+			holding = true
+			runTest(i, testInput)
 		}(i, test)
 	}
 	wg.Wait()
 
-	// Check if any test failed.
-	for _, res := range results {
-		if res.Status != "PASSED" {
-			allPassed = false
-			break
-		}
-	}
-
-	// フィルタリング処理
-	filteredResults := results
-	if len(config.General.ResultFilter) > 0 {
-		tmp := []TestResult{}
-		for _, res := range results {
-			for _, status := range config.General.ResultFilter {
-				if res.Status == status {
-					tmp = append(tmp, res)
-					break
-				}
+	if stopped.Load() && suiteCtx.Err() == nil {
+		for i, res := range results {
+			if res.Status == "FAILED" && strings.HasPrefix(res.Details, "interrupted") {
+				results[i] = buildSkippedTestResult(cfg.Tests[i], "skipped: suite stopped after the first failure (-fail-fast)")
 			}
 		}
-		filteredResults = tmp
 	}
-
-	// Output the results.
-	if config.General.Output == "text" {
-		for _, res := range filteredResults {
-			fmt.Printf("Running test: %s\n", res.Name)
-			fmt.Printf("Destination: %s\n", res.Destination)
-			fmt.Printf("Source IP: %s\n", res.SourceIPAddress)
-			fmt.Printf("Source Interface: %s\n", res.SourceInterface)
-			fmt.Printf("Request Type: %s\n", res.RequestType)
-			fmt.Printf("Expected Result: %s\n", res.ExpectedResult)
-			fmt.Printf("Actual Result: %s\n", res.ActualResult)
-			fmt.Printf("Status: %s\n", res.Status)
-			fmt.Printf("Details: %s\n", res.Details)
-			fmt.Printf("Timestamp: %s\n", res.Timestamp.Format(time.RFC3339Nano))
-			fmt.Println()
-		}
-	} else if config.General.Output == "json" {
-		b, err := json.MarshalIndent(filteredResults, "", "  ")
-		if err != nil {
-			log.Fatalf("JSON marshal error: %v", err)
-		}
-		fmt.Println(string(b))
-	}
-
-	// If any test has FAILED, exit with a nonzero exit code.
-	if !allPassed {
-		os.Exit(1)
+	// Every result, however the test ended, carries its annotations.
+	for i := range results {
+		results[i].Annotations = mergeAnnotations(cfg.General.Labels, cfg.Tests[i].Annotations)
 	}
+	return results, nil
 }
@@ -1,13 +1,23 @@
-package main
+package icmptest
 
 import (
 	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
 	"fmt"
+	"log/slog"
+	"math/rand"
 	"net"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/2matzzz/icmp-test/config"
 	"golang.org/x/net/icmp"
 	"golang.org/x/net/ipv4"
 )
@@ -17,7 +27,7 @@ func TestCreateICMPMessageEcho(t *testing.T) {
 	id := os.Getpid() & 0xffff
 	seq := 42
 	payloadSize := 32
-	msg, err := createICMPMessage(ipv4.ICMPTypeEcho, id, seq, payloadSize)
+	msg, err := createICMPMessage(ipv4.ICMPTypeEcho, id, seq, payloadSize, "")
 	if err != nil {
 		t.Fatalf("createICMPMessage(echo, %d, %d, %d) error: %v", id, seq, payloadSize, err)
 	}
@@ -51,7 +61,7 @@ func TestCreateICMPMessageTimestamp(t *testing.T) {
 	id := os.Getpid() & 0xffff
 	seq := 17
 	payloadSize := 32 // Payload size parameter, but timestamp messages ignore it
-	msg, err := createICMPMessage(ipv4.ICMPTypeTimestamp, id, seq, payloadSize)
+	msg, err := createICMPMessage(ipv4.ICMPTypeTimestamp, id, seq, payloadSize, "")
 	if err != nil {
 		t.Fatalf("createICMPMessage(timestamp, %d, %d, %d) error: %v", id, seq, payloadSize, err)
 	}
@@ -179,321 +189,1877 @@ func getLocalInterfaceAndIP(t *testing.T) (string, string) {
 	return "lo0", "127.0.0.1"
 }
 
-// TestLoadConfigValid verifies that a valid YAML configuration file is loaded correctly.
-func TestLoadConfigValid(t *testing.T) {
-	ifaceName, ipStr := getValidInterfaceAndIP(t)
+// TestCreateICMPMessageEchoPayloadSizes verifies that echo messages are created with various payload sizes.
+func TestCreateICMPMessageEchoPayloadSizes(t *testing.T) {
+	id := os.Getpid() & 0xffff
+	seq := 1
+
+	testCases := []struct {
+		name        string
+		payloadSize int
+		expected    string
+	}{
+		{"Zero payload", 0, ""},
+		{"Small payload", 10, "0123456789"},
+		{"Default payload", 32, "0123456789abcdefghijklmnopqrstuvwxyz"[:32]},
+		{"Large payload", 100, strings.Repeat("0123456789abcdefghijklmnopqrstuvwxyz", 3)[:100]},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			msg, err := createICMPMessage(ipv4.ICMPTypeEcho, id, seq, tc.payloadSize, "")
+			if err != nil {
+				t.Fatalf("createICMPMessage(echo, %d, %d, %d) error: %v", id, seq, tc.payloadSize, err)
+			}
+
+			echo, ok := msg.Body.(*icmp.Echo)
+			if !ok {
+				t.Fatalf("expected body type *icmp.Echo; got %T", msg.Body)
+			}
+
+			if len(echo.Data) != tc.payloadSize {
+				t.Errorf("expected data length %d; got %d", tc.payloadSize, len(echo.Data))
+			}
+
+			// Verify the pattern
+			pattern := "0123456789abcdefghijklmnopqrstuvwxyz"
+			for i := 0; i < len(echo.Data); i++ {
+				expected := pattern[i%len(pattern)]
+				if echo.Data[i] != expected {
+					t.Errorf("data[%d]: expected %c, got %c", i, expected, echo.Data[i])
+				}
+			}
+		})
+	}
+}
+
+// TestCreateICMPMessageEchoPatternConsistency verifies the payload pattern is consistent across multiple creations.
+func TestCreateICMPMessageEchoPatternConsistency(t *testing.T) {
+	id := os.Getpid() & 0xffff
+	payloadSize := 72 // Two full cycles of the 36-character pattern
+
+	// Create multiple messages
+	msg1, err := createICMPMessage(ipv4.ICMPTypeEcho, id, 1, payloadSize, "")
+	if err != nil {
+		t.Fatalf("createICMPMessage error: %v", err)
+	}
+
+	msg2, err := createICMPMessage(ipv4.ICMPTypeEcho, id, 2, payloadSize, "")
+	if err != nil {
+		t.Fatalf("createICMPMessage error: %v", err)
+	}
+
+	echo1, ok := msg1.Body.(*icmp.Echo)
+	if !ok {
+		t.Fatalf("expected body type *icmp.Echo; got %T", msg1.Body)
+	}
+
+	echo2, ok := msg2.Body.(*icmp.Echo)
+	if !ok {
+		t.Fatalf("expected body type *icmp.Echo; got %T", msg2.Body)
+	}
+
+	// Payload should be identical (only ID/Seq should differ)
+	if !bytes.Equal(echo1.Data, echo2.Data) {
+		t.Errorf("payload data should be identical across different message creations")
+	}
+
+	// Verify the pattern is exactly two full cycles
+	expected := "0123456789abcdefghijklmnopqrstuvwxyz0123456789abcdefghijklmnopqrstuvwxyz"
+	if string(echo1.Data) != expected {
+		t.Errorf("expected payload %q; got %q", expected, string(echo1.Data))
+	}
+}
+
+// TestMaxUnfragmentedPayload verifies the MTU-derived payload limit for IPv4 and IPv6 destinations.
+func TestMaxUnfragmentedPayload(t *testing.T) {
+	tests := []struct {
+		mtu      int
+		dst      net.IP
+		expected int
+	}{
+		{1500, net.ParseIP("8.8.8.8"), 1472},
+		{1500, net.ParseIP("2001:db8::1"), 1452},
+		{65536, net.ParseIP("127.0.0.1"), 65508},
+		{1500, nil, 1472},
+	}
+	for _, tc := range tests {
+		if got := maxUnfragmentedPayload(tc.mtu, tc.dst); got != tc.expected {
+			t.Errorf("maxUnfragmentedPayload(%d, %v) = %d, want %d", tc.mtu, tc.dst, got, tc.expected)
+		}
+	}
+}
+
+func TestFragmentationPrediction(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.General.Interface = net.Interface{Name: "eth0", MTU: 1500}
+	dst := net.ParseIP("192.0.2.1")
+
+	if willFragment(cfg, Test{PayloadSize: 1472}, dst) {
+		t.Error("1472-byte payload fits a 1500-byte MTU")
+	}
+	if !willFragment(cfg, Test{PayloadSize: 1473}, dst) {
+		t.Error("1473-byte payload should be fragmented on a 1500-byte MTU")
+	}
+	cfg.General.SetDFBit = true
+	if willFragment(cfg, Test{PayloadSize: 4000}, dst) {
+		t.Error("requests with DF set are never fragmented")
+	}
+
+	if replyFragmented(cfg, 1480, dst) {
+		t.Error("1480-byte ICMP reply fits a 1500-byte MTU")
+	}
+	if !replyFragmented(cfg, 1481, dst) {
+		t.Error("1481-byte ICMP reply cannot arrive unfragmented on a 1500-byte MTU")
+	}
+	cfg.General.Interface.MTU = 0
+	if replyFragmented(cfg, 9000, dst) {
+		t.Error("unknown MTU should not report fragmentation")
+	}
+}
+
+// TestDryRunICMPTest verifies that a dry run describes the packet without sending it.
+func TestResolveDestination(t *testing.T) {
+	ctx := context.Background()
+	dst, resolveTime, err := resolveDestination(ctx, "192.0.2.1", time.Second)
+	if err != nil || !dst.IP.Equal(net.ParseIP("192.0.2.1")) || resolveTime != 0 {
+		t.Errorf("literal address: got %v, %v, %v; want 192.0.2.1 without a lookup", dst, resolveTime, err)
+	}
+	if _, _, err := resolveDestination(ctx, "2001:db8::1", time.Second); err == nil {
+		t.Error("expected an error for an IPv6 destination")
+	}
+	for _, name := range []string{"localhost", "LocalHost.", "app.localhost"} {
+		dst, resolveTime, err := resolveDestination(ctx, name, time.Nanosecond)
+		if err != nil || !dst.IP.Equal(net.ParseIP("127.0.0.1")) || resolveTime != 0 {
+			t.Errorf("%s: got %v, %v, %v; want 127.0.0.1 without a lookup", name, dst, resolveTime, err)
+		}
+	}
+	if isLocalhostName("notlocalhost") {
+		t.Error("notlocalhost is not a localhost name")
+	}
+	// A deadline that has already passed must fail as a DNS timeout, not a lookup error.
+	if _, _, err := resolveDestination(ctx, "icmp-test.invalid", time.Nanosecond); err == nil || !strings.Contains(err.Error(), "DNS timeout") {
+		t.Errorf("expected a DNS timeout, got %v", err)
+	}
+}
+
+func TestDNSCache(t *testing.T) {
+	ctx := context.Background()
+	var none *DNSCache
+	if dst, _, err := none.resolve(ctx, "192.0.2.1", time.Second); err != nil || !dst.IP.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("nil cache: got %v, %v", dst, err)
+	}
+
+	c := NewDNSCache(time.Minute)
+	c.entries["cached.invalid"] = dnsCacheEntry{ip: net.ParseIP("192.0.2.7"), expires: time.Now().Add(time.Minute)}
+	dst, resolveTime, err := c.resolve(ctx, "cached.invalid", time.Nanosecond)
+	if err != nil || !dst.IP.Equal(net.ParseIP("192.0.2.7")) || resolveTime != 0 {
+		t.Errorf("fresh entry: got %v, %v, %v; want 192.0.2.7 from the cache", dst, resolveTime, err)
+	}
+
+	// An expired entry is looked up again, and a failed lookup replaces nothing.
+	c.entries["cached.invalid"] = dnsCacheEntry{ip: net.ParseIP("192.0.2.7"), expires: time.Now().Add(-time.Second)}
+	if _, _, err := c.resolve(ctx, "cached.invalid", time.Nanosecond); err == nil {
+		t.Error("expected the expired entry to be resolved again and time out")
+	}
+	if e := c.entries["cached.invalid"]; !e.ip.Equal(net.ParseIP("192.0.2.7")) {
+		t.Errorf("failed lookup changed the cached entry to %v", e.ip)
+	}
+}
+
+func TestDryRunICMPTest(t *testing.T) {
+	cfg := &config.Config{General: config.GeneralConfig{TOS: 0x10, SetDFBit: true}}
+	test := Test{
+		Name:        "dry",
+		Destination: "127.0.0.1",
+		ID:          1234,
+		Seq:         7,
+		RequestType: ipv4.ICMPTypeEcho,
+		PayloadSize: 32,
+	}
+	result := dryRunICMPTest(context.Background(), cfg, test)
+	if result.Status != "DRY-RUN" {
+		t.Fatalf("expected status DRY-RUN; got %s (%s)", result.Status, result.Details)
+	}
+	if result.RequestSize != 40 {
+		t.Errorf("request size = %d, want 40", result.RequestSize)
+	}
+	for _, want := range []string{"40 bytes", "127.0.0.1", "id=1234", "seq=7", "tos=0x10", "df=true"} {
+		if !strings.Contains(result.Details, want) {
+			t.Errorf("expected details to contain %q; got %q", want, result.Details)
+		}
+	}
+}
+
+// TestDebugDump verifies that hex dumps are only written when debug logging is enabled.
+func TestDebugDump(t *testing.T) {
+	origOutput, origLogger, origLevel := logOutput, logger, logLevel.Level()
+	defer func() {
+		logOutput, logger = origOutput, origLogger
+		logLevel.Set(origLevel)
+	}()
+
+	var buf bytes.Buffer
+	logOutput = &buf
+	logger = slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{Level: logLevel}))
+	packet := []byte{0x08, 0x00, 0xf7, 0xff}
+
+	logLevel.Set(slog.LevelWarn)
+	debugDump(context.Background(), "sent packet", packet, "test", "quiet")
+	if buf.Len() != 0 {
+		t.Fatalf("expected no output at warn level; got %q", buf.String())
+	}
+
+	logLevel.Set(slog.LevelDebug)
+	debugDump(context.Background(), "sent packet", packet, "test", "verbose")
+	out := buf.String()
+	if !strings.Contains(out, "msg=\"sent packet\"") || !strings.Contains(out, "bytes=4") {
+		t.Errorf("expected log line with message and byte count; got %q", out)
+	}
+	if !strings.Contains(out, "08 00 f7 ff") {
+		t.Errorf("expected hex dump of packet; got %q", out)
+	}
+}
+
+// TestPcapWriter verifies the pcap global header and that records carry a valid synthesized IPv4 header.
+func TestPcapWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := newPcapWriter(&buf)
+	if err != nil {
+		t.Fatalf("newPcapWriter error: %v", err)
+	}
+	if buf.Len() != 24 {
+		t.Fatalf("expected 24-byte global header; got %d", buf.Len())
+	}
+	if got := binary.LittleEndian.Uint32(buf.Bytes()[20:24]); got != pcapLinkTypeRaw {
+		t.Errorf("expected link type %d; got %d", pcapLinkTypeRaw, got)
+	}
+
+	icmpData := []byte{0x08, 0x00, 0x00, 0x00, 0x12, 0x34, 0x00, 0x01}
+	ts := time.Unix(1700000000, 123456000)
+	src, dst := net.ParseIP("192.0.2.1"), net.ParseIP("198.51.100.7")
+	if err := w.WritePacket(ts, src, dst, 0x10, 64, true, icmpData); err != nil {
+		t.Fatalf("WritePacket error: %v", err)
+	}
+
+	rec := buf.Bytes()[24:]
+	if got := binary.LittleEndian.Uint32(rec[0:4]); got != 1700000000 {
+		t.Errorf("expected ts_sec 1700000000; got %d", got)
+	}
+	if got := binary.LittleEndian.Uint32(rec[4:8]); got != 123456 {
+		t.Errorf("expected ts_usec 123456; got %d", got)
+	}
+	pkt := rec[16:]
+	if len(pkt) != 20+len(icmpData) || int(binary.LittleEndian.Uint32(rec[8:12])) != len(pkt) {
+		t.Fatalf("unexpected record length %d", len(pkt))
+	}
+	hdr, err := ipv4.ParseHeader(pkt)
+	if err != nil {
+		t.Fatalf("ParseHeader error: %v", err)
+	}
+	if !hdr.Src.Equal(src) || !hdr.Dst.Equal(dst) || hdr.TOS != 0x10 || hdr.TTL != 64 || hdr.Protocol != 1 {
+		t.Errorf("unexpected IPv4 header: %+v", hdr)
+	}
+	if hdr.Flags&ipv4.DontFragment == 0 {
+		t.Errorf("expected DF flag to be set")
+	}
+	if ipv4Checksum(pkt[:20]) != 0 {
+		t.Errorf("IPv4 header checksum does not verify")
+	}
+	if !bytes.Equal(pkt[20:], icmpData) {
+		t.Errorf("expected ICMP payload %x; got %x", icmpData, pkt[20:])
+	}
+}
+
+// TestRecordPackets verifies that the shared send and receive hooks used by probes,
+// flood and broadcast tests add records to the packet capture.
+func TestRecordPackets(t *testing.T) {
+	var buf bytes.Buffer
+	if err := CapturePackets(&buf); err != nil {
+		t.Fatalf("CapturePackets error: %v", err)
+	}
+	defer CapturePackets(nil)
+
+	cfg := &config.Config{}
+	cfg.General.SourceIPAddress = net.ParseIP("192.0.2.1")
+	cfg.General.TOS = 0x10
+	test := Test{Name: "flood"}
+	dst := &net.IPAddr{IP: net.ParseIP("198.51.100.7")}
+	icmpData := []byte{0x08, 0x00, 0x00, 0x00, 0x12, 0x34, 0x00, 0x01}
+
+	recordSent(context.Background(), cfg, test, dst, 64, icmpData)
+	recordReceived(context.Background(), cfg, test, dst, &ipv4.ControlMessage{TTL: 55}, icmpData)
+
+	recs := buf.Bytes()[24:]
+	for i, want := range []struct {
+		src, dst string
+		tos, ttl int
+	}{{"192.0.2.1", "198.51.100.7", 0x10, 64}, {"198.51.100.7", "192.0.2.1", 0, 55}} {
+		if len(recs) < 16 {
+			t.Fatalf("record %d missing", i)
+		}
+		n := int(binary.LittleEndian.Uint32(recs[8:12]))
+		hdr, err := ipv4.ParseHeader(recs[16 : 16+n])
+		if err != nil {
+			t.Fatalf("record %d: ParseHeader error: %v", i, err)
+		}
+		if hdr.Src.String() != want.src || hdr.Dst.String() != want.dst || hdr.TOS != want.tos || hdr.TTL != want.ttl {
+			t.Errorf("record %d: unexpected IPv4 header: %+v", i, hdr)
+		}
+		recs = recs[16+n:]
+	}
+	if len(recs) != 0 {
+		t.Errorf("expected 2 records; got %d trailing bytes", len(recs))
+	}
+}
+
+func TestSeqAllocator(t *testing.T) {
+	seqs := NewSeqAllocator(0xfffd)
+	for _, tc := range []struct{ n, want int }{{1, 0xfffd}, {3, 0xfffe}, {2, 1}, {1, 3}} {
+		if got := seqs.alloc(tc.n); got != tc.want {
+			t.Errorf("alloc(%d) = %d, want %d", tc.n, got, tc.want)
+		}
+	}
+
+	// Repeated runs sharing an allocator get disjoint blocks.
+	count := 3
+	cfg := &config.Config{}
+	cfg.General.Parallelism = 1
+	cfg.Tests = []config.TestInput{
+		{Name: "a", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Count: &count},
+		{Name: "b", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response"},
+	}
+	opts := RunOptions{DryRun: true, Seqs: NewSeqAllocator(500)}
+	var details []string
+	for run := 0; run < 2; run++ {
+		results, err := RunWithOptions(context.Background(), *cfg, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, res := range results {
+			details = append(details, res.Details)
+		}
+	}
+	for i, want := range []string{"seq=500", "seq=503", "seq=504", "seq=507"} {
+		if !strings.Contains(details[i], want) {
+			t.Errorf("result %d details %q, want %s", i, details[i], want)
+		}
+	}
+}
+
+// TestRTTSamples verifies min/avg/max and jitter (mean absolute difference of consecutive samples).
+func TestRTTSamples(t *testing.T) {
+	ms := time.Millisecond
+	tests := []struct {
+		name                  string
+		rtts                  []time.Duration
+		min, avg, max, jitter time.Duration
+	}{
+		{"empty", nil, 0, 0, 0, 0},
+		{"single", []time.Duration{5 * ms}, 5 * ms, 5 * ms, 5 * ms, 0},
+		{"steady", []time.Duration{10 * ms, 10 * ms, 10 * ms}, 10 * ms, 10 * ms, 10 * ms, 0},
+		// diffs: 10, 20, 10 => jitter 40/3
+		{"varying", []time.Duration{10 * ms, 20 * ms, 0, 10 * ms}, 0, 10 * ms, 20 * ms, 40 * ms / 3},
+	}
+	for _, tc := range tests {
+		t.Run(tc.name, func(t *testing.T) {
+			samples := newRTTSamples(0)
+			for _, rtt := range tc.rtts {
+				samples.add(rtt)
+			}
+			min, avg, max, jitter := samples.summary()
+			if min != tc.min || avg != tc.avg || max != tc.max || jitter != tc.jitter {
+				t.Errorf("summary of %v = %v/%v/%v/%v, want %v/%v/%v/%v",
+					tc.rtts, min, avg, max, jitter, tc.min, tc.avg, tc.max, tc.jitter)
+			}
+		})
+	}
+}
+
+// TestRTTSamplesCap verifies that a capped accumulator keeps exact summary statistics
+// while bounding the samples kept for percentiles.
+func TestRTTSamplesCap(t *testing.T) {
+	samples := newRTTSamples(100)
+	for i := 1; i <= 10000; i++ {
+		samples.add(time.Duration(i) * time.Microsecond)
+	}
+	if len(samples.kept) != 100 || samples.count() != 10000 || !samples.sampled() {
+		t.Errorf("kept %d of %d samples (sampled %t), want 100 of 10000", len(samples.kept), samples.count(), samples.sampled())
+	}
+	min, avg, max, _ := samples.summary()
+	if min != time.Microsecond || max != 10*time.Millisecond || avg != 5000500*time.Nanosecond {
+		t.Errorf("min/avg/max = %v/%v/%v, want 1µs/5.0005ms/10ms", min, avg, max)
+	}
+
+	var result TestResult
+	setRTTStats(&result, samples)
+	if result.PacketsReceived != 10000 || len(result.Notes) != 1 {
+		t.Errorf("received %d, notes %q; want 10000 and a sampling note", result.PacketsReceived, result.Notes)
+	}
+}
+
+// TestPercentile verifies linear interpolation between ranks for RTT percentiles.
+func TestPercentile(t *testing.T) {
+	ms := time.Millisecond
+	sorted := []time.Duration{10 * ms, 20 * ms, 30 * ms, 40 * ms, 50 * ms}
+	tests := []struct {
+		p        float64
+		expected time.Duration
+	}{
+		{0, 10 * ms},
+		{50, 30 * ms},
+		{95, 48 * ms},
+		{99, 49600 * time.Microsecond},
+		{100, 50 * ms},
+	}
+	for _, tc := range tests {
+		if got := percentile(sorted, tc.p); got != tc.expected {
+			t.Errorf("percentile(%v, %v) = %v, want %v", sorted, tc.p, got, tc.expected)
+		}
+	}
+	if got := percentile([]time.Duration{7 * ms}, 99); got != 7*ms {
+		t.Errorf("percentile of single sample = %v, want %v", got, 7*ms)
+	}
+}
+
+func TestTestResultJSON(t *testing.T) {
+	b, err := json.Marshal(TestResult{Name: "t", Duration: 1500 * time.Microsecond, Status: "PASSED"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]interface{}
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatal(err)
+	}
+	if got["duration"] != float64(1500000) || got["duration_ms"] != 1.5 {
+		t.Errorf("duration = %v, duration_ms = %v; want 1500000 and 1.5", got["duration"], got["duration_ms"])
+	}
+	if got["name"] != "t" || got["status"] != "PASSED" {
+		t.Errorf("other fields lost: %s", b)
+	}
+}
+
+func TestAggregateRuns(t *testing.T) {
+	run := func(statuses ...string) []TestResult {
+		results := make([]TestResult, len(statuses))
+		for i, s := range statuses {
+			results[i] = TestResult{Name: fmt.Sprintf("t%d", i), Status: s, PacketsSent: 1, BytesSent: 40, TotalTime: time.Second, Duration: time.Second}
+			if s == "PASSED" {
+				results[i].PacketsReceived = 1
+				results[i].BytesReceived = 40
+				results[i].MinRTT = time.Duration(i+1) * time.Millisecond
+				results[i].AvgRTT = results[i].MinRTT
+				results[i].MaxRTT = results[i].MinRTT
+			}
+		}
+		return results
+	}
+	runs := [][]TestResult{
+		run("PASSED", "FAILED", "PASSED", "SKIPPED"),
+		run("PASSED", "FAILED", "FAILED", "SKIPPED"),
+		run("PASSED", "FAILED", "PASSED", "SKIPPED"),
+	}
+	firstStart := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for k := range runs {
+		runs[k][0].Timestamp = firstStart.Add(time.Duration(k) * time.Minute)
+	}
+	got := AggregateRuns(runs)
+
+	want := []struct {
+		status     string
+		runsPassed int
+	}{
+		{"PASSED", 3},
+		{"FAILED", 0},
+		{"FLAKY", 2},
+		{"SKIPPED", 0},
+	}
+	for i, w := range want {
+		if got[i].Status != w.status || got[i].RunsPassed != w.runsPassed || got[i].Runs != 3 {
+			t.Errorf("test %d: got status %s, %d/%d runs; want %s, %d/3",
+				i, got[i].Status, got[i].RunsPassed, got[i].Runs, w.status, w.runsPassed)
+		}
+	}
+	if got[0].PacketsSent != 3 || got[0].PacketsReceived != 3 {
+		t.Errorf("packets = %d/%d, want 3/3", got[0].PacketsReceived, got[0].PacketsSent)
+	}
+	if got[0].BytesSent != 120 || got[0].BytesReceived != 120 {
+		t.Errorf("bytes = %d sent, %d received; want 120, 120", got[0].BytesSent, got[0].BytesReceived)
+	}
+	if !got[0].Timestamp.Equal(firstStart) {
+		t.Errorf("timestamp = %v, want the first run's start %v", got[0].Timestamp, firstStart)
+	}
+	if got[0].TotalTime != 3*time.Second {
+		t.Errorf("total time = %v, want 3s", got[0].TotalTime)
+	}
+	if got[0].Duration != time.Second {
+		t.Errorf("duration = %v, want the 1s mean", got[0].Duration)
+	}
+	if got[2].AvgRTT != 3*time.Millisecond || got[2].PacketsReceived != 2 {
+		t.Errorf("flaky avg RTT = %v over %d replies, want 3ms over 2", got[2].AvgRTT, got[2].PacketsReceived)
+	}
+	if !strings.HasPrefix(got[2].Details, "passed 2/3 runs") {
+		t.Errorf("details = %q", got[2].Details)
+	}
+
+	// A single-probe result carries its RTT in FirstReplyRTT (and Duration) only.
+	single := func(rtt time.Duration) []TestResult {
+		return []TestResult{{Name: "s", Status: "PASSED", PacketsSent: 1, PacketsReceived: 1, Duration: rtt, FirstReplyRTT: rtt}}
+	}
+	got = AggregateRuns([][]TestResult{single(2 * time.Millisecond), single(4 * time.Millisecond)})
+	if got[0].MinRTT != 2*time.Millisecond || got[0].AvgRTT != 3*time.Millisecond || got[0].MaxRTT != 4*time.Millisecond {
+		t.Errorf("single-probe rtt min/avg/max = %v/%v/%v, want 2ms/3ms/4ms", got[0].MinRTT, got[0].AvgRTT, got[0].MaxRTT)
+	}
+	if got[0].Duration != 3*time.Millisecond {
+		t.Errorf("single-probe duration = %v, want the 3ms mean", got[0].Duration)
+	}
+}
+
+func TestParseQuotedRequest(t *testing.T) {
+	// Original IPv4 header (IHL 5) followed by the first 8 bytes of an echo request.
+	data := make([]byte, ipv4HeaderLen+icmpHeaderLen)
+	data[0] = 0x45
+	data[9] = 1 // ICMP
+	copy(data[16:20], net.ParseIP("192.0.2.1").To4())
+	data[ipv4HeaderLen] = 8 // echo request
+	binary.BigEndian.PutUint16(data[ipv4HeaderLen+4:], 0x1234)
+	binary.BigEndian.PutUint16(data[ipv4HeaderLen+6:], 42)
+
+	q, ok := parseQuotedRequest(data)
+	if !ok || q.ID != 0x1234 || q.Seq != 42 || q.Type != 8 || !q.Dst.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("parseQuotedRequest = %+v, %t; want echo request 0x1234/42 to 192.0.2.1", q, ok)
+	}
+	test := Test{RequestType: ipv4.ICMPTypeEcho, ID: 0x1234, Seq: 42}
+	if !q.matches(test, net.ParseIP("192.0.2.1")) {
+		t.Error("quoted request does not match the test that sent it")
+	}
+	if q.matches(test, net.ParseIP("192.0.2.2")) {
+		t.Error("quoted request to another destination matches")
+	}
+	test.Seq = 43
+	if q.matches(test, nil) {
+		t.Error("quoted request with another Seq matches")
+	}
+	if _, ok := parseQuotedRequest(data[:ipv4HeaderLen+4]); ok {
+		t.Error("parseQuotedRequest accepted a truncated quote")
+	}
+}
+
+func TestRecordReplyInterface(t *testing.T) {
+	lo, err := net.InterfaceByName("lo")
+	if err != nil {
+		t.Skip("no loopback interface named lo")
+	}
+	cfg := &config.Config{}
+	cfg.General.Interface = net.Interface{Index: lo.Index + 1000, Name: "egress0"}
+	cfg.General.SourceIPAddress = net.ParseIP("192.0.2.10")
+	dst := &net.IPAddr{IP: net.ParseIP("198.51.100.1")}
+
+	var result TestResult
+	recordReplyInterface(&result, cfg, dst, &probeReply{IfIndex: lo.Index})
+	recordReplyInterface(&result, cfg, dst, &probeReply{IfIndex: lo.Index})
+	if result.ReplyInterface != "lo" {
+		t.Errorf("ReplyInterface = %q, want lo", result.ReplyInterface)
+	}
+	if !result.ReplyInterfaceMismatch || len(result.Notes) != 1 {
+		t.Errorf("expected one mismatch note, got mismatch=%t notes=%q", result.ReplyInterfaceMismatch, result.Notes)
+	}
+
+	// Matching ingress and egress interfaces are not flagged.
+	cfg.General.Interface = *lo
+	result = TestResult{}
+	recordReplyInterface(&result, cfg, dst, &probeReply{IfIndex: lo.Index})
+	if result.ReplyInterfaceMismatch || len(result.Notes) != 0 {
+		t.Errorf("unexpected mismatch: %q", result.Notes)
+	}
+}
+
+func TestDestLimiter(t *testing.T) {
+	l := newDestLimiter(1)
+	release, err := l.acquire(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A different destination is not limited.
+	other, err := l.acquire(context.Background(), "127.0.0.2")
+	if err != nil {
+		t.Fatalf("acquire for another destination failed: %v", err)
+	}
+	other()
+
+	// The same destination waits until the slot is released.
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if _, err := l.acquire(ctx, "127.0.0.1"); err == nil {
+		t.Fatal("second acquire for the same destination did not block")
+	}
+	release()
+	release, err = l.acquire(context.Background(), "127.0.0.1")
+	if err != nil {
+		t.Fatalf("acquire after release failed: %v", err)
+	}
+	release()
+
+	// Unlimited never blocks.
+	u := newDestLimiter(0)
+	for k := 0; k < 3; k++ {
+		if _, err := u.acquire(context.Background(), "127.0.0.1"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+// TestTestResolveReusesOutcome verifies that a destination runSuite already resolved,
+// for the per-destination limit, is not looked up again, and neither is a failure.
+func TestTestResolveReusesOutcome(t *testing.T) {
+	cfg := &config.Config{}
+	addr := &net.IPAddr{IP: net.ParseIP("192.0.2.7")}
+	test := Test{Destination: "unresolvable.invalid", resolved: &resolvedDest{addr: addr, time: 3 * time.Millisecond}}
+	got, rt, err := test.resolve(context.Background(), cfg)
+	if err != nil || got != addr || rt != 3*time.Millisecond {
+		t.Errorf("resolve = %v, %v, %v; want the stored outcome", got, rt, err)
+	}
+	test.resolved = &resolvedDest{err: fmt.Errorf("DNS timeout")}
+	if _, _, err := test.resolve(context.Background(), cfg); err == nil || err.Error() != "DNS timeout" {
+		t.Errorf("resolve error = %v, want the stored failure", err)
+	}
+}
+
+func TestDrawStartDelays(t *testing.T) {
+	a := drawStartDelays(5, 50*time.Millisecond, rand.New(rand.NewSource(42)))
+	b := drawStartDelays(5, 50*time.Millisecond, rand.New(rand.NewSource(42)))
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("same seed gave different delays: %v vs %v", a, b)
+	}
+	for _, d := range a {
+		if d < 0 || d > 50*time.Millisecond {
+			t.Errorf("delay %v out of range [0, 50ms]", d)
+		}
+	}
+	for _, d := range drawStartDelays(3, 0, rand.New(rand.NewSource(1))) {
+		if d != 0 {
+			t.Errorf("expected no delay without start_jitter, got %v", d)
+		}
+	}
+}
+
+func TestShuffleOrder(t *testing.T) {
+	a := []int{0, 1, 2, 3, 4, 5, 6, 7}
+	b := slices.Clone(a)
+	shuffleOrder(a, rand.New(rand.NewSource(42)))
+	shuffleOrder(b, rand.New(rand.NewSource(42)))
+	if !reflect.DeepEqual(a, b) {
+		t.Errorf("same seed gave different orders: %v vs %v", a, b)
+	}
+	sorted := slices.Clone(a)
+	slices.Sort(sorted)
+	if !reflect.DeepEqual(sorted, []int{0, 1, 2, 3, 4, 5, 6, 7}) {
+		t.Errorf("shuffled order %v is not a permutation", a)
+	}
+}
+
+func TestRawReply(t *testing.T) {
+	cfg := &config.Config{}
+	msg := []byte{0, 0, 0xf7, 0xff, 0, 1, 0, 0}
+	if raw := rawReply(cfg, msg); raw != nil {
+		t.Errorf("rawReply without include_raw_reply = %v, want nil", raw)
+	}
+	cfg.General.IncludeRawReply = true
+	raw := rawReply(cfg, msg)
+	msg[0] = 8 // the receive buffer is reused for the next message
+	if !bytes.Equal(raw, []byte{0, 0, 0xf7, 0xff, 0, 1, 0, 0}) {
+		t.Errorf("rawReply = %v, want an independent copy", raw)
+	}
+	b, err := json.Marshal(TestResult{RawReply: raw})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(b), `"raw_reply":"AAD3/wABAAA="`) {
+		t.Errorf("JSON = %s, want raw_reply in base64", b)
+	}
+}
+
+func TestApplyMinResponders(t *testing.T) {
+	two := 2
+	cfg := &config.Config{Tests: []config.TestInput{
+		{Name: "DNS", MinResponders: &two},
+		{Name: "DNS", MinResponders: &two},
+		{Name: "DNS", MinResponders: &two},
+		{Name: "other"},
+	}}
+	results := []TestResult{
+		{Destination: "192.0.2.1", Status: "PASSED"},
+		{Destination: "192.0.2.2", Status: "FAILED", Details: "no response"},
+		{Destination: "192.0.2.3", Status: "PASSED"},
+		{Destination: "192.0.2.4", Status: "FAILED", Details: "no response"},
+	}
+	applyMinResponders(cfg, results)
+	if results[1].Status != "PASSED" || results[1].Details != "tolerated by min_responders: no response" {
+		t.Errorf("tolerated result = %s %q", results[1].Status, results[1].Details)
+	}
+	want := "min_responders 2: 2 of 3 destinations passed; passed: 192.0.2.1, 192.0.2.3; failed: 192.0.2.2"
+	for i := 0; i < 3; i++ {
+		if !slices.Equal(results[i].Notes, []string{want}) {
+			t.Errorf("result %d notes = %q, want %q", i, results[i].Notes, want)
+		}
+	}
+	if results[3].Status != "FAILED" || len(results[3].Notes) != 0 {
+		t.Errorf("ungrouped result changed: %+v", results[3])
+	}
+
+	results[0].Status, results[1].Status, results[0].Notes, results[1].Notes, results[2].Notes = "FAILED", "FAILED", nil, nil, nil
+	applyMinResponders(cfg, results)
+	if results[0].Status != "FAILED" || results[1].Status != "FAILED" {
+		t.Errorf("expected failures to stand below min_responders, got %s and %s", results[0].Status, results[1].Status)
+	}
+}
+
+func TestRunSuiteShuffle(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.General.Parallelism = 1
+	for i := 0; i < 8; i++ {
+		cfg.Tests = append(cfg.Tests, config.TestInput{Name: fmt.Sprintf("t%d", i), Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response"})
+	}
+	// Dependencies launched after their dependents must not block the suite.
+	cfg.Tests[0].DependsOn = []string{"t7"}
+
+	opts := RunOptions{DryRun: true, Shuffle: true, Rand: rand.New(rand.NewSource(1))}
+	results, err := runSuite(context.Background(), cfg, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, r := range results {
+		if want := fmt.Sprintf("t%d", i); r.Name != want {
+			t.Errorf("results[%d] = %q, want config order (%q)", i, r.Name, want)
+		}
+		want := "DRY-RUN"
+		if i == 0 {
+			want = "SKIPPED" // its dependency only ran dry
+		}
+		if r.Status != want {
+			t.Errorf("%s: status %s (%s), want %s", r.Name, r.Status, r.Details, want)
+		}
+	}
+}
+
+func TestRunSuiteFloodID(t *testing.T) {
+	flood, duration, count := "flood", "1s", 5
+	cfg := &config.Config{}
+	cfg.General.Parallelism = 2
+	cfg.Tests = []config.TestInput{
+		{Name: "flood", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Mode: &flood, Duration: &duration},
+		{Name: "count", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Count: &count},
+	}
+	results, err := runSuite(context.Background(), cfg, RunOptions{DryRun: true, AllowFlood: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	floodID, countID := fmt.Sprintf("id=%d ", (pid+1)&0xffff), fmt.Sprintf("id=%d ", pid)
+	if !strings.Contains(results[0].Details, floodID) || !strings.Contains(results[1].Details, countID) {
+		t.Errorf("details = %q, %q; want the flood test on %s and the count test on %s", results[0].Details, results[1].Details, floodID, countID)
+	}
+
+	own, shared := (pid+7)&0xffff, (pid+8)&0xffff
+	cfg.Tests[0].ID = &own
+	if ids, err := assignFloodIDs(cfg.Tests); err != nil || len(ids) != 0 {
+		t.Errorf("flood test with its own id: ids %v, error %v; want no assignment", ids, err)
+	}
+	cfg.Tests[0].ID, cfg.Tests[1].ID = &shared, &shared
+	if _, err := assignFloodIDs(cfg.Tests); err == nil || !strings.Contains(err.Error(), "needs an ICMP ID of its own") {
+		t.Errorf("expected an error for a flood test sharing its id, got %v", err)
+	}
+}
+
+func TestListTests(t *testing.T) {
+	badTimeout := "-1s"
+	cfg := &config.Config{}
+	cfg.General.Interface = net.Interface{Name: "eth0"}
+	cfg.General.SourceIPAddress = net.ParseIP("192.0.2.10")
+	cfg.Tests = []config.TestInput{
+		{Name: "ok", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", DependsOn: []string{"other"}},
+		{Name: "bad", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Timeout: &badTimeout},
+	}
+
+	var buf bytes.Buffer
+	if err := ListTests(&buf, cfg, false); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expected header and 2 tests, got:\n%s", buf.String())
+	}
+	for _, want := range []string{"ok", "127.0.0.1", "192.0.2.10 (eth0)", "32", config.DefaultTimeout, "depends_on other"} {
+		if !strings.Contains(lines[1], want) {
+			t.Errorf("line %q missing %q", lines[1], want)
+		}
+	}
+	if !strings.Contains(lines[2], "invalid: invalid timeout") {
+		t.Errorf("line %q does not report the invalid timeout", lines[2])
+	}
+
+	// Names are resolved within resolve_timeout; one that cannot be answered in time is
+	// listed as unresolved instead of holding up the listing.
+	cfg.General.ResolveTimeout = time.Nanosecond
+	cfg.Tests = []config.TestInput{{Name: "name", Destination: "gw.example.com", RequestType: "echo", ExpectedResult: "response"}}
+	buf.Reset()
+	start := time.Now()
+	if err := ListTests(&buf, cfg, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "unresolved") || time.Since(start) > time.Second {
+		t.Errorf("listing took %v:\n%s\nwant the name unresolved at once", time.Since(start), buf.String())
+	}
+}
+
+func TestHasRawSocketPrivilege(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("only meaningful when running as root")
+	}
+	if !HasRawSocketPrivilege() {
+		t.Error("root should have raw socket privileges")
+	}
+}
+
+func TestBuildTestWarmup(t *testing.T) {
+	count, warmup, negative := 3, 2, -1
+	one := 1
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response"}
+
+	in := base
+	in.Count, in.Warmup = &count, &warmup
+	test, err := buildTest(in, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if test.Warmup != 2 || in.ProbeCount() != 5 {
+		t.Errorf("warmup = %d, probeCount = %d; want 2, 5", test.Warmup, in.ProbeCount())
+	}
+
+	in.Warmup = &negative
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for negative warmup")
+	}
+	in.Count, in.Warmup = &one, &warmup
+	if _, err := buildTest(in, 0, false); err == nil || !strings.Contains(err.Error(), "count > 1") {
+		t.Errorf("expected count > 1 error, got %v", err)
+	}
+}
+
+func TestBuildTestLinger(t *testing.T) {
+	linger, zero, tooLong := "500ms", "0s", "11s"
+	count := 3
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "timeout"}
+
+	in := base
+	in.Linger = &linger
+	test, err := buildTest(in, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if test.Linger != 500*time.Millisecond {
+		t.Errorf("linger = %v, want 500ms", test.Linger)
+	}
+
+	for _, bad := range []*string{&zero, &tooLong} {
+		in.Linger = bad
+		if _, err := buildTest(in, 0, false); err == nil {
+			t.Errorf("expected an error for linger %q", *bad)
+		}
+	}
+	in.Linger, in.Count = &linger, &count
+	if _, err := buildTest(in, 0, false); err == nil || !strings.Contains(err.Error(), "single-probe") {
+		t.Errorf("expected single-probe error, got %v", err)
+	}
+}
+
+func TestBuildTestBroadcast(t *testing.T) {
+	broadcast, two, zero, count := "broadcast", 2, 0, 3
+	base := config.TestInput{Name: "t", Destination: "192.0.2.255", RequestType: "echo", ExpectedResult: "response"}
+
+	in := base
+	in.Mode = &broadcast
+	test, err := buildTest(in, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if test.Mode != "broadcast" || test.MinResponders != 1 {
+		t.Errorf("mode = %q, min_responders = %d; want broadcast, 1", test.Mode, test.MinResponders)
+	}
+	in.MinResponders = &two
+	if test, err := buildTest(in, 0, false); err != nil || test.MinResponders != 2 {
+		t.Errorf("min_responders 2: got %d (err %v)", test.MinResponders, err)
+	}
+
+	in.MinResponders = &zero
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for min_responders 0")
+	}
+	in.MinResponders, in.Count = nil, &count
+	if _, err := buildTest(in, 0, false); err == nil || !strings.Contains(err.Error(), "count must be 1") {
+		t.Errorf("expected a count error, got %v", err)
+	}
+	jitter := "5ms"
+	in.Count, in.MaxJitter = nil, &jitter
+	if _, err := buildTest(in, 0, false); err == nil || !strings.Contains(err.Error(), "max_jitter") {
+		t.Errorf("expected max_jitter in broadcast mode to fail, got %v", err)
+	}
+	in = base
+	in.MinResponders = &two
+	if _, err := buildTest(in, 0, false); err != nil {
+		t.Errorf("expected min_responders without a mode to be accepted, got %v", err)
+	}
+	flood, duration := "flood", "1s"
+	in.Mode, in.Duration = &flood, &duration
+	if _, err := buildTest(in, 0, true); err == nil || !strings.Contains(err.Error(), "not supported in flood mode") {
+		t.Errorf("expected min_responders in flood mode to fail, got %v", err)
+	}
+}
+
+func TestBuildTestStopOnLoss(t *testing.T) {
+	stop, count, one, loss := true, 5, 1, "10%"
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", StopOnLoss: &stop}
+
+	in := base
+	in.Count = &count
+	if test, err := buildTest(in, 0, false); err != nil || !test.StopOnLoss {
+		t.Errorf("stop_on_loss with count 5: got %t (err %v), want true", test.StopOnLoss, err)
+	}
+	in.AssertLossBelow = &loss
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for stop_on_loss with assert_loss_below")
+	}
+	in = base
+	in.Count = &one
+	if _, err := buildTest(in, 0, false); err == nil || !strings.Contains(err.Error(), "count > 1") {
+		t.Errorf("expected count > 1 error, got %v", err)
+	}
+}
+
+func TestBuildTestUnreachable(t *testing.T) {
+	name, number, bogus, count := "admin-prohibited", "3", "nope", 3
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "unreachable"}
+
+	if test, err := buildTest(base, 0, false); err != nil || test.ExpectedCode != -1 {
+		t.Errorf("no expected_code: got %d (err %v), want -1", test.ExpectedCode, err)
+	}
+	in := base
+	in.ExpectedCode = &name
+	if test, err := buildTest(in, 0, false); err != nil || test.ExpectedCode != 13 {
+		t.Errorf("expected_code %q: got %d (err %v), want 13", name, test.ExpectedCode, err)
+	}
+	in.ExpectedCode = &number
+	if test, err := buildTest(in, 0, false); err != nil || test.ExpectedCode != 3 {
+		t.Errorf("expected_code %q: got %d (err %v), want 3", number, test.ExpectedCode, err)
+	}
+	in.ExpectedCode = &bogus
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Errorf("expected an error for expected_code %q", bogus)
+	}
+	in = base
+	in.Count = &count
+	if _, err := buildTest(in, 0, false); err == nil || !strings.Contains(err.Error(), "count must be 1") {
+		t.Errorf("expected a single-probe error, got %v", err)
+	}
+	in = base
+	in.ExpectedResult, in.ExpectedCode = "response", &name
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for expected_code with expected_result response")
+	}
+}
+
+func TestBuildTestExpectedResultList(t *testing.T) {
+	count, code := 3, "admin-prohibited"
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response|unreachable"}
+
+	test, err := buildTest(base, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for outcome, want := range map[string]bool{"response": true, "unreachable": true, "timeout": false} {
+		if got := test.expects(outcome); got != want {
+			t.Errorf("expects(%q) = %t, want %t", outcome, got, want)
+		}
+	}
+	in := base
+	in.ExpectedCode = &code
+	if test, err := buildTest(in, 0, false); err != nil || test.ExpectedCode != 13 {
+		t.Errorf("expected_code with a list including unreachable: got %d (err %v), want 13", test.ExpectedCode, err)
+	}
+
+	for _, tc := range []struct {
+		name   string
+		modify func(*config.TestInput)
+	}{
+		{"unknown entry", func(in *config.TestInput) { in.ExpectedResult = "response|lost" }},
+		{"duplicate entry", func(in *config.TestInput) { in.ExpectedResult = "response|response" }},
+		{"count > 1", func(in *config.TestInput) { in.Count = &count }},
+		{"expected_code without unreachable", func(in *config.TestInput) { in.ExpectedResult, in.ExpectedCode = "response|timeout", &code }},
+	} {
+		in := base
+		tc.modify(&in)
+		if _, err := buildTest(in, 0, false); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
+	}
+}
+
+func TestBuildTestMaxUnexpectedReplies(t *testing.T) {
+	count, one, k, negative := 5, 1, 2, -1
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "timeout", Count: &count, MaxUnexpectedReplies: &k}
+
+	if test, err := buildTest(base, 0, false); err != nil || test.MaxUnexpected != 2 {
+		t.Errorf("max_unexpected_replies 2: got %d (err %v), want 2", test.MaxUnexpected, err)
+	}
+	in := base
+	in.ExpectedResult = "response"
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for max_unexpected_replies with expected_result response")
+	}
+	in = base
+	in.Count = &one
+	if _, err := buildTest(in, 0, false); err == nil || !strings.Contains(err.Error(), "count > 1") {
+		t.Errorf("expected count > 1 error, got %v", err)
+	}
+	in = base
+	in.MaxUnexpectedReplies = &negative
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for negative max_unexpected_replies")
+	}
+}
 
-	// Prepare a YAML configuration content with valid values.
-	yamlContent := fmt.Sprintf(`
-general:
-  output: "json"
-  parallelism: 4
-  tos: 100
-  interfaceName: "%s"
-  sourceIPAddress: "%s"
-tests:
-  - name: "scenario1"
-`, ifaceName, ipStr)
+func TestBuildTestAssertTTL(t *testing.T) {
+	lo, hi, zero := 60, 64, 0
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", AssertTTLMin: &lo, AssertTTLMax: &hi}
 
-	// Write the YAML content to a temporary file.
-	tmpfile, err := os.CreateTemp("", "config-*.yaml")
-	if err != nil {
-		t.Fatal(err)
+	test, err := buildTest(base, 0, false)
+	if err != nil || test.AssertTTLMin != 60 || test.AssertTTLMax != 64 {
+		t.Errorf("got [%d, %d] (err %v), want [60, 64]", test.AssertTTLMin, test.AssertTTLMax, err)
+	}
+	in := base
+	in.AssertTTLMin, in.AssertTTLMax = &hi, &lo
+	if _, err := buildTest(in, 0, false); err == nil || !strings.Contains(err.Error(), "above assert_ttl_max") {
+		t.Errorf("expected an inverted range error, got %v", err)
+	}
+	in = base
+	in.AssertTTLMin = &zero
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for assert_ttl_min 0")
+	}
+	in = base
+	in.ExpectedResult = "timeout"
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for assert_ttl_min with expected_result timeout")
 	}
-	defer os.Remove(tmpfile.Name())
 
-	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
-		t.Fatal(err)
+	peer := &net.IPAddr{IP: net.ParseIP("192.0.2.1")}
+	for _, tc := range []struct {
+		ttl  int
+		want string
+	}{
+		{62, ""},
+		{59, "below assert_ttl_min 60"},
+		{128, "above assert_ttl_max 64"},
+		{0, "unknown"},
+	} {
+		err := checkTTLAssertion(test, &probeReply{Peer: peer, TTL: tc.ttl})
+		if (tc.want == "") != (err == nil) || (err != nil && !strings.Contains(err.Error(), tc.want)) {
+			t.Errorf("TTL %d: got %v, want %q", tc.ttl, err, tc.want)
+		}
 	}
-	tmpfile.Close()
+	if err := checkTTLAssertion(Test{}, &probeReply{Peer: peer}); err != nil {
+		t.Errorf("no bounds: got %v", err)
+	}
+}
 
-	cfg, err := loadConfig(tmpfile.Name())
-	if err != nil {
-		t.Fatalf("Expected no error, but got: %v", err)
+func TestBuildTestCollectGrace(t *testing.T) {
+	grace, off, tooLong, count, timeout := "500ms", "0s", "11s", 5, "2s"
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Count: &count, CollectGrace: &grace}
+
+	if test, err := buildTest(base, 0, false); err != nil || test.CollectGrace != 500*time.Millisecond {
+		t.Errorf("collect_grace 500ms: got %v (err %v)", test.CollectGrace, err)
 	}
+	in := base
+	in.CollectGrace, in.ProbeTimeout = nil, &timeout
+	if test, err := buildTest(in, 0, false); err != nil || test.CollectGrace != 2*time.Second {
+		t.Errorf("no collect_grace: got %v (err %v), want the 2s probe timeout", test.CollectGrace, err)
+	}
+	in.Count = nil
+	if test, err := buildTest(in, 0, false); err != nil || test.CollectGrace != 0 {
+		t.Errorf("single probe: got collect_grace %v (err %v), want none", test.CollectGrace, err)
+	}
+	in = base
+	in.CollectGrace = &off
+	if test, err := buildTest(in, 0, false); err != nil || test.CollectGrace != 0 {
+		t.Errorf("collect_grace 0s: got %v (err %v), want off", test.CollectGrace, err)
+	}
+	in = base
+	in.CollectGrace = &tooLong
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for collect_grace above 10s")
+	}
+	in = base
+	in.Count = nil
+	if _, err := buildTest(in, 0, false); err == nil || !strings.Contains(err.Error(), "multi-probe") {
+		t.Errorf("expected a multi-probe error, got %v", err)
+	}
+}
 
-	// Validate each configuration field.
-	if cfg.General.Output != "json" {
-		t.Errorf("Expected output to be 'json', got: %v", cfg.General.Output)
+// TestAddPendingRTTs verifies that late RTTs take their probe's place, so that jitter
+// and the first reply's RTT follow probe order.
+func TestAddPendingRTTs(t *testing.T) {
+	ms := time.Millisecond
+	var result TestResult
+	rtts := newRTTSamples(0)
+	pending := []pendingProbe{{Seq: 1}, {Seq: 2, RTT: 10 * ms, Answered: true}, {Seq: 3}, {Seq: 4, RTT: 10 * ms, Answered: true}, {Seq: 5}}
+	late := map[int]probeReply{1: {RTT: 20 * ms, Size: 64}, 3: {RTT: 30 * ms, Size: 64}}
+
+	addPendingRTTs(&result, rtts, pending, late)
+	if rtts.count() != 4 || result.BytesReceived != 128 {
+		t.Fatalf("got %d RTTs and %d bytes, want 4 and 128", rtts.count(), result.BytesReceived)
+	}
+	if result.FirstReplyRTT != 20*ms {
+		t.Errorf("FirstReplyRTT = %v, want the late reply's 20ms", result.FirstReplyRTT)
 	}
-	if cfg.General.Parallelism != 4 {
-		t.Errorf("Expected parallelism to be 4, got: %v", cfg.General.Parallelism)
+	// 20, 10, 30, 10ms in probe order; appended after the others, 10, 10, 20, 30ms would give 20ms/3.
+	if _, _, _, jitter := rtts.summary(); jitter != 50*ms/3 {
+		t.Errorf("jitter = %v, want %v", jitter, 50*ms/3)
 	}
-	if cfg.General.TOS != 100 {
-		t.Errorf("Expected TOS to be 100, got: %v", cfg.General.TOS)
+}
+
+// TestCollectLateReplies feeds ICMP messages through a UDP socket, which needs no
+// privileges, and checks that only replies to unanswered probes are collected.
+func TestCollectLateReplies(t *testing.T) {
+	c, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
 	}
-	if len(cfg.Tests) != 1 || cfg.Tests[0].Name != "scenario1" {
-		t.Errorf("Expected tests to be ['scenario1'], got: %v", cfg.Tests)
+	defer c.Close()
+	sender, err := net.Dial("udp4", c.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	test := Test{ID: 42, RequestType: ipv4.ICMPTypeEcho}
+	send := func(typ icmp.Type, id, seq int) {
+		b, err := (&icmp.Message{Type: typ, Body: &icmp.Echo{ID: id, Seq: seq}}).Marshal(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := sender.Write(b); err != nil {
+			t.Fatal(err)
+		}
 	}
+	send(ipv4.ICMPTypeEchoReply, 7, 5)  // another process's reply
+	send(ipv4.ICMPTypeEcho, 42, 5)      // a request, not a reply
+	send(ipv4.ICMPTypeEchoReply, 42, 9) // answered in time already
+	send(ipv4.ICMPTypeEchoReply, 42, 5)
 
-	// Verify that the network interface and source IP are set correctly.
-	// Note: Due to the updated getValidInterfaceAndIP function, we just verify they're non-empty
-	if cfg.General.Interface.Name == "" {
-		t.Errorf("Expected interface to be set, but got empty string")
+	now := time.Now()
+	unanswered := map[int]time.Time{5: now.Add(-time.Second), 6: now}
+	late := collectLateReplies(context.Background(), &config.Config{}, ipv4.NewPacketConn(c), test, unanswered, 200*time.Millisecond, make([]byte, 1500))
+	if len(late) != 1 || late[5].Type != ipv4.ICMPTypeEchoReply || late[5].RTT < time.Second {
+		t.Fatalf("late replies = %+v, want one echo reply to seq 5 at least 1s after its send", late)
 	}
-	if cfg.General.SourceIPAddress == nil {
-		t.Errorf("Expected source IP to be set, but got nil")
+	if _, ok := unanswered[6]; !ok || len(unanswered) != 1 {
+		t.Errorf("unanswered = %v, want only seq 6 left", unanswered)
 	}
+}
 
-	// Log for debugging purposes
-	t.Logf("Using interface: %s, IP: %s", cfg.General.Interface.Name, cfg.General.SourceIPAddress.String())
+func TestBuildTestRetries(t *testing.T) {
+	two, many, three := 2, 11, 3
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Retries: &two}
+
+	if test, err := buildTest(base, 0, false); err != nil || test.Retries != 2 {
+		t.Errorf("retries 2: got %d (err %v), want 2", test.Retries, err)
+	}
+	if n := base.ProbeCount(); n != 3 {
+		t.Errorf("ProbeCount = %d, want 3 sequence numbers for the probe and two retries", n)
+	}
+	in := base
+	in.Retries = &many
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for retries above the maximum")
+	}
+	in = base
+	in.ExpectedResult = "timeout"
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for retries with expected_result timeout")
+	}
+	in = base
+	in.Count = &three
+	if _, err := buildTest(in, 0, false); err == nil || !strings.Contains(err.Error(), "single-probe") {
+		t.Errorf("expected a single-probe error, got %v", err)
+	}
 }
 
-// TestLoadConfigInvalidOutput checks that an invalid output value results in an error.
-func TestLoadConfigInvalidOutput(t *testing.T) {
-	ifaceName, ipStr := getValidInterfaceAndIP(t)
+// shortWriter reports fewer bytes sent than it was given, as no raw socket should.
+type shortWriter struct{}
 
-	yamlContent := fmt.Sprintf(`
-general:
-  output: "xml"
-  parallelism: 4
-  tos: 100
-  interfaceName: "%s"
-  sourceIPAddress: "%s"
-tests:
-  - name: "scenario1"
-`, ifaceName, ipStr)
+func (shortWriter) WriteTo(b []byte, _ *ipv4.ControlMessage, _ net.Addr) (int, error) {
+	return len(b) - 8, nil
+}
 
-	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+func TestSendProbeShortWrite(t *testing.T) {
+	test, err := buildTest(config.TestInput{Name: "t", Destination: "192.0.2.1", RequestType: "echo", ExpectedResult: "response"}, 1, false)
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer os.Remove(tmpfile.Name())
-	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
-		t.Fatal(err)
+	dst := &net.IPAddr{IP: net.ParseIP("192.0.2.1")}
+	reply, err := sendProbe(context.Background(), &config.Config{}, nil, shortWriter{}, nil, dst, test, nil)
+	want := fmt.Sprintf("short write to 192.0.2.1: sent %d of %d bytes", test.PayloadSize, 8+test.PayloadSize)
+	if reply != nil || err == nil || !strings.Contains(err.Error(), want) {
+		t.Errorf("sendProbe = %v, %v; want an error containing %q", reply, err, want)
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	peer := &net.IPAddr{IP: net.ParseIP("192.0.2.1")}
+	reply := &probeReply{Type: ipv4.ICMPTypeDestinationUnreachable, Peer: peer}
+	tests := []struct {
+		name  string
+		reply *probeReply
+		err   error
+		want  bool
+	}{
+		{"timeout", nil, nil, true},
+		{"response", &probeReply{Type: ipv4.ICMPTypeEchoReply, Peer: peer}, nil, false},
+		{"host-unreachable", reply, &unreachableError{Code: 1, Peer: peer}, true},
+		{"admin-prohibited", reply, &unreachableError{Code: 13, Peer: peer}, false},
+		{"port-unreachable", reply, &unreachableError{Code: 3, Peer: peer}, false},
+		{"time exceeded", reply, fmt.Errorf("time exceeded in transit from %v", peer), false},
+	}
+	for _, tc := range tests {
+		if got := retryable(tc.reply, tc.err); got != tc.want {
+			t.Errorf("%s: retryable = %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestBuildTestDeadline(t *testing.T) {
+	deadline, interval, zero := "10s", "1s", "0s"
+	count := 3
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response"}
+
+	in := base
+	in.Deadline, in.Interval = &deadline, &interval
+	test, err := buildTest(in, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if test.Count != 11 || in.ProbeCount() != 11 {
+		t.Errorf("count = %d, probeCount = %d; want 11 probes fitting in 10s at 1s intervals", test.Count, in.ProbeCount())
+	}
+
+	in.Count = &count
+	if test, err := buildTest(in, 0, false); err != nil || test.Count != 3 || test.Deadline != 10*time.Second {
+		t.Errorf("explicit count: got count %d deadline %v (err %v), want 3 and 10s", test.Count, test.Deadline, err)
+	}
+
+	in = base
+	in.Deadline, in.Interval = &deadline, &zero
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for a deadline without count and a zero interval")
+	}
+}
+
+func TestBuildTestProbeTimeout(t *testing.T) {
+	probeTimeout, timeout := "500ms", "2s"
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response"}
+
+	in := base
+	in.ProbeTimeout = &probeTimeout
+	test, err := buildTest(in, 0, false)
+	if err != nil || test.Timeout != 500*time.Millisecond {
+		t.Errorf("probe_timeout: got %v (err %v), want 500ms", test.Timeout, err)
 	}
-	tmpfile.Close()
 
-	_, err = loadConfig(tmpfile.Name())
-	if err == nil {
-		t.Fatal("Expected an error for invalid output value, but got nil")
+	in = base
+	in.Timeout = &timeout
+	test, err = buildTest(in, 0, false)
+	if err != nil || test.Timeout != 2*time.Second {
+		t.Errorf("timeout: got %v (err %v), want 2s", test.Timeout, err)
 	}
-	if !strings.Contains(err.Error(), "invalid output value") {
-		t.Errorf("Expected error message to contain 'invalid output value', got: %v", err)
+
+	in.ProbeTimeout = &probeTimeout
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error when both timeout and probe_timeout are set")
 	}
 }
 
-// TestLoadConfigInvalidTOS checks that an out-of-range TOS value results in an error.
-func TestLoadConfigInvalidTOS(t *testing.T) {
-	ifaceName, ipStr := getValidInterfaceAndIP(t)
+func TestRunSuiteFailFast(t *testing.T) {
+	badTimeout := "-1s"
+	cfg := &config.Config{}
+	cfg.General.Parallelism = 1
+	cfg.Tests = []config.TestInput{
+		{Name: "invalid", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Timeout: &badTimeout},
+		{Name: "next", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response"},
+	}
 
-	yamlContent := fmt.Sprintf(`
-general:
-  output: "json"
-  parallelism: 4
-  tos: 300
-  interfaceName: "%s"
-  sourceIPAddress: "%s"
-tests:
-  - name: "scenario1"
-`, ifaceName, ipStr)
+	results, err := runSuite(context.Background(), cfg, RunOptions{DryRun: true, FailFast: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != "FAILED" {
+		t.Errorf("first test status = %s, want FAILED", results[0].Status)
+	}
+	if results[1].Status != "SKIPPED" || !strings.Contains(results[1].Details, "-fail-fast") {
+		t.Errorf("second test = %s (%s), want SKIPPED by -fail-fast", results[1].Status, results[1].Details)
+	}
 
-	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	results, err = runSuite(context.Background(), cfg, RunOptions{DryRun: true})
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer os.Remove(tmpfile.Name())
-	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+	if results[1].Status != "DRY-RUN" {
+		t.Errorf("without -fail-fast second test status = %s, want DRY-RUN", results[1].Status)
+	}
+}
+
+// TestRunSuiteFailFastMinResponders verifies that a failed member of a min_responders
+// group stops the suite only once the whole group has finished short of the minimum.
+func TestRunSuiteFailFastMinResponders(t *testing.T) {
+	badTimeout, one := "-1s", 1
+	cfg := &config.Config{}
+	cfg.General.Parallelism = 1
+	cfg.Tests = []config.TestInput{
+		{Name: "group", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Timeout: &badTimeout, MinResponders: &one},
+		{Name: "group", Destination: "127.0.0.2", RequestType: "echo", ExpectedResult: "response", MinResponders: &one},
+		{Name: "next", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response"},
+	}
+
+	results, err := runSuite(context.Background(), cfg, RunOptions{DryRun: true, FailFast: true})
+	if err != nil {
 		t.Fatal(err)
 	}
-	tmpfile.Close()
+	if results[1].Status != "DRY-RUN" {
+		t.Errorf("second group member = %s (%s), want DRY-RUN: the group had not finished", results[1].Status, results[1].Details)
+	}
+	// A dry run passes no destination, so the group falls short and stops the suite.
+	if results[2].Status != "SKIPPED" || !strings.Contains(results[2].Details, "-fail-fast") {
+		t.Errorf("test after the group = %s (%s), want SKIPPED by -fail-fast", results[2].Status, results[2].Details)
+	}
+}
+
+// TestRunSuiteDependsOnMinResponders verifies that a test depending on a min_responders
+// group runs when the group met its minimum, even though one member failed.
+func TestRunSuiteDependsOnMinResponders(t *testing.T) {
+	if os.Geteuid() != 0 {
+		t.Skip("needs raw sockets; run as root")
+	}
+	badTimeout, one := "-1s", 1
+	cfg := &config.Config{}
+	cfg.General.Parallelism = 2
+	cfg.Tests = []config.TestInput{
+		{Name: "group", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", MinResponders: &one},
+		{Name: "group", Destination: "127.0.0.2", RequestType: "echo", ExpectedResult: "response", Timeout: &badTimeout, MinResponders: &one},
+		{Name: "after", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", DependsOn: []string{"group"}},
+	}
 
-	_, err = loadConfig(tmpfile.Name())
-	if err == nil {
-		t.Fatal("Expected an error for invalid TOS value, but got nil")
+	results, err := runSuite(context.Background(), cfg, RunOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].Status != "PASSED" {
+		t.Skipf("no echo reply from 127.0.0.1: %s", results[0].Details)
 	}
-	if !strings.Contains(err.Error(), "invalid TOS value") {
-		t.Errorf("Expected error message to contain 'invalid TOS value', got: %v", err)
+	if results[1].Status != "PASSED" || !strings.HasPrefix(results[1].Details, "tolerated by min_responders") {
+		t.Errorf("failed member = %s (%s), want tolerated", results[1].Status, results[1].Details)
+	}
+	if results[2].Status != "PASSED" {
+		t.Errorf("dependent = %s (%s), want PASSED", results[2].Status, results[2].Details)
 	}
 }
 
-// TestLoadConfigNoTests checks that an empty tests section results in an error.
-func TestLoadConfigNoTests(t *testing.T) {
-	ifaceName, ipStr := getValidInterfaceAndIP(t)
+func TestRunSuiteProgress(t *testing.T) {
+	badTimeout := "-1s"
+	cfg := &config.Config{}
+	cfg.General.Parallelism = 2
+	cfg.Tests = []config.TestInput{
+		{Name: "invalid", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Timeout: &badTimeout},
+		{Name: "ok", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response"},
+		{Name: "skipped", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Skip: true},
+	}
+
+	var calls [][3]int
+	opts := RunOptions{DryRun: true, Progress: func(completed, total, failed int) {
+		calls = append(calls, [3]int{completed, total, failed})
+	}}
+	if _, err := runSuite(context.Background(), cfg, opts); err != nil {
+		t.Fatal(err)
+	}
+	if len(calls) != 3 {
+		t.Fatalf("got %d progress calls, want 3: %v", len(calls), calls)
+	}
+	for i, c := range calls {
+		if c[0] != i+1 || c[1] != 3 {
+			t.Errorf("call %d = %v, want completed %d of 3", i, c, i+1)
+		}
+	}
+	if last := calls[2]; last[2] != 1 {
+		t.Errorf("final failed count = %d, want 1", last[2])
+	}
+}
 
-	yamlContent := fmt.Sprintf(`
-general:
-  output: "json"
-  parallelism: 4
-  tos: 100
-  interfaceName: "%s"
-  sourceIPAddress: "%s"
-tests: []
-`, ifaceName, ipStr)
+func TestRunSuiteAnnotations(t *testing.T) {
+	annotations := map[string]string{"ticket": "NET-123", "site": "lab"}
+	cfg := &config.Config{}
+	cfg.General.Parallelism = 1
+	cfg.General.Labels = map[string]string{"site": "dc1", "role": "edge"}
+	cfg.Tests = []config.TestInput{
+		{Name: "run", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Annotations: annotations},
+		{Name: "skipped", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Annotations: annotations, Skip: true},
+		{Name: "plain", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response"},
+	}
 
-	tmpfile, err := os.CreateTemp("", "config-*.yaml")
+	results, err := runSuite(context.Background(), cfg, RunOptions{DryRun: true})
 	if err != nil {
 		t.Fatal(err)
 	}
-	defer os.Remove(tmpfile.Name())
-	if _, err := tmpfile.Write([]byte(yamlContent)); err != nil {
+	for _, res := range results[:2] {
+		if a := res.Annotations; a["ticket"] != "NET-123" || a["site"] != "lab" || a["role"] != "edge" {
+			t.Errorf("%s: annotations = %v, want ticket NET-123, the test's site and the general role", res.Name, a)
+		}
+	}
+	if a := results[2].Annotations; len(a) != 2 || a["site"] != "dc1" || a["role"] != "edge" {
+		t.Errorf("plain: annotations = %v, want only general.labels", a)
+	}
+	if cfg.General.Labels["site"] != "dc1" {
+		t.Errorf("general.labels modified: %v", cfg.General.Labels)
+	}
+}
+
+func TestRunSuiteSpoofSource(t *testing.T) {
+	duration := "1s"
+	flood := "flood"
+	cfg := &config.Config{}
+	cfg.General.Parallelism = 1
+	cfg.General.SpoofSource = net.ParseIP("198.51.100.7").To4()
+	cfg.Tests = []config.TestInput{
+		{Name: "echo", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "timeout"},
+		{Name: "flood", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "timeout", Mode: &flood, Duration: &duration},
+	}
+
+	if _, err := runSuite(context.Background(), cfg, RunOptions{DryRun: true}); err == nil || !strings.Contains(err.Error(), "-allow-spoof") {
+		t.Fatalf("expected an -allow-spoof error, got %v", err)
+	}
+	results, err := runSuite(context.Background(), cfg, RunOptions{DryRun: true, AllowSpoof: true, AllowFlood: true})
+	if err != nil {
 		t.Fatal(err)
 	}
-	tmpfile.Close()
+	if results[0].Status != "DRY-RUN" || !strings.Contains(results[0].Details, "spoof_source=198.51.100.7") {
+		t.Errorf("echo test = %s (%s), want a DRY-RUN naming the spoofed source", results[0].Status, results[0].Details)
+	}
+	if results[1].Status != "FAILED" || !strings.Contains(results[1].Details, "flood mode") {
+		t.Errorf("flood test = %s (%s), want FAILED as unsupported", results[1].Status, results[1].Details)
+	}
+}
 
-	_, err = loadConfig(tmpfile.Name())
-	if err == nil {
-		t.Fatal("Expected an error for empty tests section, but got nil")
+// TestRunCircularDependency verifies that Run returns an error instead of exiting when
+// the suite cannot be ordered.
+func TestRunCircularDependency(t *testing.T) {
+	cfg := config.Config{}
+	cfg.General.Parallelism = 1
+	cfg.Tests = []config.TestInput{
+		{Name: "a", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", DependsOn: []string{"b"}},
+		{Name: "b", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", DependsOn: []string{"a"}},
 	}
-	if !strings.Contains(err.Error(), "no test scenarios found") {
-		t.Errorf("Expected error message to contain 'no test scenarios found', got: %v", err)
+	results, err := Run(context.Background(), cfg)
+	if err == nil || !strings.Contains(err.Error(), "circular dependency") {
+		t.Errorf("Run error = %v, want circular dependency", err)
+	}
+	if results != nil {
+		t.Errorf("Run returned %d results with an error, want none", len(results))
 	}
 }
 
-// TestGetIfaceFromInterfaceName verifies the behavior of getIfaceFromInterfaceName for valid and invalid interface names.
-func TestGetIfaceFromInterfaceName(t *testing.T) {
-	ifaceName, _ := getValidInterfaceAndIP(t)
-	// Test with a valid interface name.
-	iface, err := getIfaceFromInterfaceName(ifaceName)
+func TestBuildTestRaw(t *testing.T) {
+	icmpType, code, big := 15, 3, 256
+	payload, badPayload := "deadbeef", "xyz"
+	size := 8
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "raw", ExpectedResult: "timeout"}
+
+	in := base
+	in.ICMPType, in.ICMPCode, in.Payload = &icmpType, &code, &payload
+	test, err := buildTest(in, 7, false)
 	if err != nil {
-		t.Fatalf("Expected no error for a valid interface name, but got: %v", err)
+		t.Fatalf("unexpected error: %v", err)
 	}
-	if iface.Name != ifaceName {
-		t.Errorf("Expected interface name %s, got %s", ifaceName, iface.Name)
+	if !test.Raw || int(test.RequestType) != 15 || test.ICMPCode != 3 || test.PayloadSize != 4 {
+		t.Errorf("got raw=%t type=%d code=%d payload_size=%d; want raw type 15 code 3 with 4-byte payload",
+			test.Raw, int(test.RequestType), test.ICMPCode, test.PayloadSize)
+	}
+	if got := test.requestTypeName(); got != "raw(type=15,code=3)" {
+		t.Errorf("requestTypeName() = %q", got)
 	}
 
-	// Test with an empty string.
-	_, err = getIfaceFromInterfaceName("")
-	if err == nil {
-		t.Fatal("Expected an error for empty interface name, but got nil")
+	test.ID = 0x1234
+	b, err := buildICMPPacket(test)
+	if err != nil {
+		t.Fatalf("buildICMPPacket error: %v", err)
+	}
+	want := []byte{15, 3, 0, 0, 0x12, 0x34, 0, 7, 0xde, 0xad, 0xbe, 0xef}
+	if b[0] != want[0] || b[1] != want[1] || !bytes.Equal(b[4:], want[4:]) {
+		t.Errorf("packet = % x, want % x (checksum aside)", b, want)
 	}
 
-	// Test with a non-existent interface name.
-	_, err = getIfaceFromInterfaceName("nonexistent_interface_12345")
-	if err == nil {
-		t.Fatal("Expected an error for a non-existent interface name, but got nil")
+	file := filepath.Join(t.TempDir(), "probe.bin")
+	if err := os.WriteFile(file, []byte{1, 2, 3}, 0644); err != nil {
+		t.Fatal(err)
+	}
+	in = base
+	in.ICMPType, in.PayloadFile = &icmpType, &file
+	if test, err := buildTest(in, 0, false); err != nil || !bytes.Equal(test.Payload, []byte{1, 2, 3}) {
+		t.Errorf("payload_file: got %v, %v", test.Payload, err)
 	}
-}
 
-// TestDetermineNetworkInterfaceAndIPAddress_BothSpecified verifies the behavior when both interfaceName and sourceIPAddress are specified.
-func TestDetermineNetworkInterfaceAndIPAddress_BothSpecified(t *testing.T) {
-	ifaceName, ipStr := getValidInterfaceAndIP(t)
-	cfg := inputConfig{
-		General: inputGeneralConfig{
-			InterfaceName:         &ifaceName,
-			SourceIPAddressString: &ipStr,
-		},
+	invalid := []struct {
+		name   string
+		modify func(*config.TestInput)
+	}{
+		{"missing icmp_type", func(in *config.TestInput) {}},
+		{"icmp_type out of range", func(in *config.TestInput) { in.ICMPType = &big }},
+		{"icmp_code out of range", func(in *config.TestInput) { in.ICMPType, in.ICMPCode = &icmpType, &big }},
+		{"bad hex", func(in *config.TestInput) { in.ICMPType, in.Payload = &icmpType, &badPayload }},
+		{"payload and payload_file", func(in *config.TestInput) { in.ICMPType, in.Payload, in.PayloadFile = &icmpType, &payload, &file }},
+		{"payload_size", func(in *config.TestInput) { in.ICMPType, in.PayloadSize = &icmpType, &size }},
+		{"icmp_type without raw", func(in *config.TestInput) { in.RequestType, in.ICMPType = "echo", &icmpType }},
+	}
+	for _, tc := range invalid {
+		in := base
+		tc.modify(&in)
+		if _, err := buildTest(in, 0, false); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
 	}
+}
 
-	iface, ip := determineNetworkInterfaceAndIPAddress(cfg)
-	if iface.Name != ifaceName {
-		t.Errorf("Expected interface name %s, got %s", ifaceName, iface.Name)
+func TestIsRequestNotReply(t *testing.T) {
+	tests := []struct {
+		name    string
+		test    Test
+		msgType ipv4.ICMPType
+		want    bool
+	}{
+		{"echo request copy", Test{RequestType: ipv4.ICMPTypeEcho}, ipv4.ICMPTypeEcho, true},
+		{"echo reply", Test{RequestType: ipv4.ICMPTypeEcho}, ipv4.ICMPTypeEchoReply, false},
+		{"timestamp request copy to local address", Test{RequestType: ipv4.ICMPTypeTimestamp, Destination: "192.0.2.1"}, ipv4.ICMPTypeTimestamp, true},
+		{"timestamp request to loopback is the expected message", Test{RequestType: ipv4.ICMPTypeTimestamp, Destination: "127.0.0.1"}, ipv4.ICMPTypeTimestamp, false},
+		{"raw request copy", Test{Raw: true, RequestType: ipv4.ICMPType(15)}, ipv4.ICMPType(15), true},
+		{"raw reply", Test{Raw: true, RequestType: ipv4.ICMPType(15)}, ipv4.ICMPType(16), false},
 	}
-	if ip == nil || ip.String() != ipStr {
-		t.Errorf("Expected IP %s, got %v", ipStr, ip)
+	for _, tc := range tests {
+		if got := isRequestNotReply(tc.test, tc.msgType); got != tc.want {
+			t.Errorf("%s: isRequestNotReply = %t, want %t", tc.name, got, tc.want)
+		}
 	}
 }
 
-// TestDetermineNetworkInterfaceAndIPAddress_InterfaceOnly verifies the behavior when only interfaceName is specified.
-func TestDetermineNetworkInterfaceAndIPAddress_InterfaceOnly(t *testing.T) {
-	ifaceName, _ := getValidInterfaceAndIP(t)
-	cfg := inputConfig{
-		General: inputGeneralConfig{
-			InterfaceName: &ifaceName,
-		},
+func TestIsSelfDestination(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.General.SourceIPAddress = net.ParseIP("198.51.100.7")
+	for _, tc := range []struct {
+		dst  string
+		want bool
+	}{
+		{"127.0.0.1", true},
+		{"198.51.100.7", true},
+		{"203.0.113.9", false},
+	} {
+		if got := isSelfDestination(cfg, &net.IPAddr{IP: net.ParseIP(tc.dst)}); got != tc.want {
+			t.Errorf("isSelfDestination(%s) = %t, want %t", tc.dst, got, tc.want)
+		}
 	}
-	iface, ip := determineNetworkInterfaceAndIPAddress(cfg)
-	if iface.Name != ifaceName {
-		t.Errorf("Expected interface name %s, got %s", ifaceName, iface.Name)
+
+	// Any address assigned to this host counts, not only the source address.
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		t.Skipf("cannot list interface addresses: %v", err)
 	}
-	if ip == nil || ip.To4() == nil {
-		t.Errorf("Expected a valid IPv4 address for interface %s, got: %v", ifaceName, ip)
+	for _, addr := range addrs {
+		if ipNet, ok := addr.(*net.IPNet); ok && !ipNet.IP.IsLoopback() {
+			if !isSelfDestination(cfg, &net.IPAddr{IP: ipNet.IP}) {
+				t.Errorf("isSelfDestination(%s) = false for a local address", ipNet.IP)
+			}
+			break
+		}
 	}
 }
 
-// TestDetermineNetworkInterfaceAndIPAddress_NeitherSpecified verifies the behavior when neither interfaceName nor sourceIPAddress is specified.
-// Note: This branch returns the first valid interface found on the system.
-func TestDetermineNetworkInterfaceAndIPAddress_NeitherSpecified(t *testing.T) {
-	cfg := inputConfig{
-		General: inputGeneralConfig{},
+func TestBuildTestAssertRTT(t *testing.T) {
+	below, p95, bad, neg := "20ms", "p95", "p42", "-1ms"
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response"}
+
+	in := base
+	in.AssertRTTBelow = &below
+	test, err := buildTest(in, 0, false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if test.AssertRTTBelow != 20*time.Millisecond || test.AssertRTTStat != "avg" {
+		t.Errorf("got %v %s, want 20ms avg", test.AssertRTTBelow, test.AssertRTTStat)
+	}
+	in.AssertRTTStat = &p95
+	if test, err := buildTest(in, 0, false); err != nil || test.AssertRTTStat != "p95" {
+		t.Errorf("assert_rtt_stat p95: got %q, %v", test.AssertRTTStat, err)
 	}
-	iface, ip := determineNetworkInterfaceAndIPAddress(cfg)
-	if ip == nil || ip.To4() == nil {
-		t.Errorf("Expected a valid IPv4 address, got: %v", ip)
+
+	invalid := []struct {
+		name   string
+		modify func(*config.TestInput)
+	}{
+		{"negative threshold", func(in *config.TestInput) { in.AssertRTTBelow = &neg }},
+		{"unknown statistic", func(in *config.TestInput) { in.AssertRTTBelow, in.AssertRTTStat = &below, &bad }},
+		{"statistic without threshold", func(in *config.TestInput) { in.AssertRTTStat = &p95 }},
+		{"timeout expected", func(in *config.TestInput) { in.AssertRTTBelow, in.ExpectedResult = &below, "timeout" }},
 	}
-	if iface.Name == "" {
-		t.Errorf("Expected a valid interface, got: %v", iface)
+	for _, tc := range invalid {
+		in := base
+		tc.modify(&in)
+		if _, err := buildTest(in, 0, false); err == nil {
+			t.Errorf("%s: expected an error", tc.name)
+		}
 	}
 }
 
-// TestCreateICMPMessageEchoPayloadSizes verifies that echo messages are created with various payload sizes.
-func TestCreateICMPMessageEchoPayloadSizes(t *testing.T) {
-	id := os.Getpid() & 0xffff
-	seq := 1
+func TestCheckRTTAssertion(t *testing.T) {
+	result := TestResult{MinRTT: 5 * time.Millisecond, AvgRTT: 10 * time.Millisecond, MaxRTT: 30 * time.Millisecond,
+		P50RTT: 9 * time.Millisecond, P95RTT: 25 * time.Millisecond, P99RTT: 29 * time.Millisecond}
+	tests := []struct {
+		stat    string
+		below   time.Duration
+		wantErr bool
+	}{
+		{"avg", 20 * time.Millisecond, false},
+		{"avg", 10 * time.Millisecond, true}, // equal is not below
+		{"max", 20 * time.Millisecond, true},
+		{"p95", 26 * time.Millisecond, false},
+		{"min", 5 * time.Millisecond, true},
+		{"avg", 0, false}, // no assertion
+	}
+	for _, tc := range tests {
+		err := checkRTTAssertion(result, Test{AssertRTTBelow: tc.below, AssertRTTStat: tc.stat})
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s below %v: error = %v, wantErr %t", tc.stat, tc.below, err, tc.wantErr)
+		}
+	}
+}
 
-	testCases := []struct {
-		name        string
-		payloadSize int
-		expected    string
+func TestCheckRTTLimits(t *testing.T) {
+	result := TestResult{Jitter: 4 * time.Millisecond, P99RTT: 29 * time.Millisecond}
+	tests := []struct {
+		test    Test
+		wantErr string
 	}{
-		{"Zero payload", 0, ""},
-		{"Small payload", 10, "0123456789"},
-		{"Default payload", 32, "0123456789abcdefghijklmnopqrstuvwxyz"[:32]},
-		{"Large payload", 100, strings.Repeat("0123456789abcdefghijklmnopqrstuvwxyz", 3)[:100]},
+		{Test{}, ""},
+		{Test{MaxJitter: 4 * time.Millisecond, MaxP99: 29 * time.Millisecond}, ""}, // equal is within
+		{Test{MaxJitter: 3 * time.Millisecond}, "max_jitter"},
+		{Test{MaxP99: 20 * time.Millisecond}, "max_p99"},
+	}
+	for _, tc := range tests {
+		err := checkRTTLimits(result, tc.test)
+		if (err == nil) != (tc.wantErr == "") || (err != nil && !strings.Contains(err.Error(), tc.wantErr)) {
+			t.Errorf("%+v: error = %v, want %q", tc.test, err, tc.wantErr)
+		}
 	}
+}
 
-	for _, tc := range testCases {
-		t.Run(tc.name, func(t *testing.T) {
-			msg, err := createICMPMessage(ipv4.ICMPTypeEcho, id, seq, tc.payloadSize)
-			if err != nil {
-				t.Fatalf("createICMPMessage(echo, %d, %d, %d) error: %v", id, seq, tc.payloadSize, err)
-			}
+func TestParsePercent(t *testing.T) {
+	valid := map[string]float64{"10%": 10, "0.5%": 0.5, "10": 10, "0%": 0, "100%": 100, " 5 % ": 5}
+	for in, want := range valid {
+		if got, err := parsePercent(in); err != nil || got != want {
+			t.Errorf("parsePercent(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, in := range []string{"", "%", "ten%", "-1%", "101%"} {
+		if _, err := parsePercent(in); err == nil {
+			t.Errorf("parsePercent(%q): expected an error", in)
+		}
+	}
+}
 
-			echo, ok := msg.Body.(*icmp.Echo)
-			if !ok {
-				t.Fatalf("expected body type *icmp.Echo; got %T", msg.Body)
-			}
+func TestBuildTestAssertLoss(t *testing.T) {
+	loss, bad := "10%", "150%"
+	count := 20
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", Count: &count}
 
-			if len(echo.Data) != tc.payloadSize {
-				t.Errorf("expected data length %d; got %d", tc.payloadSize, len(echo.Data))
-			}
+	test, err := buildTest(base, 0, false)
+	if err != nil || test.AssertLoss {
+		t.Fatalf("without assert_loss_below: AssertLoss = %t, err %v; want no loss assertion", test.AssertLoss, err)
+	}
+	in := base
+	in.AssertLossBelow = &loss
+	if test, err := buildTest(in, 0, false); err != nil || !test.AssertLoss || test.MaxLoss != 10 {
+		t.Errorf("got AssertLoss %t, MaxLoss %v, err %v; want 10%%", test.AssertLoss, test.MaxLoss, err)
+	}
+	in.AssertLossBelow = &bad
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for 150%")
+	}
+	in.AssertLossBelow, in.Count = &loss, nil
+	if _, err := buildTest(in, 0, false); err == nil || !strings.Contains(err.Error(), "count > 1") {
+		t.Errorf("single probe: got %v, want count > 1 error", err)
+	}
 
-			// Verify the pattern
-			pattern := "0123456789abcdefghijklmnopqrstuvwxyz"
-			for i := 0; i < len(echo.Data); i++ {
-				expected := pattern[i%len(pattern)]
-				if echo.Data[i] != expected {
-					t.Errorf("data[%d]: expected %c, got %c", i, expected, echo.Data[i])
-				}
-			}
-		})
+	if got := lossPercent(TestResult{PacketsSent: 20, PacketsReceived: 18}); got != 10 {
+		t.Errorf("lossPercent = %v, want 10", got)
 	}
 }
 
-// TestCreateICMPMessageEchoPatternConsistency verifies the payload pattern is consistent across multiple creations.
-func TestCreateICMPMessageEchoPatternConsistency(t *testing.T) {
-	id := os.Getpid() & 0xffff
-	payloadSize := 72 // Two full cycles of the 36-character pattern
+func TestBuildPayload(t *testing.T) {
+	tests := []struct {
+		size    int
+		pattern string
+		want    string
+	}{
+		{0, defaultPayloadPattern, ""},
+		{5, defaultPayloadPattern, "01234"},
+		{40, defaultPayloadPattern, "0123456789abcdefghijklmnopqrstuvwxyz0123"},
+		{7, "ab", "abababa"},
+		{3, "", "\x00\x00\x00"},
+	}
+	for _, tc := range tests {
+		if got := string(buildPayload(tc.size, tc.pattern)); got != tc.want {
+			t.Errorf("buildPayload(%d, %q) = %q, want %q", tc.size, tc.pattern, got, tc.want)
+		}
+	}
 
-	// Create multiple messages
-	msg1, err := createICMPMessage(ipv4.ICMPTypeEcho, id, 1, payloadSize)
+	// Echo requests carry exactly the default pattern.
+	msg, err := createICMPMessage(ipv4.ICMPTypeEcho, 1, 1, 100, "")
 	if err != nil {
 		t.Fatalf("createICMPMessage error: %v", err)
 	}
+	if echo := msg.Body.(*icmp.Echo); !bytes.Equal(echo.Data, buildPayload(100, defaultPayloadPattern)) {
+		t.Errorf("echo payload = %q", echo.Data)
+	}
+}
+
+func TestChecksumMarker(t *testing.T) {
+	data := buildPayload(64, defaultPayloadPattern)
+	addChecksumMarker(data)
+	if err := checkChecksumMarker(data, 64); err != nil {
+		t.Fatalf("intact payload: %v", err)
+	}
+	if err := checkChecksumMarker(data[:40], 64); err == nil || !strings.Contains(err.Error(), "truncated to 40 of 64") {
+		t.Errorf("truncated payload: got %v", err)
+	}
+	if err := checkChecksumMarker(append(slices.Clone(data), 0), 64); err == nil || !strings.Contains(err.Error(), "1 more") {
+		t.Errorf("extended payload: got %v", err)
+	}
+	corrupted := slices.Clone(data)
+	corrupted[10] ^= 0xff
+	if err := checkChecksumMarker(corrupted, 64); err == nil || !strings.Contains(err.Error(), "corrupted") {
+		t.Errorf("corrupted payload: got %v", err)
+	}
 
-	msg2, err := createICMPMessage(ipv4.ICMPTypeEcho, id, 2, payloadSize)
+	small, timestamp, yes := 4, "timestamp", true
+	base := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", VerifyChecksum: &yes}
+	test, err := buildTest(base, 0, false)
+	if err != nil || !test.VerifyChecksum {
+		t.Fatalf("verify_checksum: got %v (err %v)", test.VerifyChecksum, err)
+	}
+	b, err := buildICMPPacket(test)
 	if err != nil {
-		t.Fatalf("createICMPMessage error: %v", err)
+		t.Fatal(err)
+	}
+	if err := checkChecksumMarker(b[8:], test.PayloadSize); err != nil {
+		t.Errorf("request payload carries no valid marker: %v", err)
+	}
+	in := base
+	in.PayloadSize = &small
+	if _, err := buildTest(in, 0, false); err == nil || !strings.Contains(err.Error(), "at least 8") {
+		t.Errorf("expected a payload_size error, got %v", err)
 	}
+	in = base
+	in.RequestType = timestamp
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for verify_checksum with a timestamp request")
+	}
+}
 
-	echo1, ok := msg1.Body.(*icmp.Echo)
-	if !ok {
-		t.Fatalf("expected body type *icmp.Echo; got %T", msg1.Body)
+func TestPayloadPresets(t *testing.T) {
+	now := time.Unix(1700000000, 123456000)
+
+	if got := string(payloadPresets["windows-ping"].build(32, now)); got != "abcdefghijklmnopqrstuvwabcdefghi" {
+		t.Errorf("windows-ping payload = %q", got)
+	}
+	if got := payloadPresets["icmp-test"].build(40, now); !bytes.Equal(got, buildPayload(40, defaultPayloadPattern)) {
+		t.Errorf("icmp-test payload = %q", got)
 	}
 
-	echo2, ok := msg2.Body.(*icmp.Echo)
-	if !ok {
-		t.Fatalf("expected body type *icmp.Echo; got %T", msg2.Body)
+	linux := payloadPresets["linux-ping"].build(56, now)
+	if sec := binary.LittleEndian.Uint64(linux[0:8]); sec != 1700000000 {
+		t.Errorf("linux-ping tv_sec = %d, want 1700000000", sec)
+	}
+	if usec := binary.LittleEndian.Uint64(linux[8:16]); usec != 123456 {
+		t.Errorf("linux-ping tv_usec = %d, want 123456", usec)
+	}
+	if linux[16] != 0x10 || linux[55] != 0x37 {
+		t.Errorf("linux-ping pattern = % x, want bytes counting up from 0x10", linux[16:])
+	}
+	if short := payloadPresets["linux-ping"].build(8, now); !bytes.Equal(short, []byte{0, 1, 2, 3, 4, 5, 6, 7}) {
+		t.Errorf("linux-ping 8-byte payload = % x, want no timeval", short)
 	}
 
-	// Payload should be identical (only ID/Seq should differ)
-	if !bytes.Equal(echo1.Data, echo2.Data) {
-		t.Errorf("payload data should be identical across different message creations")
+	preset, size, bogus := "linux-ping", 100, "bsd-ping"
+	in := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", PayloadPreset: &preset}
+	if test, err := buildTest(in, 0, false); err != nil || test.PayloadSize != 56 {
+		t.Errorf("linux-ping default payload_size = %d (err %v), want 56", test.PayloadSize, err)
+	}
+	in.PayloadSize = &size
+	if test, err := buildTest(in, 0, false); err != nil || test.PayloadSize != 100 {
+		t.Errorf("linux-ping with payload_size 100 = %d (err %v), want 100", test.PayloadSize, err)
+	}
+	in.PayloadPreset = &bogus
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Errorf("expected an error for payload_preset %q", bogus)
+	}
+	in.PayloadPreset, in.RequestType = &preset, "timestamp"
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for payload_preset with a timestamp request")
+	}
+}
+
+func TestBuildTestID(t *testing.T) {
+	id, tooLarge := 12345, 65536
+	in := config.TestInput{Name: "t", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response"}
+
+	if test, err := buildTest(in, 0, false); err != nil || test.ID != pid {
+		t.Errorf("default id = %d, %v; want pid %d", test.ID, err, pid)
+	}
+	in.ID = &id
+	if test, err := buildTest(in, 0, false); err != nil || test.ID != 12345 {
+		t.Errorf("id = %d, %v; want 12345", test.ID, err)
 	}
+	in.ID = &tooLarge
+	if _, err := buildTest(in, 0, false); err == nil {
+		t.Error("expected an error for id 65536")
+	}
+}
 
-	// Verify the pattern is exactly two full cycles
-	expected := "0123456789abcdefghijklmnopqrstuvwxyz0123456789abcdefghijklmnopqrstuvwxyz"
-	if string(echo1.Data) != expected {
-		t.Errorf("expected payload %q; got %q", expected, string(echo1.Data))
+// TestWithTestOverrides verifies that tests expanded by source "all" run and report from
+// their own source address, and that a per-test set_df_bit replaces the general one.
+func TestWithTestOverrides(t *testing.T) {
+	df := true
+	cfg := &config.Config{}
+	cfg.General.Parallelism = 1
+	cfg.General.SourceIPAddress = net.ParseIP("192.0.2.1")
+	cfg.Tests = []config.TestInput{
+		{Name: "general", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response"},
+		{Name: "own", Destination: "127.0.0.1", RequestType: "echo", ExpectedResult: "response", SourceIP: net.ParseIP("192.0.2.2"), SetDFBit: &df},
+	}
+	results, err := runSuite(context.Background(), cfg, RunOptions{DryRun: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if results[0].SourceIPAddress != "192.0.2.1" || results[1].SourceIPAddress != "192.0.2.2" {
+		t.Errorf("sources = %s, %s; want 192.0.2.1, 192.0.2.2", results[0].SourceIPAddress, results[1].SourceIPAddress)
+	}
+	if !strings.Contains(results[0].Details, "df=false") || !strings.Contains(results[1].Details, "df=true") {
+		t.Errorf("details = %q, %q; want df=false, df=true", results[0].Details, results[1].Details)
+	}
+	if cfg.General.SourceIPAddress.String() != "192.0.2.1" || cfg.General.SetDFBit {
+		t.Errorf("general settings changed to source %s, df %t", cfg.General.SourceIPAddress, cfg.General.SetDFBit)
 	}
 }
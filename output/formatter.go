@@ -0,0 +1,80 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+
+	icmptest "github.com/2matzzz/icmp-test"
+	"github.com/2matzzz/icmp-test/config"
+)
+
+// Formatter writes results in one output format. summary counts all results of the
+// run, including any that general.result_filter removed from results.
+type Formatter interface {
+	Format(w io.Writer, results []icmptest.TestResult, summary Summary) error
+}
+
+// FormatterFunc adapts an ordinary function to the Formatter interface.
+type FormatterFunc func(w io.Writer, results []icmptest.TestResult, summary Summary) error
+
+// Format calls f(w, results, summary).
+func (f FormatterFunc) Format(w io.Writer, results []icmptest.TestResult, summary Summary) error {
+	return f(w, results, summary)
+}
+
+var (
+	formattersMu sync.RWMutex
+	formatters   = make(map[string]Formatter)
+)
+
+// Register makes f available as the general.output value name. It panics if name is
+// already registered, so formats are expected to register once, from an init function.
+func Register(name string, f Formatter) {
+	formattersMu.Lock()
+	defer formattersMu.Unlock()
+	if _, dup := formatters[name]; dup {
+		panic(fmt.Sprintf("output: Register called twice for format %q", name))
+	}
+	formatters[name] = f
+	config.RegisterOutputFormat(name)
+}
+
+// Lookup returns the formatter registered as name.
+func Lookup(name string) (Formatter, bool) {
+	formattersMu.RLock()
+	defer formattersMu.RUnlock()
+	f, ok := formatters[name]
+	return f, ok
+}
+
+// Formats returns the registered format names in sorted order.
+func Formats() []string {
+	formattersMu.RLock()
+	defer formattersMu.RUnlock()
+	names := make([]string, 0, len(formatters))
+	for name := range formatters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func init() {
+	Register("text", FormatterFunc(func(w io.Writer, results []icmptest.TestResult, _ Summary) error {
+		return WriteText(w, results)
+	}))
+	Register("json", FormatterFunc(func(w io.Writer, results []icmptest.TestResult, _ Summary) error {
+		return WriteJSON(w, results)
+	}))
+	Register("json-compact", FormatterFunc(func(w io.Writer, results []icmptest.TestResult, _ Summary) error {
+		return WriteCompactJSON(w, results)
+	}))
+	Register("csv", FormatterFunc(func(w io.Writer, results []icmptest.TestResult, _ Summary) error {
+		return WriteCSV(w, results)
+	}))
+	Register("prometheus", FormatterFunc(func(w io.Writer, results []icmptest.TestResult, _ Summary) error {
+		return WritePrometheus(w, results)
+	}))
+}
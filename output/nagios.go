@@ -0,0 +1,71 @@
+package output
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	icmptest "github.com/2matzzz/icmp-test"
+)
+
+// Exit codes of the Nagios plugin API, returned by WriteNagios.
+const (
+	NagiosOK       = 0
+	NagiosWarning  = 1
+	NagiosCritical = 2
+	NagiosUnknown  = 3
+)
+
+// maxNagiosNames caps the failed tests named in the plugin line; the rest are counted.
+const maxNagiosNames = 5
+
+// NagiosThresholds map the number of failed checks to a plugin state. A check is a
+// result that passed, failed or was flaky; FLAKY counts as failed.
+type NagiosThresholds struct {
+	Warning  int // Failed checks from which the state is WARNING
+	Critical int // Failed checks from which the state is CRITICAL
+}
+
+// WriteNagios writes a single line in Nagios plugin format summarizing results, e.g.
+// "CRITICAL - 2/5 checks failed: a, b | passed=3;;;0;5 failed=2;1;2;0;5 ...", and
+// returns the matching plugin exit code. The state is UNKNOWN if no check ran, e.g.
+// because every test was skipped.
+func WriteNagios(w io.Writer, results []icmptest.TestResult, th NagiosThresholds) (int, error) {
+	sum := Summarize(results)
+	checks := sum.Passed + sum.Failed + sum.Flaky
+	failed := sum.Failed + sum.Flaky
+
+	var names []string
+	for _, res := range results {
+		if res.Status == "FAILED" || res.Status == "FLAKY" {
+			// "|" starts the performance data.
+			names = append(names, strings.ReplaceAll(res.Name, "|", "/"))
+		}
+	}
+	if len(names) > maxNagiosNames {
+		names = append(names[:maxNagiosNames], fmt.Sprintf("and %d more", len(names)-maxNagiosNames))
+	}
+
+	code, state := NagiosOK, "OK"
+	switch {
+	case checks == 0:
+		code, state = NagiosUnknown, "UNKNOWN"
+	case failed >= th.Critical:
+		code, state = NagiosCritical, "CRITICAL"
+	case failed >= th.Warning:
+		code, state = NagiosWarning, "WARNING"
+	}
+
+	var text string
+	switch {
+	case checks == 0:
+		text = fmt.Sprintf("no checks ran (%d skipped)", sum.Skipped)
+	case failed == 0:
+		text = fmt.Sprintf("%d/%d checks passed", checks, checks)
+	default:
+		text = fmt.Sprintf("%d/%d checks failed: %s", failed, checks, strings.Join(names, ", "))
+	}
+	_, err := fmt.Fprintf(w, "%s - %s | passed=%d;;;0;%d failed=%d;%d;%d;0;%d flaky=%d;;;0;%d skipped=%d\n",
+		state, text, sum.Passed, checks, failed, th.Warning, th.Critical, checks, sum.Flaky, checks, sum.Skipped)
+	return code, err
+}
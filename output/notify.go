@@ -0,0 +1,153 @@
+package output
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"strings"
+	"time"
+
+	icmptest "github.com/2matzzz/icmp-test"
+	"github.com/2matzzz/icmp-test/config"
+)
+
+// maxNotifyLines caps the tests listed in one webhook message; the rest are counted.
+const maxNotifyLines = 20
+
+// NotifyLimit keeps a test that matches on every run from being reported on every run.
+// The zero value sends every matching result.
+type NotifyLimit struct {
+	StateFile string        // JSON file mapping test name to when it was last notified ("" = no limit)
+	Interval  time.Duration // Minimum time before the same test is notified again
+}
+
+// NotifyResults posts one message summarizing the results whose status is in notifyOn
+// to the webhook at rawURL. Results already notified within limit.Interval are left out,
+// and nothing is sent when no result remains. The body has a single "text" field, which
+// Slack incoming webhooks and most chat tools accept.
+func NotifyResults(ctx context.Context, rawURL string, notifyOn []string, results []icmptest.TestResult, limit NotifyLimit) error {
+	u, err := config.ParseWebhookURL(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid webhook_url: %v", err)
+	}
+	matching := matchingResults(notifyOn, results)
+
+	var state map[string]time.Time
+	now := time.Now()
+	if limit.StateFile != "" {
+		if state, err = readNotifyState(limit.StateFile); err != nil {
+			return err
+		}
+		// A test that stopped matching is reported as soon as it matches again.
+		for name := range state {
+			if !containsTest(matching, name) {
+				delete(state, name)
+			}
+		}
+	}
+	var due []icmptest.TestResult
+	for _, res := range matching {
+		if last, ok := state[res.Name]; ok && now.Sub(last) < limit.Interval {
+			continue
+		}
+		due = append(due, res)
+	}
+
+	if text := notificationText(notifyOn, due, len(results), len(matching)-len(due), limit.Interval); text != "" {
+		body, err := json.Marshal(map[string]string{"text": text})
+		if err != nil {
+			return fmt.Errorf("JSON marshal error: %w", err)
+		}
+		if err := deliver(ctx, u, body); err != nil {
+			return err
+		}
+		if state != nil {
+			for _, res := range due {
+				state[res.Name] = now
+			}
+		}
+	}
+	if state == nil {
+		return nil
+	}
+	return writeNotifyState(limit.StateFile, state)
+}
+
+// matchingResults returns the results whose status is in notifyOn.
+func matchingResults(notifyOn []string, results []icmptest.TestResult) []icmptest.TestResult {
+	var matching []icmptest.TestResult
+	for _, res := range results {
+		for _, status := range notifyOn {
+			if res.Status == status {
+				matching = append(matching, res)
+				break
+			}
+		}
+	}
+	return matching
+}
+
+func containsTest(results []icmptest.TestResult, name string) bool {
+	for _, res := range results {
+		if res.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+// readNotifyState loads the notify state file; a missing file is an empty state.
+func readNotifyState(path string) (map[string]time.Time, error) {
+	state := make(map[string]time.Time)
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return state, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("notify state: %w", err)
+	}
+	if err := json.Unmarshal(b, &state); err != nil {
+		return nil, fmt.Errorf("notify state %s: %w", path, err)
+	}
+	return state, nil
+}
+
+// writeNotifyState replaces the notify state file in the same way as WriteReport.
+func writeNotifyState(path string, state map[string]time.Time) error {
+	b, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("JSON marshal error: %w", err)
+	}
+	if err := writeFileAtomic(path, append(b, '\n')); err != nil {
+		return fmt.Errorf("notify state: %w", err)
+	}
+	return nil
+}
+
+// notificationText formats due, the results to report, one line each with destination,
+// details and timestamp, or returns "" if there are none. total is the number of results
+// in the run and suppressed the matching ones left out because they were notified less
+// than interval ago.
+func notificationText(notifyOn []string, due []icmptest.TestResult, total, suppressed int, interval time.Duration) string {
+	if len(due) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "icmp-test: %d of %d tests %s\n", len(due)+suppressed, total, strings.Join(notifyOn, "/"))
+	for i, res := range due {
+		if i == maxNotifyLines {
+			fmt.Fprintf(&b, "... and %d more\n", len(due)-maxNotifyLines)
+			break
+		}
+		fmt.Fprintf(&b, "%s %s -> %s: %s (%s)\n", res.Status, res.Name, res.Destination, res.Details,
+			res.Timestamp.Format(time.RFC3339))
+	}
+	if suppressed > 0 {
+		fmt.Fprintf(&b, "(%d already reported within the last %v)\n", suppressed, interval)
+	}
+	return strings.TrimSuffix(b.String(), "\n")
+}
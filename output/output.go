@@ -0,0 +1,248 @@
+// Package output writes test results in the formats selected by general.output and
+// the -report flag.
+package output
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	icmptest "github.com/2matzzz/icmp-test"
+)
+
+// WriteText writes each result as a block of "Key: value" lines followed by a blank line.
+func WriteText(w io.Writer, results []icmptest.TestResult) error {
+	var b bytes.Buffer
+	for _, res := range results {
+		fmt.Fprintf(&b, "Running test: %s\n", res.Name)
+		fmt.Fprintf(&b, "Destination: %s\n", res.Destination)
+		fmt.Fprintf(&b, "Source IP: %s\n", res.SourceIPAddress)
+		fmt.Fprintf(&b, "Source Interface: %s\n", res.SourceInterface)
+		if res.VRF != "" {
+			fmt.Fprintf(&b, "VRF: %s\n", res.VRF)
+		}
+		if res.ReplyInterface != "" {
+			fmt.Fprintf(&b, "Reply Interface: %s\n", res.ReplyInterface)
+		}
+		if res.ResolveTime > 0 {
+			fmt.Fprintf(&b, "Resolve Time: %v\n", res.ResolveTime.Round(time.Microsecond))
+		}
+		fmt.Fprintf(&b, "Request Type: %s\n", res.RequestType)
+		fmt.Fprintf(&b, "Expected Result: %s\n", res.ExpectedResult)
+		fmt.Fprintf(&b, "Actual Result: %s\n", res.ActualResult)
+		if res.RequestSize > 0 {
+			fmt.Fprintf(&b, "Request Size: %d bytes%s\n", res.RequestSize, fragmentedSuffix(res.Fragmented))
+		}
+		if res.ReplySize > 0 {
+			fmt.Fprintf(&b, "Reply Size: %d bytes%s\n", res.ReplySize, fragmentedSuffix(res.ReplyFragmented))
+		}
+		if res.Retries > 0 {
+			fmt.Fprintf(&b, "Retries: %d\n", res.Retries)
+		}
+		if res.ReplyTTL > 0 {
+			fmt.Fprintf(&b, "Reply TTL: %d\n", res.ReplyTTL)
+		}
+		if res.PacketsSent > 1 {
+			fmt.Fprintf(&b, "Packets: %d sent, %d received\n", res.PacketsSent, res.PacketsReceived)
+			fmt.Fprintf(&b, "Bytes: %d sent, %d received\n", res.BytesSent, res.BytesReceived)
+			if res.ThroughputPPS > 0 {
+				fmt.Fprintf(&b, "Throughput: %.0f pps\n", res.ThroughputPPS)
+			}
+			if res.PacketsReceived > 0 {
+				fmt.Fprintf(&b, "First Reply RTT: %v\n", res.FirstReplyRTT)
+				fmt.Fprintf(&b, "RTT min/avg/max: %v/%v/%v\n", res.MinRTT, res.AvgRTT, res.MaxRTT)
+				fmt.Fprintf(&b, "RTT p50/p95/p99: %v/%v/%v\n", res.P50RTT, res.P95RTT, res.P99RTT)
+				fmt.Fprintf(&b, "Jitter: %v\n", res.Jitter)
+			}
+		}
+		if len(res.Responders) > 0 {
+			fmt.Fprintf(&b, "Responders: %s\n", strings.Join(res.Responders, ", "))
+		}
+		if res.Duration > 0 {
+			fmt.Fprintf(&b, "Duration: %v\n", res.Duration)
+		}
+		if res.TotalTime > 0 {
+			fmt.Fprintf(&b, "Total Time: %v\n", res.TotalTime.Round(time.Millisecond))
+		}
+		if res.Runs > 1 {
+			fmt.Fprintf(&b, "Runs Passed: %d/%d\n", res.RunsPassed, res.Runs)
+		}
+		fmt.Fprintf(&b, "Status: %s\n", res.Status)
+		fmt.Fprintf(&b, "Details: %s\n", res.Details)
+		for _, note := range res.Notes {
+			fmt.Fprintf(&b, "Note: %s\n", note)
+		}
+		if len(res.Annotations) > 0 {
+			pairs := make([]string, 0, len(res.Annotations))
+			for k, v := range res.Annotations {
+				pairs = append(pairs, k+"="+v)
+			}
+			sort.Strings(pairs)
+			fmt.Fprintf(&b, "Annotations: %s\n", strings.Join(pairs, ", "))
+		}
+		fmt.Fprintf(&b, "Timestamp: %s\n", res.Timestamp.Format(time.RFC3339Nano))
+		b.WriteString("\n")
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// fragmentedSuffix marks a packet size in WriteText as sent or received in fragments.
+func fragmentedSuffix(fragmented bool) string {
+	if fragmented {
+		return " (fragmented)"
+	}
+	return ""
+}
+
+// WriteRepeatSummary writes per-status counts across all tests after a -repeat run,
+// listing flaky tests by name with their pass counts.
+func WriteRepeatSummary(w io.Writer, results []icmptest.TestResult, repeat int) error {
+	counts := make(map[string]int)
+	var flaky []string
+	for _, res := range results {
+		counts[res.Status]++
+		if res.Status == "FLAKY" {
+			flaky = append(flaky, fmt.Sprintf("%s (%d/%d)", res.Name, res.RunsPassed, res.Runs))
+		}
+	}
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "Summary over %d runs: %d passed, %d failed, %d flaky, %d skipped\n",
+		repeat, counts["PASSED"], counts["FAILED"], counts["FLAKY"], counts["SKIPPED"])
+	for _, name := range flaky {
+		fmt.Fprintf(&b, "FLAKY: %s\n", name)
+	}
+	_, err := w.Write(b.Bytes())
+	return err
+}
+
+// WriteJSON writes results as an indented JSON array.
+func WriteJSON(w io.Writer, results []icmptest.TestResult) error {
+	b, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return fmt.Errorf("JSON marshal error: %w", err)
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// WriteCompactJSON writes results as a JSON array on a single line, for log
+// ingestion and grep.
+func WriteCompactJSON(w io.Writer, results []icmptest.TestResult) error {
+	b, err := json.Marshal(results)
+	if err != nil {
+		return fmt.Errorf("JSON marshal error: %w", err)
+	}
+	_, err = fmt.Fprintln(w, string(b))
+	return err
+}
+
+// csvHeader lists the columns written by WriteCSV, in order.
+var csvHeader = []string{
+	"name", "destination", "source_ip", "request_type", "expected",
+	"actual", "status", "duration_ms", "timestamp", "details",
+}
+
+// WriteCSV writes a header row followed by one row per result.
+// Fields are quoted by encoding/csv where needed (e.g. Details containing commas).
+func WriteCSV(w io.Writer, results []icmptest.TestResult) error {
+	cw := csv.NewWriter(w)
+	if err := cw.Write(csvHeader); err != nil {
+		return err
+	}
+	for _, res := range results {
+		record := []string{
+			res.Name,
+			res.Destination,
+			res.SourceIPAddress,
+			res.RequestType,
+			res.ExpectedResult,
+			res.ActualResult,
+			res.Status,
+			strconv.FormatFloat(float64(res.Duration)/float64(time.Millisecond), 'f', 3, 64),
+			res.Timestamp.Format(time.RFC3339Nano),
+			res.Details,
+		}
+		if err := cw.Write(record); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// Summary counts results by status; it heads the -report file.
+type Summary struct {
+	Total   int  `json:"total"`
+	Passed  int  `json:"passed"`
+	Failed  int  `json:"failed"`
+	Flaky   int  `json:"flaky"`
+	Skipped int  `json:"skipped"`
+	Success bool `json:"success"` // No FAILED or FLAKY results; matches a zero exit code
+}
+
+// report is the structure of the -report file.
+type report struct {
+	Summary Summary               `json:"summary"`
+	Results []icmptest.TestResult `json:"results"`
+}
+
+// Summarize counts results by status.
+func Summarize(results []icmptest.TestResult) Summary {
+	sum := Summary{Total: len(results)}
+	for _, res := range results {
+		switch res.Status {
+		case "PASSED":
+			sum.Passed++
+		case "FAILED":
+			sum.Failed++
+		case "FLAKY":
+			sum.Flaky++
+		case "SKIPPED":
+			sum.Skipped++
+		}
+	}
+	sum.Success = sum.Failed == 0 && sum.Flaky == 0
+	return sum
+}
+
+// WriteReport writes all results and their summary as JSON to path. The file is written
+// to a temporary file in the same directory and renamed into place, so readers never
+// see a partial report.
+func WriteReport(path string, results []icmptest.TestResult) error {
+	b, err := json.MarshalIndent(report{Summary: Summarize(results), Results: results}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("JSON marshal error: %w", err)
+	}
+	return writeFileAtomic(path, append(b, '\n'))
+}
+
+// writeFileAtomic writes b to a temporary file in the directory of path and renames it
+// into place.
+func writeFileAtomic(path string, b []byte) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name()) // no-op after a successful rename
+	if err := tmp.Chmod(0o644); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,380 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	icmptest "github.com/2matzzz/icmp-test"
+	"github.com/2matzzz/icmp-test/config"
+)
+
+// TestWriteCSV verifies the CSV header, column order, and quoting of fields containing commas.
+func TestWriteCSV(t *testing.T) {
+	ts := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	results := []icmptest.TestResult{
+		{
+			Name:            "scenario, with comma",
+			Destination:     "8.8.8.8",
+			SourceIPAddress: "192.0.2.1",
+			RequestType:     "echo",
+			ExpectedResult:  "response",
+			ActualResult:    "echo reply",
+			Status:          "PASSED",
+			Duration:        1500 * time.Microsecond,
+			Timestamp:       ts,
+			Details:         "received expected response echo reply from 8.8.8.8",
+		},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteCSV(&buf, results); err != nil {
+		t.Fatalf("WriteCSV error: %v", err)
+	}
+
+	records, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatalf("failed to parse CSV output: %v", err)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records (header + 1 row); got %d", len(records))
+	}
+	if strings.Join(records[0], ",") != strings.Join(csvHeader, ",") {
+		t.Errorf("expected header %v; got %v", csvHeader, records[0])
+	}
+	row := records[1]
+	if row[0] != "scenario, with comma" {
+		t.Errorf("expected name to round-trip; got %q", row[0])
+	}
+	if row[7] != "1.500" {
+		t.Errorf("expected duration_ms 1.500; got %q", row[7])
+	}
+	if row[8] != ts.Format(time.RFC3339Nano) {
+		t.Errorf("expected timestamp %q; got %q", ts.Format(time.RFC3339Nano), row[8])
+	}
+}
+
+func TestWriteReport(t *testing.T) {
+	dir := t.TempDir()
+	path := dir + "/report.json"
+	results := []icmptest.TestResult{
+		{Name: "a", Status: "PASSED"},
+		{Name: "b", Status: "FAILED"},
+		{Name: "c", Status: "SKIPPED"},
+	}
+	if err := WriteReport(path, results); err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got report
+	if err := json.Unmarshal(b, &got); err != nil {
+		t.Fatalf("report is not valid JSON: %v", err)
+	}
+	want := Summary{Total: 3, Passed: 1, Failed: 1, Skipped: 1, Success: false}
+	if got.Summary != want {
+		t.Errorf("summary = %+v, want %+v", got.Summary, want)
+	}
+	if len(got.Results) != 3 {
+		t.Errorf("got %d results, want 3", len(got.Results))
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 {
+		t.Errorf("expected only the report in %s, found %d entries", dir, len(entries))
+	}
+}
+
+func TestPushResults(t *testing.T) {
+	defer func(d time.Duration) { pushRetryDelay = d }(pushRetryDelay)
+	pushRetryDelay = time.Millisecond
+	results := []icmptest.TestResult{{Name: "t", Status: "PASSED"}, {Name: "u", Status: "FAILED"}}
+
+	var requests int
+	var got report
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if requests == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("request body: %v", err)
+		}
+	}))
+	defer srv.Close()
+	if err := PushResults(context.Background(), srv.URL, results); err != nil {
+		t.Fatalf("PushResults error: %v", err)
+	}
+	if requests != 2 || got.Summary.Total != 2 || got.Summary.Failed != 1 || len(got.Results) != 2 {
+		t.Errorf("after %d requests got summary %+v with %d results", requests, got.Summary, len(got.Results))
+	}
+
+	requests = 0
+	rejecting := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer rejecting.Close()
+	if err := PushResults(context.Background(), rejecting.URL, results); err == nil || requests != 1 {
+		t.Errorf("4xx: got %v after %d requests, want an error without retries", err, requests)
+	}
+
+	sock := filepath.Join(t.TempDir(), "collector.sock")
+	ln, err := net.Listen("unix", sock)
+	if err != nil {
+		t.Skipf("cannot listen on unix socket: %v", err)
+	}
+	defer ln.Close()
+	received := make(chan []byte, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		b, _ := io.ReadAll(conn)
+		received <- b
+	}()
+	if err := PushResults(context.Background(), "unix://"+sock, results); err != nil {
+		t.Fatalf("unix push error: %v", err)
+	}
+	b := <-received
+	if !bytes.HasSuffix(b, []byte("\n")) || !json.Valid(b) {
+		t.Errorf("unix push sent %q, want one JSON document and a newline", b)
+	}
+}
+
+func TestNotifyResults(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	results := []icmptest.TestResult{
+		{Name: "ok", Destination: "192.0.2.1", Status: "PASSED", Timestamp: ts},
+		{Name: "down", Destination: "192.0.2.2", Status: "FAILED", Details: "expected response, but timeout occurred", Timestamp: ts},
+	}
+
+	var got map[string]string
+	var requests int
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("request body: %v", err)
+		}
+	}))
+	defer srv.Close()
+
+	if err := NotifyResults(context.Background(), srv.URL, []string{"FAILED"}, results, NotifyLimit{}); err != nil {
+		t.Fatalf("NotifyResults error: %v", err)
+	}
+	want := "icmp-test: 1 of 2 tests FAILED\nFAILED down -> 192.0.2.2: expected response, but timeout occurred (2024-05-01T12:00:00Z)"
+	if requests != 1 || got["text"] != want {
+		t.Errorf("after %d requests text = %q, want %q", requests, got["text"], want)
+	}
+
+	// Nothing matches: no message.
+	if err := NotifyResults(context.Background(), srv.URL, []string{"FLAKY"}, results, NotifyLimit{}); err != nil || requests != 1 {
+		t.Errorf("no matching results: err %v after %d requests, want no request", err, requests)
+	}
+}
+
+// TestNotifyResultsLimit verifies that a test still failing is not reported again within
+// the interval, and that one that recovered is reported as soon as it fails again.
+func TestNotifyResultsLimit(t *testing.T) {
+	var texts []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var got map[string]string
+		if err := json.NewDecoder(r.Body).Decode(&got); err != nil {
+			t.Errorf("request body: %v", err)
+		}
+		texts = append(texts, got["text"])
+	}))
+	defer srv.Close()
+
+	limit := NotifyLimit{StateFile: filepath.Join(t.TempDir(), "notify.json"), Interval: time.Hour}
+	run := func(statuses ...string) {
+		t.Helper()
+		var results []icmptest.TestResult
+		for i, status := range statuses {
+			results = append(results, icmptest.TestResult{Name: fmt.Sprintf("t%d", i), Destination: "192.0.2.1", Status: status})
+		}
+		if err := NotifyResults(context.Background(), srv.URL, []string{"FAILED"}, results, limit); err != nil {
+			t.Fatalf("NotifyResults error: %v", err)
+		}
+	}
+
+	run("FAILED", "PASSED")
+	run("FAILED", "PASSED") // t0 already reported
+	if len(texts) != 1 {
+		t.Fatalf("sent %d messages for a test failing twice, want 1: %q", len(texts), texts)
+	}
+	run("FAILED", "FAILED") // only t1 is new
+	if len(texts) != 2 || strings.Contains(texts[1], "t0") || !strings.Contains(texts[1], "FAILED t1") ||
+		!strings.Contains(texts[1], "2 of 2 tests FAILED") || !strings.Contains(texts[1], "1 already reported within the last 1h0m0s") {
+		t.Fatalf("messages = %q, want a second one about t1 only", texts)
+	}
+	run("PASSED", "FAILED")
+	run("FAILED", "FAILED") // t0 recovered in between
+	if len(texts) != 3 || !strings.Contains(texts[2], "FAILED t0") || strings.Contains(texts[2], "FAILED t1") {
+		t.Fatalf("messages = %q, want a third one about t0 only", texts)
+	}
+}
+
+// TestWriteCompactJSON verifies that compact JSON is one line holding the same array as
+// the indented format.
+func TestWriteCompactJSON(t *testing.T) {
+	results := []icmptest.TestResult{
+		{Name: "a", Destination: "127.0.0.1", Status: "PASSED", Notes: []string{"first"}},
+		{Name: "b", Destination: "192.0.2.1", Status: "FAILED"},
+	}
+	var compact, indented bytes.Buffer
+	if err := WriteCompactJSON(&compact, results); err != nil {
+		t.Fatal(err)
+	}
+	if err := WriteJSON(&indented, results); err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(compact.String(), "\n"); n != 1 || !strings.HasSuffix(compact.String(), "\n") {
+		t.Errorf("compact output has %d newlines, want a single trailing one: %q", n, compact.String())
+	}
+	var want bytes.Buffer
+	if err := json.Compact(&want, indented.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(compact.String()) != strings.TrimSpace(want.String()) {
+		t.Errorf("compact output = %s, want %s", compact.String(), want.String())
+	}
+}
+
+// TestWriteNagios verifies the plugin state and exit code for each threshold, and the
+// summary line with its performance data.
+func TestWriteNagios(t *testing.T) {
+	results := func(statuses ...string) []icmptest.TestResult {
+		var rs []icmptest.TestResult
+		for i, st := range statuses {
+			rs = append(rs, icmptest.TestResult{Name: fmt.Sprintf("t%d", i), Status: st})
+		}
+		return rs
+	}
+	th := NagiosThresholds{Warning: 1, Critical: 2}
+	tests := []struct {
+		results []icmptest.TestResult
+		code    int
+		line    string
+	}{
+		{results("PASSED", "PASSED"), NagiosOK,
+			"OK - 2/2 checks passed | passed=2;;;0;2 failed=0;1;2;0;2 flaky=0;;;0;2 skipped=0\n"},
+		{results("PASSED", "FAILED", "SKIPPED"), NagiosWarning,
+			"WARNING - 1/2 checks failed: t1 | passed=1;;;0;2 failed=1;1;2;0;2 flaky=0;;;0;2 skipped=1\n"},
+		{results("FLAKY", "FAILED", "PASSED"), NagiosCritical,
+			"CRITICAL - 2/3 checks failed: t0, t1 | passed=1;;;0;3 failed=2;1;2;0;3 flaky=1;;;0;3 skipped=0\n"},
+		{results("SKIPPED"), NagiosUnknown,
+			"UNKNOWN - no checks ran (1 skipped) | passed=0;;;0;0 failed=0;1;2;0;0 flaky=0;;;0;0 skipped=1\n"},
+	}
+	for _, tc := range tests {
+		var buf bytes.Buffer
+		code, err := WriteNagios(&buf, tc.results, th)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if code != tc.code || buf.String() != tc.line {
+			t.Errorf("WriteNagios = %d %q, want %d %q", code, buf.String(), tc.code, tc.line)
+		}
+	}
+
+	var buf bytes.Buffer
+	many := results("FAILED", "FAILED", "FAILED", "FAILED", "FAILED", "FAILED", "FAILED")
+	many[0].Name = "a|b"
+	if _, err := WriteNagios(&buf, many, th); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(buf.String(), "CRITICAL - 7/7 checks failed: a/b, t1, t2, t3, t4, and 2 more | ") {
+		t.Errorf("WriteNagios = %q, want five names, the rest counted and no extra \"|\"", buf.String())
+	}
+}
+
+// TestFormatterRegistry verifies the built-in formats, that registering a format makes it
+// a valid general.output value, and that duplicate names are rejected.
+func TestFormatterRegistry(t *testing.T) {
+	results := []icmptest.TestResult{{Name: "a", Destination: "127.0.0.1", Status: "PASSED"}}
+	summary := Summarize(results)
+	for _, name := range []string{"text", "json", "json-compact", "csv"} {
+		f, ok := Lookup(name)
+		if !ok {
+			t.Errorf("format %q not registered", name)
+			continue
+		}
+		var buf bytes.Buffer
+		if err := f.Format(&buf, results, summary); err != nil || !strings.Contains(buf.String(), "127.0.0.1") {
+			t.Errorf("%s: Format = %q, %v; want the result written", name, buf.String(), err)
+		}
+	}
+
+	var got Summary
+	Register("test-summary", FormatterFunc(func(w io.Writer, results []icmptest.TestResult, summary Summary) error {
+		got = summary
+		return nil
+	}))
+	if !slices.Contains(Formats(), "test-summary") || !slices.Contains(config.OutputFormats(), "test-summary") {
+		t.Errorf("Formats() = %v, config.OutputFormats() = %v; want both to include test-summary", Formats(), config.OutputFormats())
+	}
+	f, _ := Lookup("test-summary")
+	if err := f.Format(io.Discard, nil, summary); err != nil || got != summary {
+		t.Errorf("Format passed summary %+v (error %v), want %+v", got, err, summary)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering json twice did not panic")
+		}
+	}()
+	Register("json", FormatterFunc(func(io.Writer, []icmptest.TestResult, Summary) error { return nil }))
+}
+
+func TestWritePrometheus(t *testing.T) {
+	ms := time.Millisecond
+	results := []icmptest.TestResult{
+		{Name: `probe "a"`, Destination: "192.0.2.1", Status: "PASSED", PacketsSent: 10, PacketsReceived: 9,
+			MinRTT: ms, AvgRTT: 2 * ms, MaxRTT: 5 * ms, P50RTT: 2 * ms, P95RTT: 4 * ms, P99RTT: 5 * ms, Jitter: 1500 * time.Microsecond},
+		{Name: "single", Destination: "192.0.2.2", Status: "FAILED", PacketsSent: 1},
+		{Name: "skipped", Destination: "192.0.2.3", Status: "SKIPPED"},
+	}
+	var buf bytes.Buffer
+	if err := WritePrometheus(&buf, results); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"# TYPE icmp_test_jitter_seconds gauge\n",
+		`icmp_test_jitter_seconds{test="probe \"a\"",dest="192.0.2.1"} 0.0015` + "\n",
+		`icmp_test_rtt_seconds{test="probe \"a\"",dest="192.0.2.1",stat="p99"} 0.005` + "\n",
+		`icmp_test_passed{test="single",dest="192.0.2.2"} 0` + "\n",
+		`icmp_test_packets_received{test="probe \"a\"",dest="192.0.2.1"} 9` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output lacks %q:\n%s", want, out)
+		}
+	}
+	if strings.Contains(out, "skipped") || strings.Contains(out, `icmp_test_jitter_seconds{test="single"`) {
+		t.Errorf("unexpected samples:\n%s", out)
+	}
+	if _, ok := Lookup("prometheus"); !ok {
+		t.Error("prometheus format is not registered")
+	}
+}
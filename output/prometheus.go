@@ -0,0 +1,85 @@
+package output
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	icmptest "github.com/2matzzz/icmp-test"
+)
+
+// promLabelEscaper escapes label values as the Prometheus text format requires.
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// WritePrometheus writes results as gauges in the Prometheus text exposition format,
+// e.g. for the node_exporter textfile collector. Every sample is labelled with the test
+// name and destination. Skipped and dry-run results have no samples; RTT statistics need
+// at least one reply and jitter at least two.
+func WritePrometheus(w io.Writer, results []icmptest.TestResult) error {
+	var b bytes.Buffer
+	family := func(name, help string, sample func(res icmptest.TestResult) []string) {
+		var lines []string
+		for _, res := range results {
+			if res.Status != "PASSED" && res.Status != "FAILED" && res.Status != "FLAKY" {
+				continue
+			}
+			labels := fmt.Sprintf(`test="%s",dest="%s"`, promLabelEscaper.Replace(res.Name), promLabelEscaper.Replace(res.Destination))
+			for _, s := range sample(res) {
+				lines = append(lines, name+"{"+labels+s+"\n")
+			}
+		}
+		if len(lines) == 0 {
+			return
+		}
+		fmt.Fprintf(&b, "# HELP %s %s\n# TYPE %s gauge\n", name, help, name)
+		b.WriteString(strings.Join(lines, ""))
+	}
+	// value formats v as the rest of a sample line after the common labels.
+	value := func(extra string, v float64) string {
+		return extra + "} " + strconv.FormatFloat(v, 'g', -1, 64)
+	}
+	seconds := func(d time.Duration) float64 { return d.Seconds() }
+
+	family("icmp_test_passed", "Whether the test passed (1) or not (0).", func(res icmptest.TestResult) []string {
+		passed := 0.0
+		if res.Status == "PASSED" {
+			passed = 1
+		}
+		return []string{value("", passed)}
+	})
+	family("icmp_test_packets_sent", "ICMP requests sent.", func(res icmptest.TestResult) []string {
+		return []string{value("", float64(res.PacketsSent))}
+	})
+	family("icmp_test_packets_received", "Replies received.", func(res icmptest.TestResult) []string {
+		return []string{value("", float64(res.PacketsReceived))}
+	})
+	family("icmp_test_rtt_seconds", "RTT statistics over the replies received.", func(res icmptest.TestResult) []string {
+		if res.PacketsReceived == 0 {
+			return nil
+		}
+		if res.MaxRTT == 0 {
+			// Single-probe results carry their one RTT only.
+			return []string{value(`,stat="avg"`, seconds(res.FirstReplyRTT))}
+		}
+		return []string{
+			value(`,stat="min"`, seconds(res.MinRTT)),
+			value(`,stat="avg"`, seconds(res.AvgRTT)),
+			value(`,stat="max"`, seconds(res.MaxRTT)),
+			value(`,stat="p50"`, seconds(res.P50RTT)),
+			value(`,stat="p95"`, seconds(res.P95RTT)),
+			value(`,stat="p99"`, seconds(res.P99RTT)),
+		}
+	})
+	family("icmp_test_jitter_seconds", "Mean absolute difference between consecutive RTTs (RFC 3550 style).", func(res icmptest.TestResult) []string {
+		if res.PacketsReceived < 2 {
+			return nil
+		}
+		return []string{value("", seconds(res.Jitter))}
+	})
+
+	_, err := w.Write(b.Bytes())
+	return err
+}
@@ -0,0 +1,117 @@
+package output
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/url"
+	"time"
+
+	icmptest "github.com/2matzzz/icmp-test"
+	"github.com/2matzzz/icmp-test/config"
+)
+
+const (
+	pushAttempts = 3                // Tries per push before giving up
+	pushTimeout  = 10 * time.Second // Time limit of each try
+)
+
+// pushRetryDelay is the wait before the second try; it doubles for each further try.
+var pushRetryDelay = time.Second
+
+// PushResults sends all results and their summary, in the -report JSON format, to
+// rawURL. Failed tries are retried with a doubling delay, up to pushAttempts in total.
+func PushResults(ctx context.Context, rawURL string, results []icmptest.TestResult) error {
+	u, err := config.ParsePushURL(rawURL)
+	if err != nil {
+		return fmt.Errorf("invalid push_url %q: %v", rawURL, err)
+	}
+	body, err := json.Marshal(report{Summary: Summarize(results), Results: results})
+	if err != nil {
+		return fmt.Errorf("JSON marshal error: %w", err)
+	}
+	return deliver(ctx, u, body)
+}
+
+// deliver sends body to u, retrying failed tries with a doubling delay up to
+// pushAttempts in total. Rejections (4xx) are not retried.
+func deliver(ctx context.Context, u *url.URL, body []byte) error {
+	var err error
+	delay := pushRetryDelay
+	for attempt := 1; ; attempt++ {
+		err = pushOnce(ctx, u, body)
+		if err == nil || attempt == pushAttempts {
+			return err
+		}
+		if _, permanent := err.(pushRejectedError); permanent {
+			return err
+		}
+		slog.Warn("push failed, retrying", "url", u.Redacted(), "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(delay):
+		}
+		delay *= 2
+	}
+}
+
+// pushRejectedError is returned when the collector answers with a 4xx status, which a
+// retry would not change.
+type pushRejectedError struct {
+	status string
+}
+
+func (e pushRejectedError) Error() string {
+	return "collector rejected results: " + e.status
+}
+
+// pushOnce makes one attempt at delivering body to u.
+func pushOnce(ctx context.Context, u *url.URL, body []byte) error {
+	ctx, cancel := context.WithTimeout(ctx, pushTimeout)
+	defer cancel()
+
+	if u.Scheme == "http" || u.Scheme == "https" {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		io.Copy(io.Discard, resp.Body)
+		switch {
+		case resp.StatusCode >= 200 && resp.StatusCode < 300:
+			return nil
+		case resp.StatusCode >= 400 && resp.StatusCode < 500:
+			return pushRejectedError{status: resp.Status}
+		}
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+
+	address := u.Host
+	if u.Scheme == "unix" {
+		address = u.Path
+	}
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, u.Scheme, address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetWriteDeadline(deadline)
+	}
+	if _, err := conn.Write(append(body, '\n')); err != nil {
+		return err
+	}
+	return conn.Close()
+}
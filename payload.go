@@ -0,0 +1,72 @@
+package icmptest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"time"
+)
+
+// payloadPreset reproduces the echo data of a ping implementation, for targets that only
+// answer requests that look like they came from a particular tool.
+type payloadPreset struct {
+	defaultSize int                                  // payload_size used when the test sets none
+	build       func(size int, now time.Time) []byte // now is the send time, for presets carrying one
+}
+
+// payloadPresets are the values of payload_preset (see config.PayloadPresets).
+var payloadPresets = map[string]payloadPreset{
+	// The pattern this tool has always sent.
+	"icmp-test": {32, func(size int, _ time.Time) []byte {
+		return buildPayload(size, defaultPayloadPattern)
+	}},
+	// iputils ping: a struct timeval (64-bit seconds and microseconds, in the byte order
+	// of a little-endian host) followed by bytes counting up from 0x10, so byte i is i.
+	// Payloads shorter than the timeval carry only the counting bytes.
+	"linux-ping": {56, func(size int, now time.Time) []byte {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i)
+		}
+		if size >= 16 {
+			binary.LittleEndian.PutUint64(data[0:8], uint64(now.Unix()))
+			binary.LittleEndian.PutUint64(data[8:16], uint64(now.Nanosecond()/1000))
+		}
+		return data
+	}},
+	// Windows ping.exe: "abcdefghijklmnopqrstuvw" repeated.
+	"windows-ping": {32, func(size int, _ time.Time) []byte {
+		return buildPayload(size, "abcdefghijklmnopqrstuvw")
+	}},
+}
+
+// checksumMarkerLen is the size of the CRC32 that verify_checksum stores in the last bytes
+// of an echo payload, after the bytes it covers.
+const checksumMarkerLen = 4
+
+// minChecksumPayload is the smallest payload_size verify_checksum accepts, leaving at
+// least as many covered bytes as marker bytes.
+const minChecksumPayload = 2 * checksumMarkerLen
+
+// addChecksumMarker overwrites the last bytes of data with the CRC32 (IEEE) of the bytes
+// before them. data must hold at least minChecksumPayload bytes.
+func addChecksumMarker(data []byte) {
+	n := len(data) - checksumMarkerLen
+	binary.BigEndian.PutUint32(data[n:], crc32.ChecksumIEEE(data[:n]))
+}
+
+// checkChecksumMarker checks the data of an echo reply to a request whose payload of size
+// bytes carried a checksum marker, telling truncation apart from corruption.
+func checkChecksumMarker(data []byte, size int) error {
+	if len(data) < size {
+		return fmt.Errorf("reply payload truncated to %d of %d bytes", len(data), size)
+	}
+	if len(data) > size {
+		return fmt.Errorf("reply payload has %d bytes, %d more than the request", len(data), len(data)-size)
+	}
+	n := size - checksumMarkerLen
+	if want, got := binary.BigEndian.Uint32(data[n:]), crc32.ChecksumIEEE(data[:n]); got != want {
+		return fmt.Errorf("reply payload corrupted: CRC32 is %08x, marker says %08x", got, want)
+	}
+	return nil
+}
@@ -0,0 +1,167 @@
+package icmptest
+
+import (
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/2matzzz/icmp-test/config"
+	"golang.org/x/net/ipv4"
+)
+
+const (
+	pcapMagic        = 0xa1b2c3d4 // microsecond-resolution timestamps
+	pcapVersionMajor = 2
+	pcapVersionMinor = 4
+	pcapSnapLen      = 65535
+	pcapLinkTypeRaw  = 101 // LINKTYPE_RAW: packets begin with an IP header
+)
+
+// packetCapture records sent and received packets once CapturePackets is called; nil otherwise.
+var packetCapture *pcapWriter
+
+// CapturePackets records every packet sent and received from now on to w as a pcap
+// file, starting with the pcap file header. A nil w stops capturing.
+func CapturePackets(w io.Writer) error {
+	if w == nil {
+		packetCapture = nil
+		return nil
+	}
+	pw, err := newPcapWriter(w)
+	if err != nil {
+		return err
+	}
+	packetCapture = pw
+	return nil
+}
+
+// recordSent logs a hex dump of the request b to dst at debug level and adds it to the
+// packet capture, if one is running. ttl is the TTL set on the sending socket.
+func recordSent(ctx context.Context, cfg *config.Config, test Test, dst *net.IPAddr, ttl int, b []byte) {
+	debugDump(ctx, "sent packet", b, "test", test.Name, "dst", dst)
+	if packetCapture == nil {
+		return
+	}
+	src := cfg.General.SourceIPAddress
+	if cfg.General.SpoofSource != nil {
+		src = cfg.General.SpoofSource
+	}
+	if err := packetCapture.WritePacket(time.Now(), src, dst.IP,
+		cfg.General.TOS, ttl, cfg.General.SetDFBit, b); err != nil {
+		logger.Warn("failed to capture sent packet", "test", test.Name, "error", err)
+	}
+}
+
+// recordReceived logs a hex dump of the message b from peer at debug level and adds it
+// to the packet capture, if one is running. rcm may be nil.
+func recordReceived(ctx context.Context, cfg *config.Config, test Test, peer net.Addr, rcm *ipv4.ControlMessage, b []byte) {
+	debugDump(ctx, "received packet", b, "test", test.Name, "peer", peer)
+	if packetCapture == nil {
+		return
+	}
+	var ttl int
+	if rcm != nil {
+		ttl = rcm.TTL
+	}
+	if peerAddr, ok := peer.(*net.IPAddr); ok {
+		if err := packetCapture.WritePacket(time.Now(), peerAddr.IP, cfg.General.SourceIPAddress,
+			0, ttl, false, b); err != nil {
+			logger.Warn("failed to capture received packet", "test", test.Name, "error", err)
+		}
+	}
+}
+
+// pcapWriter writes packets in the classic libpcap file format.
+// Since the tool works at the ICMP layer, the IPv4 header of each record is synthesized.
+type pcapWriter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// newPcapWriter writes the pcap global header to w and returns a writer for packet records.
+func newPcapWriter(w io.Writer) (*pcapWriter, error) {
+	hdr := make([]byte, 24)
+	binary.LittleEndian.PutUint32(hdr[0:4], pcapMagic)
+	binary.LittleEndian.PutUint16(hdr[4:6], pcapVersionMajor)
+	binary.LittleEndian.PutUint16(hdr[6:8], pcapVersionMinor)
+	// hdr[8:16]: thiszone and sigfigs are always zero
+	binary.LittleEndian.PutUint32(hdr[16:20], pcapSnapLen)
+	binary.LittleEndian.PutUint32(hdr[20:24], pcapLinkTypeRaw)
+	if _, err := w.Write(hdr); err != nil {
+		return nil, fmt.Errorf("pcap header write error: %w", err)
+	}
+	return &pcapWriter{w: w}, nil
+}
+
+// WritePacket records an ICMP message as an IPv4 packet from src to dst captured at ts.
+func (p *pcapWriter) WritePacket(ts time.Time, src, dst net.IP, tos, ttl int, df bool, icmpData []byte) error {
+	pkt, err := buildIPv4Packet(src, dst, tos, ttl, df, icmpData)
+	if err != nil {
+		return err
+	}
+	if len(pkt) > pcapSnapLen {
+		return fmt.Errorf("packet of %d bytes exceeds pcap snaplen %d", len(pkt), pcapSnapLen)
+	}
+
+	rec := make([]byte, 16)
+	binary.LittleEndian.PutUint32(rec[0:4], uint32(ts.Unix()))
+	binary.LittleEndian.PutUint32(rec[4:8], uint32(ts.Nanosecond()/1000))
+	binary.LittleEndian.PutUint32(rec[8:12], uint32(len(pkt)))
+	binary.LittleEndian.PutUint32(rec[12:16], uint32(len(pkt)))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, err := p.w.Write(rec); err != nil {
+		return fmt.Errorf("pcap record write error: %w", err)
+	}
+	if _, err := p.w.Write(pkt); err != nil {
+		return fmt.Errorf("pcap record write error: %w", err)
+	}
+	return nil
+}
+
+// buildIPv4Packet prepends a synthesized 20-byte IPv4 header (protocol ICMP) to icmpData.
+func buildIPv4Packet(src, dst net.IP, tos, ttl int, df bool, icmpData []byte) ([]byte, error) {
+	src4, dst4 := src.To4(), dst.To4()
+	if src4 == nil || dst4 == nil {
+		return nil, fmt.Errorf("pcap: IPv4 source and destination required (got %v -> %v)", src, dst)
+	}
+	totalLen := ipv4HeaderLen + len(icmpData)
+	if totalLen > 0xffff {
+		return nil, fmt.Errorf("pcap: packet length %d exceeds IPv4 maximum", totalLen)
+	}
+
+	pkt := make([]byte, totalLen)
+	pkt[0] = 0x45 // version 4, IHL 5
+	pkt[1] = byte(tos)
+	binary.BigEndian.PutUint16(pkt[2:4], uint16(totalLen))
+	if df {
+		pkt[6] = 0x40
+	}
+	pkt[8] = byte(ttl)
+	pkt[9] = 1 // ICMP
+	copy(pkt[12:16], src4)
+	copy(pkt[16:20], dst4)
+	binary.BigEndian.PutUint16(pkt[10:12], ipv4Checksum(pkt[:ipv4HeaderLen]))
+	copy(pkt[ipv4HeaderLen:], icmpData)
+	return pkt, nil
+}
+
+// ipv4Checksum computes the Internet checksum (RFC 1071) over b.
+func ipv4Checksum(b []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(b); i += 2 {
+		sum += uint32(b[i])<<8 | uint32(b[i+1])
+	}
+	if len(b)%2 == 1 {
+		sum += uint32(b[len(b)-1]) << 8
+	}
+	for sum>>16 != 0 {
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+	return ^uint16(sum)
+}
@@ -0,0 +1,42 @@
+//go:build linux
+
+package icmptest
+
+import (
+	"bufio"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// capNetRaw is the Linux capability number of CAP_NET_RAW.
+const capNetRaw = 13
+
+// HasRawSocketPrivilege reports whether the process may open raw ICMP sockets: it runs
+// as root or has CAP_NET_RAW in its effective capability set.
+func HasRawSocketPrivilege() bool {
+	if os.Geteuid() == 0 {
+		return true
+	}
+	f, err := os.Open("/proc/self/status")
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if !strings.HasPrefix(line, "CapEff:") {
+			continue
+		}
+		caps, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "CapEff:")), 16, 64)
+		if err != nil {
+			return false
+		}
+		return caps&(1<<capNetRaw) != 0
+	}
+	return false
+}
+
+// PrivilegeRemedy describes how to obtain the privileges raw ICMP sockets need.
+const PrivilegeRemedy = "run as root (e.g. with sudo) or grant the binary CAP_NET_RAW: sudo setcap cap_net_raw+ep ./icmp-test"
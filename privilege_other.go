@@ -0,0 +1,14 @@
+//go:build !linux
+
+package icmptest
+
+import "os"
+
+// HasRawSocketPrivilege reports whether the process may open raw ICMP sockets.
+// Outside Linux that requires running as root.
+func HasRawSocketPrivilege() bool {
+	return os.Geteuid() == 0
+}
+
+// PrivilegeRemedy describes how to obtain the privileges raw ICMP sockets need.
+const PrivilegeRemedy = "run as root (e.g. with sudo)"
@@ -0,0 +1,80 @@
+package icmptest
+
+import (
+	"math/rand"
+	"time"
+)
+
+// rttSamples accumulates the RTTs of a test's replies. The count, min/avg/max and jitter
+// (the mean absolute difference between consecutive samples, RFC 3550 style without
+// smoothing) are updated as samples arrive and are exact. Percentiles are computed from
+// the kept samples: all of them, or with a cap, a uniform random sample of that size
+// (reservoir sampling), so memory stays bounded however many replies arrive.
+type rttSamples struct {
+	n         int
+	sum       time.Duration
+	min, max  time.Duration
+	prev      time.Duration
+	diffSum   time.Duration
+	kept      []time.Duration
+	keepLimit int // 0 = keep every sample
+}
+
+// newRTTSamples returns an empty accumulator keeping at most limit samples for
+// percentiles; limit <= 0 keeps all of them.
+func newRTTSamples(limit int) *rttSamples {
+	if limit < 0 {
+		limit = 0
+	}
+	return &rttSamples{keepLimit: limit}
+}
+
+// add records one RTT.
+func (s *rttSamples) add(rtt time.Duration) {
+	if s.n == 0 || rtt < s.min {
+		s.min = rtt
+	}
+	if rtt > s.max {
+		s.max = rtt
+	}
+	if s.n > 0 {
+		diff := rtt - s.prev
+		if diff < 0 {
+			diff = -diff
+		}
+		s.diffSum += diff
+	}
+	s.prev = rtt
+	s.sum += rtt
+	s.n++
+
+	// Algorithm R: once the reservoir is full, the n-th sample replaces a random kept
+	// one with probability limit/n.
+	if s.keepLimit == 0 || len(s.kept) < s.keepLimit {
+		s.kept = append(s.kept, rtt)
+	} else if i := rand.Intn(s.n); i < s.keepLimit {
+		s.kept[i] = rtt
+	}
+}
+
+// count returns the number of samples added.
+func (s *rttSamples) count() int {
+	return s.n
+}
+
+// summary returns the min, average and max of all samples, plus the jitter. All values
+// are zero without samples; jitter is zero for fewer than two.
+func (s *rttSamples) summary() (min, avg, max, jitter time.Duration) {
+	if s.n == 0 {
+		return 0, 0, 0, 0
+	}
+	if s.n > 1 {
+		jitter = s.diffSum / time.Duration(s.n-1)
+	}
+	return s.min, s.sum / time.Duration(s.n), s.max, jitter
+}
+
+// sampled reports whether percentiles are estimated from a subset of the samples.
+func (s *rttSamples) sampled() bool {
+	return s.n > len(s.kept)
+}
@@ -0,0 +1,92 @@
+//go:build linux
+
+package icmptest
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"syscall"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// enableRXTimestamps applies SO_TIMESTAMPNS to conn so that the kernel attaches the
+// time each packet was received to it as a control message.
+func enableRXTimestamps(conn *net.IPConn) error {
+	rawConn, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var sockErr error
+	if err := rawConn.Control(func(fd uintptr) {
+		sockErr = syscall.SetsockoptInt(int(fd), syscall.SOL_SOCKET, syscall.SO_TIMESTAMPNS, 1)
+	}); err != nil {
+		return err
+	}
+	if sockErr != nil {
+		return fmt.Errorf("SO_TIMESTAMPNS: %v", sockErr)
+	}
+	return nil
+}
+
+// readFromWithTimestamp reads one packet like pconn.ReadFrom and also returns the time the
+// kernel received it. The time is zero if the packet carried no SO_TIMESTAMPNS control message.
+func readFromWithTimestamp(pconn *ipv4.PacketConn, b []byte) (int, *ipv4.ControlMessage, net.Addr, time.Time, error) {
+	h := make([]byte, ipv4.HeaderLen)
+	ms := []ipv4.Message{{
+		Buffers: [][]byte{h, b},
+		OOB:     make([]byte, len(ipv4.NewControlMessage(ipv4.FlagTTL|ipv4.FlagDst|ipv4.FlagInterface))+syscall.CmsgSpace(16)),
+	}}
+	if _, err := pconn.ReadBatch(ms, 0); err != nil {
+		return 0, nil, nil, time.Time{}, err
+	}
+	m := ms[0]
+
+	// Raw sockets return the IP header in front of the ICMP message; strip it as
+	// ReadFrom does.
+	n := m.N
+	if hdrlen := int(h[0]&0x0f) << 2; hdrlen > len(h) {
+		d := hdrlen - len(h)
+		copy(b, b[d:])
+		n -= d
+	} else {
+		n -= hdrlen
+	}
+
+	var cm *ipv4.ControlMessage
+	var rx time.Time
+	if m.NN > 0 {
+		cm = new(ipv4.ControlMessage)
+		if err := cm.Parse(m.OOB[:m.NN]); err != nil {
+			return 0, nil, nil, time.Time{}, err
+		}
+		scms, err := syscall.ParseSocketControlMessage(m.OOB[:m.NN])
+		if err != nil {
+			return 0, nil, nil, time.Time{}, err
+		}
+		for _, scm := range scms {
+			if scm.Header.Level == syscall.SOL_SOCKET && scm.Header.Type == syscall.SCM_TIMESTAMPNS {
+				rx = parseTimespec(scm.Data)
+			}
+		}
+	}
+	return n, cm, m.Addr, rx, nil
+}
+
+// parseTimespec decodes a struct timespec in native byte order, as the kernel writes it.
+func parseTimespec(data []byte) time.Time {
+	var sec, nsec int64
+	switch len(data) {
+	case 16:
+		sec = int64(binary.NativeEndian.Uint64(data[0:8]))
+		nsec = int64(binary.NativeEndian.Uint64(data[8:16]))
+	case 8:
+		sec = int64(int32(binary.NativeEndian.Uint32(data[0:4])))
+		nsec = int64(int32(binary.NativeEndian.Uint32(data[4:8])))
+	default:
+		return time.Time{}
+	}
+	return time.Unix(sec, nsec)
+}
@@ -0,0 +1,22 @@
+//go:build !linux
+
+package icmptest
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"golang.org/x/net/ipv4"
+)
+
+// enableRXTimestamps is only implemented on Linux, where SO_TIMESTAMPNS exists.
+func enableRXTimestamps(conn *net.IPConn) error {
+	return fmt.Errorf("rx_timestamp is only supported on Linux")
+}
+
+// readFromWithTimestamp falls back to pconn.ReadFrom and returns a zero receive time.
+func readFromWithTimestamp(pconn *ipv4.PacketConn, b []byte) (int, *ipv4.ControlMessage, net.Addr, time.Time, error) {
+	n, cm, peer, err := pconn.ReadFrom(b)
+	return n, cm, peer, time.Time{}, err
+}
@@ -0,0 +1,84 @@
+package icmptest
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/2matzzz/icmp-test/config"
+	"golang.org/x/net/ipv4"
+)
+
+// packetWriter sends an ICMP message to dst; *ipv4.PacketConn and *spoofedSender
+// implement it.
+type packetWriter interface {
+	WriteTo(b []byte, cm *ipv4.ControlMessage, dst net.Addr) (int, error)
+}
+
+// spoofedSender sends ICMP messages behind an IPv4 header built here rather than by the
+// kernel (IP_HDRINCL), so that the source can be general.spoof_source, an address the
+// host need not own. It is for testing RPF and anti-spoofing filters in a lab; replies
+// go to the spoofed address and reach this host only if the lab routes them back.
+type spoofedSender struct {
+	conn *ipv4.RawConn
+	src  net.IP
+	tos  int
+	ttl  int
+	df   bool
+}
+
+// openSpoofedSender opens a raw socket with IP_HDRINCL for test's requests, with the same
+// vrf, bind_to_device and fwmark options as the receiving socket. It needs root
+// (CAP_NET_RAW) on every platform.
+func openSpoofedSender(cfg *config.Config, test Test) (*spoofedSender, error) {
+	c, err := net.ListenPacket("ip4:icmp", "0.0.0.0")
+	if err != nil {
+		return nil, fmt.Errorf("spoof_source: ListenPacket failed: %v", err)
+	}
+	// Requests must take the same route as those of tests without spoof_source.
+	if err := applyRoutingOptions(cfg, test, c.(*net.IPConn)); err != nil {
+		c.Close()
+		return nil, fmt.Errorf("spoof_source: %v", err)
+	}
+	conn, err := ipv4.NewRawConn(c)
+	if err != nil {
+		c.Close()
+		return nil, fmt.Errorf("spoof_source: IP_HDRINCL failed: %v", err)
+	}
+	ttl := test.TTL
+	if ttl == 0 {
+		ttl = 64
+	}
+	logger.Debug("socket option set", "test", test.Name, "option", "IP_HDRINCL", "value", 1, "spoof_source", cfg.General.SpoofSource)
+	return &spoofedSender{conn: conn, src: cfg.General.SpoofSource, tos: cfg.General.TOS, ttl: ttl, df: cfg.General.SetDFBit}, nil
+}
+
+// WriteTo sends b to dst from s.src. The kernel fills in the IP ID and header checksum.
+// The control message is ignored: the route to dst picks the interface.
+func (s *spoofedSender) WriteTo(b []byte, _ *ipv4.ControlMessage, dst net.Addr) (int, error) {
+	ipAddr, ok := dst.(*net.IPAddr)
+	if !ok {
+		return 0, fmt.Errorf("spoof_source: unsupported destination %v", dst)
+	}
+	h := &ipv4.Header{
+		Version:  ipv4.Version,
+		Len:      ipv4.HeaderLen,
+		TOS:      s.tos,
+		TotalLen: ipv4.HeaderLen + len(b),
+		TTL:      s.ttl,
+		Protocol: 1,
+		Src:      s.src,
+		Dst:      ipAddr.IP,
+	}
+	if s.df {
+		h.Flags = ipv4.DontFragment
+	}
+	if err := s.conn.WriteTo(h, b, nil); err != nil {
+		return 0, err
+	}
+	return len(b), nil
+}
+
+// Close closes the raw socket.
+func (s *spoofedSender) Close() error {
+	return s.conn.Close()
+}